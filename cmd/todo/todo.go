@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/api"
@@ -71,7 +74,13 @@ func main() {
 	// Get database type from configuration
 	dbType := cfg.DbType
 
+	tenantDir := cfg.TenantDir
+	if tenantDir == "" {
+		tenantDir = "tenants"
+	}
+
 	var repo repository.Repository
+	var tenants repository.TenantStore
 	if dbType == "json" {
 		dbFile, err := os.OpenFile(*dbFileFlag, os.O_CREATE|os.O_RDWR, 0777)
 		if err != nil {
@@ -81,23 +90,57 @@ func main() {
 		if err != nil {
 			logrus.WithError(err).Fatal("Could not create json repository")
 		}
+		tenants, err = repository.NewJSONTenantStore(tenantDir)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open tenant directory")
+		}
 	} else if dbType == "mongo" {
 		// Create MongoDB client
 		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.MongoAddr))
 		if err != nil {
 			logrus.WithError(err).Fatal("Could not connect to MongoDB")
 		}
-		defer client.Disconnect(context.Background())
-		repo, err = repository.New(client)
+		database := cfg.MongoDatabase
+		if database == "" {
+			database = "todo"
+		}
+		repo, err = repository.NewMongoRepository(client, database, "todos")
 		if err != nil {
 			logrus.WithError(err).Fatal("Could not create mongo repository")
 		}
+		tenants = repository.NewMongoTenantStore(client, database)
+	} else if dbType == "sqlite" {
+		sqlitePath := cfg.SqlitePath
+		if sqlitePath == "" {
+			sqlitePath = "todo.db"
+		}
+		db, err := sql.Open("sqlite", sqlitePath)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open sqlite database")
+		}
+		repo, err = repository.New(db)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not create sqlite repository")
+		}
+		tenants, err = repository.NewSQLiteTenantStore(tenantDir)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not open tenant directory")
+		}
 	} else {
 		logrus.Fatal("Unsupported database type")
 	}
 
+	// Every tenant has a workspace of its own next to the default one
+	tenantRepo := repository.NewTenantRepository(repo, tenants)
+
 	// Create new todo app
-	appInstance := app.New(repo)
+	appInstance := app.New(tenantRepo)
+	appInstance.MaxDepth = cfg.MaxDepth
+	appInstance.Tenants = tenantRepo
+	appInstance.OwnerlessUser = cfg.OwnerlessUser
+	if _, err := appInstance.AssignOwnerless(context.Background()); err != nil {
+		logrus.WithError(err).Error("Could not assign the todos and projects without owner")
+	}
 
 	// Create new api
 	apiInstance, err := api.New(&cfg, appInstance)
@@ -105,8 +148,30 @@ func main() {
 		panic(err)
 	}
 
-	if err := apiInstance.Start(); err != nil {
-		logrus.WithError(err).Fatal("Could not start api")
+	// Serve until the server fails or a termination signal arrives
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- apiInstance.Start()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		logrus.WithError(err).Error("Could not start api")
+		exitCode = 1
+	case <-ctx.Done():
+		logrus.Info("Received termination signal, shutting down...")
+	}
+	// A second signal terminates immediately
+	stop()
+
+	if err := apiInstance.Shutdown(); err != nil {
+		logrus.WithError(err).Error("Could not shut down cleanly")
+		exitCode = 1
 	}
 
+	os.Exit(exitCode)
 }
@@ -2,21 +2,69 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/app"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
 )
 
 // API configuration
 type Config struct {
-	Addr      string `yaml:"addr"`
-	DbType    string `yaml:"dbtype"`
-	MongoAddr string `yaml:"mongoaddr"`
+	Addr       string `yaml:"addr"`
+	DbType     string `yaml:"dbtype"`
+	MongoAddr  string `yaml:"mongoaddr"`
+	SqlitePath string `yaml:"sqlitepath"`
+	// RequestTimeout bounds the database work of a single request, e.g. "5s". Zero means no limit.
+	RequestTimeout time.Duration `yaml:"requesttimeout"`
+	// ShutdownTimeout bounds the time running requests get to finish on shutdown. Defaults to 5s.
+	ShutdownTimeout time.Duration `yaml:"shutdowntimeout"`
+	// MaxLimit is the largest page size clients may ask for. Defaults to 100.
+	MaxLimit int `yaml:"maxlimit"`
+	// MaxDepth is how deep subtasks nest below a top-level todo. Defaults to 3.
+	MaxDepth int `yaml:"maxdepth"`
+	// OwnerlessUser is the name of the user who gets the todos and projects stored before user accounts
+	// existed, on startup or when the user registers. Empty leaves them to no one.
+	OwnerlessUser string `yaml:"ownerlessuser"`
+	// AuthSecret signs the session tokens and is at least 32 bytes long. Without it a random secret is
+	// used, which signs everyone out when the server restarts.
+	AuthSecret string `yaml:"authsecret"`
+	// TokenTTL is how long a session token is valid, e.g. "12h". Defaults to 24h.
+	TokenTTL time.Duration `yaml:"tokenttl"`
+	// MongoDatabase is the database of the default workspace. Defaults to "todo".
+	MongoDatabase string `yaml:"mongodatabase"`
+	// TenantDir is the directory of the db files of the tenants with the json and sqlite databases.
+	// Defaults to "tenants".
+	TenantDir string `yaml:"tenantdir"`
+	// TenantHeader is the request header naming the tenant. Defaults to "X-Tenant-ID".
+	TenantHeader string `yaml:"tenantheader"`
+	// TenantDomain is the domain whose subdomains name the tenant, e.g. "todo.example.com" for
+	// acme.todo.example.com. Empty disables tenants by subdomain.
+	TenantDomain string `yaml:"tenantdomain"`
+	// AdminKey authorizes the tenant administration endpoints. Without it they are disabled.
+	AdminKey string `yaml:"adminkey"`
+	// RateLimits limits the requests of each client per route group: "auth" for signing up and in, "api" for
+	// the routes that need a token or API key and "admin" for tenant administration. Without one a group
+	// is not limited.
+	RateLimits map[string]RateLimit `yaml:"ratelimits"`
+	// CORS is the policy for calls from web pages of other origins
+	CORS CORSConfig `yaml:"cors"`
 }
 
+const (
+	defaultShutdownTimeout = 5 * time.Second
+	defaultTokenTTL        = 24 * time.Hour
+	defaultTenantHeader    = "X-Tenant-ID"
+)
+
 type API struct {
 	Router *mux.Router
 
@@ -24,32 +72,162 @@ type API struct {
 
 	app *app.App
 
+	// signer issues and verifies the session tokens
+	signer *auth.Signer
+
+	cors *corsPolicy
+
 	httpServer *http.Server
+
+	shutdownOnce sync.Once
+	shutdownErr  error
 }
 
 // New returns the api settings
 func New(config *Config, app *app.App) (*API, error) {
 
+	secret := []byte(config.AuthSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, auth.MinSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		logrus.Warn("No authsecret is configured, sessions end when the server restarts")
+	}
+	signer, err := auth.NewSigner(secret)
+	if err != nil {
+		return nil, fmt.Errorf("authsecret: %w", err)
+	}
+
+	tenantHeader := config.TenantHeader
+	if tenantHeader == "" {
+		tenantHeader = defaultTenantHeader
+	}
+	cors, err := newCORSPolicy(config.CORS, tenantHeader)
+	if err != nil {
+		return nil, fmt.Errorf("cors: %w", err)
+	}
+
 	router := mux.NewRouter()
 	api := &API{
 		config: config,
 		app:    app,
+		signer: signer,
+		cors:   cors,
 		Router: router,
 	}
+	api.httpServer = &http.Server{
+		Addr:    config.Addr,
+		Handler: router,
+	}
+
+	limits := map[string]mux.MiddlewareFunc{}
+	for _, group := range []string{groupAuth, groupAPI, groupAdmin} {
+		if limits[group], err = api.rateLimitMiddleware(group); err != nil {
+			return nil, fmt.Errorf("ratelimits.%s: %w", group, err)
+		}
+	}
+	for group := range config.RateLimits {
+		if limits[group] == nil {
+			return nil, fmt.Errorf("ratelimits: unknown route group %q", group)
+		}
+	}
+
+	// Middleware of every request, outermost first. mux only applies it to matched routes, so the handlers
+	// of requests matching none are wrapped in it too.
+	middleware := []mux.MiddlewareFunc{api.requestIDMiddleware, api.logMiddleware, api.recoveryMiddleware, api.corsMiddleware, api.compressMiddleware, api.timeoutMiddleware}
+	api.Router.Use(middleware...)
+	api.Router.NotFoundHandler = wrapMiddleware(http.HandlerFunc(api.unmatchedHandler), middleware)
+	api.Router.MethodNotAllowedHandler = api.Router.NotFoundHandler
 
-	// Endpoint for browser preflight requests
-	api.Router.Methods("OPTIONS").HandlerFunc(api.corsMiddleware(api.preflightHandler))
+	// Endpoint for OPTIONS requests, preflight requests are answered by corsMiddleware
+	api.Router.Methods("OPTIONS").HandlerFunc(api.preflightHandler)
+
+	v1 := api.Router.PathPrefix("/api/v1").Subrouter()
+	// Signing up and in, to the tenant the request names
+	public := v1.NewRoute().Subrouter()
+	public.Use(limits[groupAuth], api.tenantMiddleware)
+	// Everything else needs a session token or API key
+	authed := v1.NewRoute().Subrouter()
+	authed.Use(limits[groupAPI], api.tenantMiddleware, api.authMiddleware)
+	keys := authed.NewRoute().Subrouter()
+	keys.Use(api.scopeMiddleware(model.ScopeAdmin))
+	// Tenant administration needs the admin key
+	admin := v1.PathPrefix("/admin").Subrouter()
+	admin.Use(limits[groupAdmin], api.adminMiddleware)
+
+	// Users
+	public.HandleFunc("/auth/register", api.Register).Methods("POST")
+	public.HandleFunc("/auth/login", api.Login).Methods("POST")
+	authed.HandleFunc("/auth/me", api.GetCurrentUser).Methods("GET")
+
+	// API keys, which only session tokens and admin keys may manage
+	keys.HandleFunc("/keys", api.GetAPIKeys).Methods("GET")
+	keys.HandleFunc("/keys", api.AddAPIKey).Methods("POST")
+	keys.HandleFunc("/keys/{id}", api.DeleteAPIKey).Methods("DELETE")
 
 	// Get All
-	api.Router.HandleFunc("/api/v1/todos", api.corsMiddleware(api.logMiddleware(api.GetTodos))).Methods("GET")
+	authed.HandleFunc("/todos", api.GetTodos).Methods("GET")
 	// Get By Id
-	api.Router.HandleFunc("/api/v1/todos/{id}", api.corsMiddleware(api.logMiddleware(api.GetTodo))).Methods("GET")
+	authed.HandleFunc("/todos/{id}", api.GetTodo).Methods("GET")
 	// Create
-	api.Router.HandleFunc("/api/v1/todos", api.corsMiddleware(api.logMiddleware(api.AddTodo))).Methods("POST")
+	authed.HandleFunc("/todos", api.AddTodo).Methods("POST")
 	// Update
-	api.Router.HandleFunc("/api/v1/todos", api.corsMiddleware(api.logMiddleware(api.UpdateTodo))).Methods("PUT")
+	authed.HandleFunc("/todos", api.UpdateTodo).Methods("PUT")
+	authed.HandleFunc("/todos/{id}", api.UpdateTodo).Methods("PUT")
+	// Partial update
+	authed.HandleFunc("/todos/{id}", api.PatchTodo).Methods("PATCH")
 	// Delete
-	api.Router.HandleFunc("/api/v1/todos/{id}", api.corsMiddleware(api.logMiddleware(api.DeleteTodo))).Methods("DELETE")
+	authed.HandleFunc("/todos/{id}", api.DeleteTodo).Methods("DELETE")
+	// Complete
+	authed.HandleFunc("/todos/{id}/complete", api.CompleteTodo).Methods("POST")
+	// Reopen
+	authed.HandleFunc("/todos/{id}/reopen", api.ReopenTodo).Methods("POST")
+
+	// Checklist
+	authed.HandleFunc("/todos/{id}/checklist", api.AddChecklistItem).Methods("POST")
+	authed.HandleFunc("/todos/{id}/checklist/order", api.ReorderChecklist).Methods("PUT")
+	authed.HandleFunc("/todos/{id}/checklist/{item}", api.PatchChecklistItem).Methods("PATCH")
+	authed.HandleFunc("/todos/{id}/checklist/{item}", api.DeleteChecklistItem).Methods("DELETE")
+
+	// Recurrence
+	authed.HandleFunc("/todos/{id}/recurrence", api.GetRecurrence).Methods("GET")
+	authed.HandleFunc("/todos/{id}/recurrence", api.EndRecurrence).Methods("DELETE")
+	authed.HandleFunc("/todos/{id}/recurrence/skip", api.SkipOccurrence).Methods("POST")
+
+	// Comments
+	authed.HandleFunc("/todos/{id}/comments", api.AddComment).Methods("POST")
+	authed.HandleFunc("/todos/{id}/comments/{comment}", api.DeleteComment).Methods("DELETE")
+
+	// Tags
+	authed.HandleFunc("/tags", api.GetTags).Methods("GET")
+	authed.HandleFunc("/tags/merge", api.MergeTags).Methods("POST")
+	authed.HandleFunc("/tags/{tag}/rename", api.RenameTag).Methods("POST")
+
+	// Projects
+	authed.HandleFunc("/projects", api.GetProjects).Methods("GET")
+	authed.HandleFunc("/projects", api.AddProject).Methods("POST")
+	authed.HandleFunc("/projects/{id}", api.GetProject).Methods("GET")
+	authed.HandleFunc("/projects/{id}", api.UpdateProject).Methods("PUT")
+	authed.HandleFunc("/projects/{id}", api.DeleteProject).Methods("DELETE")
+	authed.HandleFunc("/projects/{id}/archive", api.ArchiveProject).Methods("POST")
+	authed.HandleFunc("/projects/{id}/unarchive", api.UnarchiveProject).Methods("POST")
+	authed.HandleFunc("/projects/{id}/todos", api.GetProjectTodos).Methods("GET")
+
+	// Sharing
+	authed.HandleFunc("/projects/{id}/shares", api.GetProjectShares).Methods("GET")
+	authed.HandleFunc("/projects/{id}/shares", api.ShareProject).Methods("POST")
+	authed.HandleFunc("/todos/{id}/shares", api.GetTodoShares).Methods("GET")
+	authed.HandleFunc("/todos/{id}/shares", api.ShareTodo).Methods("POST")
+	authed.HandleFunc("/shares", api.GetShares).Methods("GET")
+	authed.HandleFunc("/shares/{id}", api.PatchShare).Methods("PATCH")
+	authed.HandleFunc("/shares/{id}", api.DeleteShare).Methods("DELETE")
+	authed.HandleFunc("/shares/{id}/accept", api.AcceptShare).Methods("POST")
+
+	// Tenant administration
+	admin.HandleFunc("/tenants", api.GetTenants).Methods("GET")
+	admin.HandleFunc("/tenants", api.AddTenant).Methods("POST")
+	admin.HandleFunc("/tenants/{tenant}", api.DeleteTenant).Methods("DELETE")
 
 	return api, nil
 
@@ -59,33 +237,83 @@ func (a *API) preflightHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (a *API) Start() error {
-	a.httpServer = &http.Server{
-		Addr:    a.config.Addr,
-		Handler: a.Router,
+// unmatchedHandler answers requests no route matches, with 405 and the allowed methods when routes match
+// the path with other methods. mux loses such method mismatches in nested subrouters, so the router is
+// asked for each method here.
+func (a *API) unmatchedHandler(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if method == r.Method {
+			continue
+		}
+		other := r.Clone(r.Context())
+		other.Method = method
+		var match mux.RouteMatch
+		if a.Router.Match(other, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	if len(allowed) == 0 {
+		err := fmt.Errorf("no endpoint %s", r.URL.Path)
+		response.Errorf(w, r, err, http.StatusNotFound, err.Error())
+		return
 	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	err := fmt.Errorf("method %s is not allowed for %s", r.Method, r.URL.Path)
+	response.Errorf(w, r, err, http.StatusMethodNotAllowed, err.Error())
+}
+
+// wrapMiddleware wraps h in middleware, the first outermost
+func wrapMiddleware(h http.Handler, middleware []mux.MiddlewareFunc) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
 
+func (a *API) Start() error {
 	err := a.httpServer.ListenAndServe()
 	if err != http.ErrServerClosed {
 		return err
-	} else {
-		return err
 	}
+	return nil
 }
 
-// Shutdown stops the server
+// Shutdown stops accepting requests, waits up to ShutdownTimeout for the running ones
+// and closes the repository. Calling it more than once has no further effect.
 func (a *API) Shutdown() error {
+	a.shutdownOnce.Do(func() {
+		a.shutdownErr = a.shutdown()
+	})
+	return a.shutdownErr
+}
 
-	a.app.Repository.Shutdown()
+func (a *API) shutdown() error {
+	timeout := a.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
 
 	// Shutdown HTTP server
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	err := a.httpServer.Shutdown(ctx)
-	if err != nil {
+
+	serverErr := a.httpServer.Shutdown(ctx)
+	if serverErr != nil {
+		// requests still running lose their connection, their database work is cancelled with it
+		logrus.WithError(serverErr).Warn("Could not drain HTTP requests in time")
+		a.httpServer.Close()
+	}
+	logrus.Info("Shutdown HTTP server...")
+
+	// Close the repository after the last request is done with it
+	repoCtx, repoCancel := context.WithTimeout(context.Background(), timeout)
+	defer repoCancel()
+
+	if err := a.app.Repository.Shutdown(repoCtx); err != nil {
 		return err
 	}
+	logrus.Info("Shutdown repository...")
 
-	logrus.Info("Shutdown HTTP server...")
-	return nil
+	return serverErr
 }
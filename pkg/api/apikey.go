@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// newAPIKey is the body of the request that creates an API key
+type newAPIKey struct {
+	Name  string      `json:"name"`
+	Scope model.Scope `json:"scope"`
+}
+
+// createdAPIKey is a new API key with the key itself, which is not shown again
+type createdAPIKey struct {
+	*model.APIKey
+	Key string `json:"key"`
+}
+
+// GetAPIKeys lists the API keys of the user
+func (a *API) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys, err := a.app.GetAPIKeys(ctx)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, keys)
+}
+
+// AddAPIKey creates an API key. The key is in the response only, it cannot be read later.
+func (a *API) AddAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body newAPIKey
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key, secret, err := a.app.CreateAPIKey(ctx, body.Name, body.Scope)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, createdAPIKey{APIKey: key, Key: secret})
+}
+
+// DeleteAPIKey revokes an API key
+func (a *API) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.app.DeleteAPIKey(ctx, id); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, "OK")
+}
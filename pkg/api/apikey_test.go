@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+func TestAPIKeys(t *testing.T) {
+	a := newTestAPI(t, &Config{})
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		a.Router.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v interface{}) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("want status 200, got %d: %s", w.Code, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	decode(serve("POST", "/api/v1/auth/register", "", `{"name":"ada","password":"correct horse"}`), &session)
+	keys := map[model.Scope]createdAPIKey{}
+	for _, scope := range []model.Scope{model.ScopeRead, model.ScopeWrite} {
+		var key createdAPIKey
+		decode(serve("POST", "/api/v1/keys", session.Token, fmt.Sprintf(`{"name":"script","scope":%q}`, scope)), &key)
+		keys[scope] = key
+	}
+	read, write := keys[model.ScopeRead].Key, keys[model.ScopeWrite].Key
+
+	tests := []struct {
+		name, method, path, key string
+		want                    int
+	}{
+		{"read key reads", "GET", "/api/v1/todos", read, http.StatusOK},
+		{"read key writes", "POST", "/api/v1/todos", read, http.StatusForbidden},
+		{"write key writes", "POST", "/api/v1/todos", write, http.StatusOK},
+		{"write key lists keys", "GET", "/api/v1/keys", write, http.StatusForbidden},
+		{"write key creates a key", "POST", "/api/v1/keys", write, http.StatusForbidden},
+		{"unknown key", "GET", "/api/v1/todos", "todo_unknown", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(tt.method, tt.path, tt.key, `{"title":"by key"}`); w.Code != tt.want {
+				t.Errorf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+
+	t.Run("revoked key", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/keys/%d", keys[model.ScopeRead].ID)
+		if w := serve("DELETE", path, session.Token, ""); w.Code != http.StatusOK {
+			t.Fatalf("revoke: want status 200, got %d: %s", w.Code, w.Body)
+		}
+		if w := serve("GET", "/api/v1/todos", read, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("want status 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("last used", func(t *testing.T) {
+		lastUsed := func() time.Time {
+			t.Helper()
+			var list []*model.APIKey
+			decode(serve("GET", "/api/v1/keys", session.Token, ""), &list)
+			for _, key := range list {
+				if key.ID == keys[model.ScopeWrite].ID && key.LastUsedAt != nil {
+					return *key.LastUsedAt
+				}
+			}
+			t.Fatal("the write key has no last used time")
+			return time.Time{}
+		}
+
+		// uses within a minute of the last recorded one are not recorded
+		first := lastUsed()
+		for i := 0; i < 3; i++ {
+			serve("GET", "/api/v1/todos", write, "")
+		}
+		if got := lastUsed(); !got.Equal(first) {
+			t.Errorf("last used changed from %v to %v within a minute", first, got)
+		}
+
+		later := first.Add(time.Minute)
+		if _, err := a.app.AuthenticateAPIKey(context.Background(), write, later); err != nil {
+			t.Fatal(err)
+		}
+		if got := lastUsed(); !got.Equal(later) {
+			t.Errorf("want last used %v a minute later, got %v", later, got)
+		}
+	})
+}
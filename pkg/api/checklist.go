@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// itemID returns the item path variable of r
+func itemID(r *http.Request) (int, error) {
+	item, ok := mux.Vars(r)["item"]
+	if !ok {
+		return 0, errors.New("item is required")
+	}
+	return strconv.Atoi(item)
+}
+
+// writeChangedTodo writes a todo after a change with its new entity tag
+func writeChangedTodo(w http.ResponseWriter, r *http.Request, todo *model.Todo) {
+	w.Header().Set("ETag", todoETag(todo))
+	response.Write(w, r, todoView{todo})
+}
+
+// AddChecklistItem adds an item to the checklist of a todo, at the end unless a position is given
+func (a *API) AddChecklistItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var add struct {
+		model.ChecklistItem
+		Position *int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&add); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	position := -1
+	if add.Position != nil {
+		position = *add.Position
+	}
+
+	todo, err := a.app.AddChecklistItem(ctx, id, add.ChecklistItem, position, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeChangedTodo(w, r, todo)
+}
+
+// PatchChecklistItem changes the text or done state of a checklist item, whichever the body names
+func (a *API) PatchChecklistItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	item, err := itemID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var patch struct {
+		Text *string `json:"text"`
+		Done *bool   `json:"done"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	todo, err := a.app.ChangeChecklistItem(ctx, id, item, func(item *model.ChecklistItem) {
+		if patch.Text != nil {
+			item.Text = *patch.Text
+		}
+		if patch.Done != nil {
+			item.Done = *patch.Done
+		}
+	}, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeChangedTodo(w, r, todo)
+}
+
+func (a *API) DeleteChecklistItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	item, err := itemID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	todo, err := a.app.RemoveChecklistItem(ctx, id, item, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeChangedTodo(w, r, todo)
+}
+
+// ReorderChecklist puts the checklist items in the order of the ids in the body
+func (a *API) ReorderChecklist(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var order struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	todo, err := a.app.ReorderChecklist(ctx, id, order.IDs, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeChangedTodo(w, r, todo)
+}
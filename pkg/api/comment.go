@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+)
+
+// commentID returns the comment path variable of r
+func commentID(r *http.Request) (int, error) {
+	comment, ok := mux.Vars(r)["comment"]
+	if !ok {
+		return 0, errors.New("comment is required")
+	}
+	return strconv.Atoi(comment)
+}
+
+// AddComment adds a comment to a todo
+func (a *API) AddComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	var add struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&add); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	todo, err := a.app.AddComment(ctx, id, add.Text, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeChangedTodo(w, r, todo)
+}
+
+func (a *API) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	comment, err := commentID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	todo, err := a.app.RemoveComment(ctx, id, comment, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeChangedTodo(w, r, todo)
+}
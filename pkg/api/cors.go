@@ -0,0 +1,252 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+)
+
+// CORSConfig is the cross-origin resource sharing policy, which decides the web pages of which origins may
+// call the API from a browser
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed, e.g. "https://app.example.com". A "*" in place of the
+	// subdomain allows every subdomain, as in "https://*.example.com", and "*" alone any origin.
+	// Without any only pages of the API's own origin may call it.
+	AllowedOrigins []string `yaml:"allowedorigins"`
+	// AllowedMethods are the methods pages may use. Defaults to the methods of the API.
+	AllowedMethods []string `yaml:"allowedmethods"`
+	// AllowedHeaders are the request headers pages may send. "*" allows any header unless credentials
+	// are allowed. Defaults to the headers the API reads.
+	AllowedHeaders []string `yaml:"allowedheaders"`
+	// ExposedHeaders are the response headers pages may read. Defaults to the headers the API sets.
+	ExposedHeaders []string `yaml:"exposedheaders"`
+	// MaxAge is how long browsers may cache a preflight response, e.g. "10m". Zero leaves it to the browser.
+	MaxAge time.Duration `yaml:"maxage"`
+	// AllowCredentials lets pages send cookies and authorization headers. It requires listing the origins.
+	AllowCredentials bool `yaml:"allowcredentials"`
+}
+
+var (
+	defaultAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultAllowedHeaders = []string{"Accept", "Content-Type", "Authorization", "If-Match", "If-None-Match", requestIDHeader}
+	defaultExposedHeaders = []string{"ETag", "Link", "Retry-After", requestIDHeader}
+)
+
+// corsPolicy is a CORSConfig checked and prepared for matching requests
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	// wildcards are the origins allowed with any subdomain, split at the "*"
+	wildcards []originPattern
+
+	methods       map[string]bool
+	anyHeader     bool
+	headers       map[string]bool
+	credentials   bool
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// originPattern matches the origins starting with prefix and ending with suffix, like "https://" and
+// ".example.com"
+type originPattern struct {
+	prefix, suffix string
+}
+
+func (p originPattern) matches(origin string) bool {
+	if len(origin) <= len(p.prefix)+len(p.suffix) || !strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+		return false
+	}
+	// the subdomain may have several labels, but nothing that ends the host
+	sub := origin[len(p.prefix) : len(origin)-len(p.suffix)]
+	return !strings.ContainsAny(sub, "/:@?#") && !strings.HasPrefix(sub, ".") && !strings.HasSuffix(sub, ".")
+}
+
+// newCORSPolicy checks config and fills in the defaults. tenantHeader is allowed by default next to the
+// default headers.
+func newCORSPolicy(config CORSConfig, tenantHeader string) (*corsPolicy, error) {
+	p := &corsPolicy{
+		origins:     map[string]bool{},
+		methods:     map[string]bool{},
+		headers:     map[string]bool{},
+		credentials: config.AllowCredentials,
+	}
+
+	for _, origin := range config.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			scheme, host, ok := strings.Cut(origin, "://")
+			if !ok || !strings.HasPrefix(host, "*.") || strings.Count(host, "*") != 1 || len(host) < 3 {
+				return nil, fmt.Errorf("origin %q: a wildcard must stand for the subdomain, as in https://*.example.com", origin)
+			}
+			p.wildcards = append(p.wildcards, originPattern{prefix: scheme + "://", suffix: host[1:]})
+		case origin == "":
+			return nil, errors.New("empty origin")
+		default:
+			p.origins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+	if p.anyOrigin && p.credentials {
+		return nil, errors.New("credentials cannot be allowed for any origin, list the origins instead of *")
+	}
+
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultAllowedMethods
+	}
+	allowed := make([]string, 0, len(methods))
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		p.methods[method] = true
+		allowed = append(allowed, method)
+	}
+	p.allowMethods = strings.Join(allowed, ", ")
+
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = append(append([]string{}, defaultAllowedHeaders...), tenantHeader)
+	}
+	for _, header := range headers {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header == "*" {
+			if p.credentials {
+				return nil, errors.New("credentials cannot be allowed for any header, list the headers instead of *")
+			}
+			p.anyHeader = true
+		}
+		p.headers[header] = true
+	}
+	p.allowHeaders = strings.Join(headers, ", ")
+
+	exposed := config.ExposedHeaders
+	if len(exposed) == 0 {
+		exposed = defaultExposedHeaders
+	}
+	p.exposeHeaders = strings.Join(exposed, ", ")
+
+	if config.MaxAge < 0 {
+		return nil, errors.New("maxage must not be negative")
+	}
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(config.MaxAge / time.Second))
+	}
+	return p, nil
+}
+
+// allowsOrigin reports whether pages of origin may call the API
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, pattern := range p.wildcards {
+		if pattern.matches(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders reports whether pages may send the comma separated request headers of a preflight request
+func (p *corsPolicy) allowsHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// allowOrigin sets the headers allowing origin to read the response
+func (p *corsPolicy) allowOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsMiddleware applies the CORS policy. Responses to allowed origins carry the CORS headers, and preflight
+// requests are answered here, with 204 when the policy allows the request and 403 when it does not.
+func (a *API) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := a.cors
+		h := w.Header()
+		h.Set("Content-Type", "application/json; charset=UTF-8")
+		// the response depends on the origin unless every origin gets the same one
+		if !p.anyOrigin {
+			h.Add("Vary", "Origin")
+		}
+
+		origin := r.Header.Get("Origin")
+		if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			a.preflight(w, r, origin)
+			return
+		}
+
+		if origin != "" && p.allowsOrigin(origin) {
+			p.allowOrigin(h, origin)
+			if p.exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflight answers a preflight request, which asks whether the page of origin may send a request
+func (a *API) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	p := a.cors
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	var err error
+	switch {
+	case !p.allowsOrigin(origin):
+		err = fmt.Errorf("origin %s is not allowed", origin)
+	case !p.methods[strings.ToUpper(method)]:
+		err = fmt.Errorf("method %s is not allowed", method)
+	case !p.allowsHeaders(requested):
+		err = fmt.Errorf("headers %s are not allowed", requested)
+	}
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusForbidden, err.Error())
+		return
+	}
+
+	p.allowOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	if p.anyHeader && requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	} else {
+		h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	h.Del("Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	if _, err := newCORSPolicy(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, "X-Tenant-ID"); err == nil {
+		t.Error("credentials for any origin: want an error")
+	}
+	for _, origin := range []string{"https://app.*.com", "https://*", "*.example.com", "https://**.example.com"} {
+		if _, err := newCORSPolicy(CORSConfig{AllowedOrigins: []string{origin}}, "X-Tenant-ID"); err == nil {
+			t.Errorf("origin %q: want an error", origin)
+		}
+	}
+
+	p, err := newCORSPolicy(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"get", "post"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}, "X-Tenant-ID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{cors: p}
+	handler := a.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name      string
+		method    string
+		origin    string
+		preflight string
+		headers   string
+		status    int
+		allowed   string
+	}{
+		{"same origin", "GET", "", "", "", http.StatusOK, ""},
+		{"listed origin", "GET", "https://app.example.com", "", "", http.StatusOK, "https://app.example.com"},
+		{"origin in other case", "GET", "https://APP.example.com", "", "", http.StatusOK, "https://APP.example.com"},
+		{"subdomain", "POST", "https://a.b.example.org", "", "", http.StatusOK, "https://a.b.example.org"},
+		{"bare wildcard domain", "GET", "https://example.org", "", "", http.StatusOK, ""},
+		{"wildcard other scheme", "GET", "http://a.example.org", "", "", http.StatusOK, ""},
+		{"wildcard suffix trick", "GET", "https://evil.com/.example.org", "", "", http.StatusOK, ""},
+		{"unlisted origin", "GET", "https://evil.com", "", "", http.StatusOK, ""},
+		{"preflight", "OPTIONS", "https://app.example.com", "POST", "content-type, x-tenant-id", http.StatusNoContent, "https://app.example.com"},
+		{"preflight unlisted origin", "OPTIONS", "https://evil.com", "POST", "", http.StatusForbidden, ""},
+		{"preflight method", "OPTIONS", "https://app.example.com", "DELETE", "", http.StatusForbidden, ""},
+		{"preflight header", "OPTIONS", "https://app.example.com", "POST", "X-Secret", http.StatusForbidden, ""},
+		{"plain options", "OPTIONS", "https://app.example.com", "", "", http.StatusOK, "https://app.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/todos", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			if tt.headers != "" {
+				r.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("want status %d, got %d", tt.status, w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowed {
+				t.Errorf("want Access-Control-Allow-Origin %q, got %q", tt.allowed, got)
+			}
+			if got := w.Header().Values("Vary"); len(got) == 0 || got[0] != "Origin" {
+				t.Errorf("want Vary: Origin, got %v", got)
+			}
+			if tt.allowed == "" {
+				return
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Errorf("want credentials allowed, got %q", got)
+			}
+			if tt.status == http.StatusNoContent {
+				if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+					t.Errorf("want the allowed methods GET, POST, got %q", got)
+				}
+				if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("want max age 600, got %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSDefault(t *testing.T) {
+	p, err := newCORSPolicy(CORSConfig{}, "X-Tenant-ID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &API{cors: p}
+	handler := a.corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// without allowed origins only the API's own origin may call it
+	r := httptest.NewRequest("GET", "/api/v1/todos", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("want no Access-Control-Allow-Origin, got %q", got)
+	}
+
+	r = httptest.NewRequest("OPTIONS", "/api/v1/todos", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight: want status 403, got %d", w.Code)
+	}
+}
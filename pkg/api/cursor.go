@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// cursorToken is the content of a cursor. It holds the sorting of the list and the sort keys of the
+// last todo of a page, and is opaque to clients.
+type cursorToken struct {
+	Keys []cursorKey `json:"k"`
+	ID   int         `json:"i"`
+	// Now is the moment the smart score of the list is computed at
+	Now *time.Time `json:"n,omitempty"`
+}
+
+// cursorKey is a sort key and the value of the todo in it. The smart key holds the fields its
+// score is computed from.
+type cursorKey struct {
+	SortBy   model.SortBy   `json:"b"`
+	SortType model.SortType `json:"t"`
+	Text     string         `json:"s,omitempty"`
+	Time     *time.Time     `json:"d,omitempty"`
+	Priority model.Priority `json:"p,omitempty"`
+	AllDay   bool           `json:"a,omitempty"`
+	// Ref is the project or parent todo
+	Ref int `json:"r,omitempty"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// encodeCursor returns the cursor continuing a list sorted by sorting behind todo
+func encodeCursor(sorting model.Sorting, todo *model.Todo) string {
+	token := cursorToken{ID: todo.ID}
+	if !sorting.Now.IsZero() {
+		token.Now = &sorting.Now
+	}
+	for _, key := range sorting.Keys {
+		k := cursorKey{SortBy: key.SortBy, SortType: key.SortType}
+		switch key.SortBy {
+		case model.SortByTitle:
+			k.Text = todo.Title
+		case model.SortByDescription:
+			k.Text = todo.Description
+		case model.SortByDueDate:
+			k.Time = todo.DueDate
+		case model.SortByStatus:
+			k.Text = string(todo.Status)
+		case model.SortByCompletedAt:
+			k.Time = todo.CompletedAt
+		case model.SortByPriority:
+			k.Priority = todo.Priority
+		case model.SortBySmart:
+			k.Text, k.Time, k.Priority, k.AllDay = string(todo.Status), todo.DueDate, todo.Priority, todo.AllDay
+		case model.SortByProject:
+			k.Ref = todo.ProjectID
+		case model.SortByParent:
+			k.Ref = todo.ParentID
+		}
+		token.Keys = append(token.Keys, k)
+	}
+
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the sorting of a cursor and the todo to continue behind
+func decodeCursor(cursor string) (model.Sorting, *model.Todo, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return model.Sorting{}, nil, errInvalidCursor
+	}
+	var token cursorToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return model.Sorting{}, nil, errInvalidCursor
+	}
+
+	var sorting model.Sorting
+	if token.Now != nil {
+		sorting.Now = *token.Now
+	}
+	todo := &model.Todo{ID: token.ID}
+	for _, k := range token.Keys {
+		switch k.SortBy {
+		case model.SortByID:
+		case model.SortByTitle:
+			todo.Title = k.Text
+		case model.SortByDescription:
+			todo.Description = k.Text
+		case model.SortByDueDate:
+			todo.DueDate = k.Time
+		case model.SortByStatus:
+			todo.Status = model.Status(k.Text)
+		case model.SortByCompletedAt:
+			todo.CompletedAt = k.Time
+		case model.SortByPriority:
+			todo.Priority = k.Priority
+		case model.SortBySmart:
+			if token.Now == nil {
+				return model.Sorting{}, nil, errInvalidCursor
+			}
+			todo.Status, todo.DueDate, todo.Priority, todo.AllDay = model.Status(k.Text), k.Time, k.Priority, k.AllDay
+		case model.SortByProject:
+			todo.ProjectID = k.Ref
+		case model.SortByParent:
+			todo.ParentID = k.Ref
+		default:
+			return model.Sorting{}, nil, errInvalidCursor
+		}
+		if k.SortType != model.SortAscending && k.SortType != model.SortDescending {
+			return model.Sorting{}, nil, errInvalidCursor
+		}
+		sorting.Keys = append(sorting.Keys, model.SortKey{SortBy: k.SortBy, SortType: k.SortType})
+	}
+
+	return sorting, todo, nil
+}
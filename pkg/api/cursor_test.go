@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// cursorList is a page of a list as the tests read it
+type cursorList struct {
+	Items []struct {
+		ID int `json:"id"`
+	} `json:"items"`
+	Next       string `json:"next"`
+	NextCursor string `json:"nextCursor"`
+	// link is the Link header of the page
+	link string
+}
+
+// list gets a page of the todos of the user, failing the test unless it succeeds
+func (u *testUser) list(query url.Values) cursorList {
+	u.t.Helper()
+	w := u.serve("GET", "/api/v1/todos?"+query.Encode(), "")
+	if w.Code != http.StatusOK {
+		u.t.Fatalf("%s: want status 200, got %d: %s", query.Encode(), w.Code, w.Body)
+	}
+	var page cursorList
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		u.t.Fatal(err)
+	}
+	page.link = w.Header().Get("Link")
+	return page
+}
+
+// walk follows the cursors of a list from its first page and returns the IDs of its todos in order
+func (u *testUser) walk(query url.Values) []int {
+	u.t.Helper()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	var ids []int
+	for {
+		page := u.list(query)
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		if page.NextCursor == "" {
+			if page.Next != "" || page.link != "" && query.Get("cursor") != "" {
+				u.t.Errorf("%s: the last page links to %q (%s)", query.Encode(), page.Next, page.link)
+			}
+			return ids
+		}
+		if query.Get("cursor") != "" {
+			if len(page.Items) != limit {
+				u.t.Fatalf("%s: a page with a next one has %d todos", query.Encode(), len(page.Items))
+			}
+			if want := `<` + page.Next + `>; rel="next"`; page.link != want {
+				u.t.Errorf("got Link %s, want %s", page.link, want)
+			}
+		}
+		next := url.Values{"cursor": {page.NextCursor}}
+		for name, values := range query {
+			if name != "cursor" && name != "page" {
+				next[name] = values
+			}
+		}
+		query = next
+	}
+}
+
+func TestCursor(t *testing.T) {
+	u := newTestUser(t, newTestAPI(t, &Config{}), "ada")
+	var want []int
+	for _, title := range []string{"b", "a", "c", "a", "b", "a"} {
+		want = append(want, u.addTodo(&model.Todo{Title: title}).ID)
+	}
+	all := u.list(url.Values{"limit": {"100"}})
+	if len(all.Items) != len(want) || all.NextCursor != "" {
+		t.Fatalf("got %d todos and cursor %q, want %d todos", len(all.Items), all.NextCursor, len(want))
+	}
+	for i, item := range all.Items {
+		want[i] = item.ID
+	}
+	byTitle := u.list(url.Values{"limit": {"100"}, "sortBy": {"title:desc"}})
+
+	// the limits dividing the list end behind a cursor on a full page, which the todo fetched
+	// beyond the limit tells is the last
+	for _, limit := range []string{"1", "2", "3", "4", "5", "6"} {
+		t.Run("limit "+limit, func(t *testing.T) {
+			if got := u.walk(url.Values{"limit": {limit}}); !equalIDs(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+			got := u.walk(url.Values{"limit": {limit}, "sortBy": {"title:desc"}})
+			if len(got) != len(byTitle.Items) {
+				t.Fatalf("sorted by title: got %v, want %d todos", got, len(byTitle.Items))
+			}
+			for i, item := range byTitle.Items {
+				if got[i] != item.ID {
+					t.Fatalf("sorted by title: got %v, want the order of %+v", got, byTitle.Items)
+				}
+			}
+		})
+	}
+
+	first := u.list(url.Values{"limit": {"2"}, "sortBy": {"title"}})
+	tests := []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"same sortBy", url.Values{"cursor": {first.NextCursor}, "sortBy": {"title"}}, http.StatusOK},
+		{"sortBy of the cursor", url.Values{"cursor": {first.NextCursor}}, http.StatusOK},
+		{"other sortBy", url.Values{"cursor": {first.NextCursor}, "sortBy": {"description"}}, http.StatusBadRequest},
+		{"other sortType", url.Values{"cursor": {first.NextCursor}, "sortBy": {"title"}, "sortType": {"desc"}}, http.StatusBadRequest},
+		{"page and cursor", url.Values{"cursor": {first.NextCursor}, "page": {"2"}}, http.StatusBadRequest},
+		{"not base64", url.Values{"cursor": {"!" + first.NextCursor}}, http.StatusBadRequest},
+		{"not JSON", url.Values{"cursor": {base64.RawURLEncoding.EncodeToString([]byte("title"))}}, http.StatusBadRequest},
+		{"truncated", url.Values{"cursor": {first.NextCursor[:len(first.NextCursor)-4]}}, http.StatusBadRequest},
+		{"unknown sortBy", url.Values{"cursor": {tokenCursor(t, cursorToken{Keys: []cursorKey{{SortBy: 99}}, ID: 1})}}, http.StatusBadRequest},
+		{"unknown sortType", url.Values{"cursor": {tokenCursor(t, cursorToken{Keys: []cursorKey{{SortBy: model.SortByTitle, SortType: 9}}, ID: 1})}}, http.StatusBadRequest},
+		{"smart without its moment", url.Values{"cursor": {tokenCursor(t, cursorToken{Keys: []cursorKey{{SortBy: model.SortBySmart}}, ID: 1})}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := u.serve("GET", "/api/v1/todos?"+tt.query.Encode(), ""); w.Code != tt.want {
+				t.Errorf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestCursorSmartOrder(t *testing.T) {
+	u := newTestUser(t, newTestAPI(t, &Config{}), "ada")
+	now := time.Now().UTC()
+	for i, due := range []time.Duration{-48 * time.Hour, time.Hour, 0, 72 * time.Hour, -time.Hour, 0, 24 * time.Hour} {
+		todo := &model.Todo{Title: "todo", Priority: model.Priority(i % 3)}
+		if due != 0 {
+			at := now.Add(due)
+			todo.DueDate = &at
+		}
+		u.addTodo(todo)
+	}
+	all := u.list(url.Values{"limit": {"100"}, "sortBy": {"smart"}})
+	var want []int
+	for _, item := range all.Items {
+		want = append(want, item.ID)
+	}
+
+	// every cursor of the list keeps the moment of its first page
+	query := url.Values{"limit": {"2"}, "sortBy": {"smart"}}
+	var ids []int
+	var pinned time.Time
+	for {
+		page := u.list(query)
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		sorting, _, err := decodeCursor(page.NextCursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sorting.Now.IsZero() || !pinned.IsZero() && !sorting.Now.Equal(pinned) {
+			t.Fatalf("the cursor computes the score at %v, want %v", sorting.Now, pinned)
+		}
+		pinned = sorting.Now
+		query = url.Values{"limit": {"2"}, "cursor": {page.NextCursor}}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if pinned.IsZero() {
+		t.Fatal("the list has no second page")
+	}
+	if !equalIDs(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+// tokenCursor encodes a cursor as encodeCursor does, to tamper with its content
+func tokenCursor(t *testing.T, token cursorToken) string {
+	data, err := json.Marshal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/app"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// todoETag returns the entity tag of a todo, its version
+func todoETag(todo *model.Todo) string {
+	return `"` + strconv.FormatInt(todo.Version, 10) + `"`
+}
+
+// projectETag returns the entity tag of a project, its version
+func projectETag(project *model.Project) string {
+	return `"` + strconv.FormatInt(project.Version, 10) + `"`
+}
+
+// listETag returns a weak entity tag for a page of todos, changing whenever a todo in it or the total changes
+func listETag(todos []*model.Todo, total int) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d;", total)
+	for _, todo := range todos {
+		fmt.Fprintf(h, "%d:%d;", todo.ID, todo.Version)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
+}
+
+// splitETags parses the list of entity tags of an If-Match or If-None-Match header
+func splitETags(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// notModified reports whether the If-None-Match header of r matches etag, using the weak comparison
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range splitETags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch returns the precondition of the If-Match header of r, or nil without one, see matchETag
+func ifMatch(r *http.Request) app.Precondition {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	return func(todo *model.Todo) error {
+		return matchETag(header, todoETag(todo))
+	}
+}
+
+// ifMatchProject returns the precondition of the If-Match header of r for a project, or nil without one
+func ifMatchProject(r *http.Request) app.ProjectPrecondition {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+
+	return func(project *model.Project) error {
+		return matchETag(header, projectETag(project))
+	}
+}
+
+// matchETag checks the If-Match header against the entity tag of the current state. Compressed responses
+// carry the tag as a weak one, see gzipResponseWriter, which names the same version and matches too.
+func matchETag(header string, etag string) error {
+	for _, tag := range splitETags(header) {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: If-Match %s does not match %s", repository.ErrPreconditionFailed, header, etag)
+}
+
+// writeTodo writes a todo with its entity tag, or 304 Not Modified when the client has it already
+func writeTodo(w http.ResponseWriter, r *http.Request, todo *model.Todo) {
+	etag := todoETag(todo)
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	response.Write(w, r, todoView{todo})
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+func TestIfMatch(t *testing.T) {
+	u := newTestUser(t, newTestAPI(t, &Config{}), "ada")
+
+	requests := []struct {
+		method, path, body string
+	}{
+		{"PUT", "/api/v1/todos/%d", `{"title":"bread","status":"open"}`},
+		{"PATCH", "/api/v1/todos/%d", `{"title":"bread"}`},
+		{"DELETE", "/api/v1/todos/%d", ""},
+		{"POST", "/api/v1/todos/%d/complete", ""},
+	}
+	tests := []struct {
+		name, ifMatch string
+		want          int
+	}{
+		{"current", `"1"`, http.StatusOK},
+		{"stale", `"7"`, http.StatusPreconditionFailed},
+		{"any", "*", http.StatusOK},
+		{"one of a list", `"7", "1"`, http.StatusOK},
+		{"weak", `W/"1"`, http.StatusOK},
+		{"none", "", http.StatusOK},
+	}
+	for _, req := range requests {
+		for _, tt := range tests {
+			t.Run(req.method+" "+req.path+" "+tt.name, func(t *testing.T) {
+				todo := u.addTodo(&model.Todo{Title: "milk"})
+				w := u.serve(req.method, fmt.Sprintf(req.path, todo.ID), req.body, "If-Match", tt.ifMatch)
+				if w.Code != tt.want {
+					t.Fatalf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+				}
+				if tt.want == http.StatusPreconditionFailed {
+					if got := u.getTodo(todo.ID); got.Version != todo.Version || got.Title != "milk" {
+						t.Errorf("the todo changed despite the failed precondition: %+v", got)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	u := newTestUser(t, newTestAPI(t, &Config{}), "ada")
+	todo := u.addTodo(&model.Todo{Title: "milk"})
+	path := fmt.Sprintf("/api/v1/todos/%d", todo.ID)
+
+	w := u.serve("GET", path, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"1"` {
+		t.Fatalf("want status 200 with ETag \"1\", got %d with %q", w.Code, etag)
+	}
+	tests := []struct {
+		name, ifNoneMatch string
+		want              int
+	}{
+		{"current", etag, http.StatusNotModified},
+		{"weak", "W/" + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"one of a list", `"7", ` + etag, http.StatusNotModified},
+		{"stale", `"7"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := u.serve("GET", path, "", "If-None-Match", tt.ifNoneMatch)
+			if w.Code != tt.want {
+				t.Errorf("want status %d, got %d", tt.want, w.Code)
+			}
+			if w.Code == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag) {
+				t.Errorf("want no body and ETag %s, got %q and %q", etag, w.Body, w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		w := u.serve("GET", "/api/v1/todos", "")
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || len(etag) < 3 || etag[:2] != "W/" {
+			t.Fatalf("want status 200 with a weak ETag, got %d with %q", w.Code, etag)
+		}
+		if w := u.serve("GET", "/api/v1/todos", "", "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("unchanged list: want status 304 without body, got %d", w.Code)
+		}
+
+		// a change of a todo in the list changes the tag
+		u.serve("PATCH", path, `{"title":"bread"}`)
+		w = u.serve("GET", "/api/v1/todos", "", "If-None-Match", etag)
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf("changed list: want status 200 with a new ETag, got %d with %q", w.Code, w.Header().Get("ETag"))
+		}
+		// so does another todo
+		etag = w.Header().Get("ETag")
+		u.addTodo(&model.Todo{Title: "eggs"})
+		if w := u.serve("GET", "/api/v1/todos", "", "If-None-Match", etag); w.Code != http.StatusOK {
+			t.Errorf("longer list: want status 200, got %d", w.Code)
+		}
+	})
+}
@@ -1,15 +1,70 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
 )
 
+// requestIDHeader carries the id of a request, which is taken from the client or generated
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request id taken from a client
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the id of a request
+type requestIDKey struct{}
+
+// requestID returns the id of the request, see requestIDMiddleware
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware gives every request an id, which is logged and returned in the X-Request-ID header.
+// The id a client sends is kept when it is short and printable, so that requests can be traced across services.
+func (a *API) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 // logMiddleware handles logging
-func (a *API) logMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (a *API) logMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logrus.WithFields(logrus.Fields{
+			"requestID":  requestID(r.Context()),
 			"host":       r.Host,
 			"address":    r.RemoteAddr,
 			"method":     r.Method,
@@ -22,14 +77,253 @@ func (a *API) logMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
-// corsMiddleware handles preflight
-func (a *API) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// recoveryMiddleware answers a request whose handler panics with 500 and an error body instead of dropping
+// the connection, and logs the panic with its stack
+func (a *API) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// the handler aborts the response on purpose
+				panic(v)
+			}
+			err := fmt.Errorf("panic: %v", v)
+			logrus.WithFields(logrus.Fields{
+				"requestID":  requestID(r.Context()),
+				"method":     r.Method,
+				"requestURI": r.RequestURI,
+				"stack":      string(debug.Stack()),
+			}).WithError(err).Error("Recovered from a panic in a handler")
+
+			if sw.status != 0 {
+				// part of the response is sent already, it cannot become an error response anymore
+				return
+			}
+			response.Errorf(sw, r, err, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter remembers the status of the response written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the response written so far to the client
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the writer the status is written to, for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// timeoutMiddleware bounds the work of a request to RequestTimeout. Handlers pass the deadline on to
+// the repository with the context of the request.
+func (a *API) timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.config.RequestTimeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), a.config.RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// scopeKey is the context key of the scope of the credential a request was authorized with
+type scopeKey struct{}
+
+// requestScope returns the scope of the credential of the request
+func requestScope(ctx context.Context) model.Scope {
+	scope, _ := ctx.Value(scopeKey{}).(model.Scope)
+	return scope
+}
+
+// authMiddleware lets requests with a valid bearer token or API key through and scopes their repository
+// calls to the user the credential belongs to. Session tokens may do anything, API keys are limited to
+// their scope: reading needs the read scope and any other method the write scope.
+func (a *API) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			unauthorized(w, r, errors.New("authorization with a bearer token is required"), "")
+			return
+		}
+
+		var userID int
+		scope := model.ScopeAdmin
+		if auth.IsAPIKey(token) {
+			key, err := a.app.AuthenticateAPIKey(r.Context(), token, time.Now())
+			if errors.Is(err, repository.ErrNotFound) {
+				unauthorized(w, r, errors.New("invalid API key"), "invalid_token")
+				return
+			}
+			if err != nil {
+				response.Fail(w, r, err)
+				return
+			}
+			userID, scope = key.OwnerID, key.Scope
+		} else {
+			claims, err := a.signer.Verify(token, time.Now())
+			if err != nil {
+				unauthorized(w, r, err, "invalid_token")
+				return
+			}
+			if claims.Tenant != repository.Tenant(r.Context()) {
+				unauthorized(w, r, errors.New("the token belongs to another tenant"), "invalid_token")
+				return
+			}
+			userID = claims.UserID
+		}
+
+		required := model.ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			required = model.ScopeRead
+		}
+		if !scope.Allows(required) {
+			forbidden(w, r, required)
+			return
+		}
+
+		ctx := context.WithValue(repository.WithOwner(r.Context(), userID), scopeKey{}, scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// scopeMiddleware returns a middleware letting requests through whose credential has the scope required.
+// It must run after authMiddleware.
+func (a *API) scopeMiddleware(required model.Scope) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !requestScope(r.Context()).Allows(required) {
+				forbidden(w, r, required)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tenantMiddleware directs the repository calls of a request to its tenant, named by the tenant header, a
+// subdomain of TenantDomain or the session token, in this order. Requests naming no tenant go to the
+// default workspace.
+func (a *API) tenantMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Authorization")
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		if a.app.Tenants == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 
+		tenant := a.requestTenant(r)
+		if tenant != "" {
+			if err := repository.ValidateTenant(tenant); err != nil {
+				response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(repository.WithTenant(r.Context(), tenant)))
+	})
+}
+
+// requestTenant returns the tenant a request names, empty for the default workspace
+func (a *API) requestTenant(r *http.Request) string {
+	header := a.config.TenantHeader
+	if header == "" {
+		header = defaultTenantHeader
+	}
+	if tenant := strings.TrimSpace(r.Header.Get(header)); tenant != "" {
+		return strings.ToLower(tenant)
+	}
+
+	if domain := strings.ToLower(a.config.TenantDomain); domain != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tenant, ok := strings.CutSuffix(strings.ToLower(host), "."+domain); ok {
+			return tenant
+		}
+	}
+
+	// API keys are stored in the tenant, so they cannot name it. An invalid token is left to authMiddleware.
+	if token, ok := bearerToken(r); ok && !auth.IsAPIKey(token) {
+		if claims, err := a.signer.Verify(token, time.Now()); err == nil {
+			return claims.Tenant
+		}
+	}
+	return ""
+}
+
+// adminMiddleware lets requests through whose bearer token is the AdminKey
+func (a *API) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.config.AdminKey == "" {
+			err := errors.New("tenant administration is disabled, no adminkey is configured")
+			response.Errorf(w, r, err, http.StatusForbidden, err.Error())
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok {
+			unauthorized(w, r, errors.New("authorization with the admin key is required"), "")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.config.AdminKey)) != 1 {
+			unauthorized(w, r, errors.New("invalid admin key"), "invalid_token")
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// unauthorized responds 401 with a challenge for a bearer token. code is the RFC 6750 error code, if any.
+func unauthorized(w http.ResponseWriter, r *http.Request, err error, code string) {
+	challenge := `Bearer realm="todo"`
+	if code != "" {
+		challenge += `, error="` + code + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	response.Errorf(w, r, err, http.StatusUnauthorized, err.Error())
+}
+
+// forbidden responds 403 to a request whose credential lacks the scope required
+func forbidden(w http.ResponseWriter, r *http.Request, required model.Scope) {
+	err := fmt.Errorf("the API key does not have the %s scope", required)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="todo", error="insufficient_scope", scope="`+string(required)+`"`)
+	response.Errorf(w, r, err, http.StatusForbidden, err.Error())
+}
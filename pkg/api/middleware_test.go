@@ -0,0 +1,168 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/app"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+func newTestAPI(t *testing.T, config *Config) *API {
+	db, err := os.OpenFile(filepath.Join(t.TempDir(), "db.json"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := repository.NewJSONRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(config, app.New(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestMiddleware(t *testing.T) {
+	a := newTestAPI(t, &Config{
+		RateLimits: map[string]RateLimit{groupAuth: {Rate: 0.001, Burst: 2}},
+		CORS:       CORSConfig{AllowedOrigins: []string{"*"}},
+	})
+	a.Router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	a.Router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"3"`)
+		io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, " second")
+	})
+
+	serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(`{"name":"ada","password":"wrong horse"}`))
+		for key, values := range header {
+			r.Header.Set(key, values[0])
+		}
+		w := httptest.NewRecorder()
+		a.Router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("recovery", func(t *testing.T) {
+		w := serve("GET", "/panic", nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("want status 500, got %d", w.Code)
+		}
+		var body response.Error
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || !body.Error || body.Code != http.StatusInternalServerError {
+			t.Errorf("want an error body, got %+v (%v)", body, err)
+		}
+	})
+
+	t.Run("request id", func(t *testing.T) {
+		if id := serve("GET", "/api/v1/todos", nil).Header().Get(requestIDHeader); id == "" {
+			t.Error("want a generated request id")
+		}
+		if id := serve("GET", "/api/v1/todos", http.Header{requestIDHeader: {"trace-42"}}).Header().Get(requestIDHeader); id != "trace-42" {
+			t.Errorf("want the request id of the client, got %q", id)
+		}
+		if id := serve("GET", "/api/v1/todos", http.Header{requestIDHeader: {"bad id"}}).Header().Get(requestIDHeader); id == "bad id" {
+			t.Error("want an invalid request id replaced")
+		}
+	})
+
+	t.Run("compression", func(t *testing.T) {
+		w := serve("GET", "/api/v1/todos", http.Header{"Accept-Encoding": {"gzip"}})
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("want a gzip body, got headers %v", w.Header())
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(gz)
+		if err != nil || !strings.Contains(string(body), `"statusCode":401`) {
+			t.Errorf("want the unauthorized error, got %q (%v)", body, err)
+		}
+
+		if w := serve("GET", "/api/v1/todos", http.Header{"Accept-Encoding": {"gzip;q=0"}}); w.Header().Get("Content-Encoding") != "" {
+			t.Error("want no compression when gzip is refused")
+		}
+	})
+
+	t.Run("unmatched routes", func(t *testing.T) {
+		tests := []struct {
+			method, path, allow string
+			want                int
+		}{
+			{"GET", "/api/v1/nothing", "", http.StatusNotFound},
+			{"GET", "/nothing", "", http.StatusNotFound},
+			{"DELETE", "/api/v1/auth/login", "POST", http.StatusMethodNotAllowed},
+			{"DELETE", "/api/v1/todos", "GET, POST, PUT", http.StatusMethodNotAllowed},
+		}
+		for _, tt := range tests {
+			w := serve(tt.method, tt.path, http.Header{"Accept-Encoding": {"gzip"}, "Origin": {"https://app.example.com"}})
+			if w.Code != tt.want {
+				t.Errorf("%s %s: want status %d, got %d", tt.method, tt.path, tt.want, w.Code)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.allow {
+				t.Errorf("%s %s: want Allow %q, got %q", tt.method, tt.path, tt.allow, allow)
+			}
+			// the middleware of every request runs for them too
+			h := w.Header()
+			if h.Get(requestIDHeader) == "" || h.Get("Content-Encoding") != "gzip" || h.Get("Access-Control-Allow-Origin") == "" {
+				t.Errorf("%s %s: want the headers of the middleware, got %v", tt.method, tt.path, h)
+			}
+		}
+	})
+
+	t.Run("compressed stream", func(t *testing.T) {
+		w := serve("GET", "/stream", http.Header{"Accept-Encoding": {"gzip"}})
+		if !w.Flushed {
+			t.Error("want the response flushed")
+		}
+		// the compressed body is another representation, so its entity tag is weak, but still matches If-Match
+		if etag := w.Header().Get("ETag"); etag != `W/"3"` {
+			t.Errorf("want the weak entity tag W/\"3\", got %q", etag)
+		}
+		if err := matchETag(`W/"3"`, `"3"`); err != nil {
+			t.Errorf("If-Match with the weak entity tag: %v", err)
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, err := io.ReadAll(gz); err != nil || string(body) != "first second" {
+			t.Errorf("want the whole body, got %q (%v)", body, err)
+		}
+
+		if etag := serve("GET", "/stream", nil).Header().Get("ETag"); etag != `"3"` {
+			t.Errorf("want the strong entity tag of the plain body, got %q", etag)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if w := serve("POST", "/api/v1/auth/login", nil); w.Code != http.StatusUnauthorized {
+				t.Fatalf("request %d: want status 401, got %d", i, w.Code)
+			}
+		}
+		w := serve("POST", "/api/v1/auth/login", nil)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("want status 429 with Retry-After, got %d %v", w.Code, w.Header())
+		}
+		// other groups have limits of their own
+		if w := serve("GET", "/api/v1/todos", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("want status 401 outside the auth group, got %d", w.Code)
+		}
+	})
+}
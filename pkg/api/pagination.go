@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+const (
+	defaultLimit    = 10
+	defaultMaxLimit = 100
+)
+
+// parsePagination reads the page and limit parameters. The page starts at 1 and the limit
+// is between 1 and maxLimit.
+func parsePagination(params url.Values, maxLimit int) (model.Pagination, error) {
+	if maxLimit <= 0 {
+		maxLimit = defaultMaxLimit
+	}
+	pagination := model.Pagination{Page: 1, Limit: defaultLimit}
+
+	if page := params.Get("page"); page != "" {
+		pageInt, err := strconv.Atoi(page)
+		if err != nil || pageInt < 1 {
+			return pagination, fmt.Errorf("invalid page %q, pages start at 1", page)
+		}
+		pagination.Page = pageInt
+	}
+	if limit := params.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt < 1 || limitInt > maxLimit {
+			return pagination, fmt.Errorf("invalid limit %q, the limit is between 1 and %d", limit, maxLimit)
+		}
+		pagination.Limit = limitInt
+	}
+	if maxLimit < pagination.Limit {
+		pagination.Limit = maxLimit
+	}
+
+	return pagination, nil
+}
+
+// newPage returns the envelope of one page of todos and sets the RFC 8288 Link header for it
+func newPage(w http.ResponseWriter, r *http.Request, todos []*model.Todo, total int, pagination model.Pagination, sorting model.Sorting) response.Page {
+	totalPages := (total + pagination.Limit - 1) / pagination.Limit
+
+	page := response.Page{
+		Items:      todoViews(todos),
+		Total:      total,
+		Page:       pagination.Page,
+		Limit:      pagination.Limit,
+		TotalPages: totalPages,
+	}
+
+	var links []string
+	link := func(rel string, number int) string {
+		target := pageURL(r, number)
+		links = append(links, fmt.Sprintf("<%s>; rel=%q", target, rel))
+		return target
+	}
+	if pagination.Page < totalPages {
+		page.Next = link("next", pagination.Page+1)
+		if len(todos) > 0 {
+			page.NextCursor = encodeCursor(sorting, todos[len(todos)-1])
+		}
+	}
+	if pagination.Page > 1 {
+		// a page past the end leads back to the last one
+		prev := pagination.Page - 1
+		if prev > totalPages {
+			prev = totalPages
+		}
+		if prev >= 1 {
+			page.Prev = link("prev", prev)
+		}
+	}
+	link("first", 1)
+	if totalPages > 0 {
+		link("last", totalPages)
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+
+	return page
+}
+
+// newCursorPage returns the envelope of the todos behind a cursor and sets the RFC 8288 Link header for it.
+// todos may hold one more todo than the limit, telling that the list goes on.
+func newCursorPage(w http.ResponseWriter, r *http.Request, todos []*model.Todo, total int, pagination model.Pagination, sorting model.Sorting) response.CursorPage {
+	page := response.CursorPage{
+		Items: todoViews(nil),
+		Total: total,
+		Limit: pagination.Limit,
+	}
+
+	if len(todos) > pagination.Limit {
+		todos = todos[:pagination.Limit]
+		page.NextCursor = encodeCursor(sorting, todos[len(todos)-1])
+		page.Next = cursorURL(r, page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=%q", page.Next, "next"))
+	}
+	if len(todos) > 0 {
+		page.Items = todoViews(todos)
+	}
+
+	return page
+}
+
+// cursorURL returns the request URL of r continuing behind cursor
+func cursorURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// pageURL returns the request URL of r pointing to another page
+func pageURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+func TestPagination(t *testing.T) {
+	a := newTestAPI(t, &Config{MaxLimit: 5})
+	u := newTestUser(t, a, "ada")
+	for i := 0; i < 7; i++ {
+		u.addTodo(&model.Todo{Title: "todo"})
+	}
+
+	tests := []struct {
+		query                          string
+		page, limit, totalPages, items int
+		next, prev, link               string
+	}{
+		{"", 1, 5, 2, 5, "/api/v1/todos?page=2", "",
+			`</api/v1/todos?page=2>; rel="next", </api/v1/todos?page=1>; rel="first", </api/v1/todos?page=2>; rel="last"`},
+		{"?limit=3&page=2", 2, 3, 3, 3, "/api/v1/todos?limit=3&page=3", "/api/v1/todos?limit=3&page=1",
+			`</api/v1/todos?limit=3&page=3>; rel="next", </api/v1/todos?limit=3&page=1>; rel="prev", </api/v1/todos?limit=3&page=1>; rel="first", </api/v1/todos?limit=3&page=3>; rel="last"`},
+		{"?limit=3&page=3", 3, 3, 3, 1, "", "/api/v1/todos?limit=3&page=2",
+			`</api/v1/todos?limit=3&page=2>; rel="prev", </api/v1/todos?limit=3&page=1>; rel="first", </api/v1/todos?limit=3&page=3>; rel="last"`},
+		// a page past the end leads back to the last page
+		{"?limit=3&page=9", 9, 3, 3, 0, "", "/api/v1/todos?limit=3&page=3",
+			`</api/v1/todos?limit=3&page=3>; rel="prev", </api/v1/todos?limit=3&page=1>; rel="first", </api/v1/todos?limit=3&page=3>; rel="last"`},
+		{"?limit=5&status=open", 1, 5, 2, 5, "/api/v1/todos?limit=5&page=2&status=open", "",
+			`</api/v1/todos?limit=5&page=2&status=open>; rel="next", </api/v1/todos?limit=5&page=1&status=open>; rel="first", </api/v1/todos?limit=5&page=2&status=open>; rel="last"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := u.serve("GET", "/api/v1/todos"+tt.query, "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("want status 200, got %d: %s", w.Code, w.Body)
+			}
+			var page struct {
+				Items      []model.Todo `json:"items"`
+				Total      int          `json:"total"`
+				Page       int          `json:"page"`
+				Limit      int          `json:"limit"`
+				TotalPages int          `json:"totalPages"`
+				Next       string       `json:"next"`
+				Prev       string       `json:"prev"`
+				NextCursor string       `json:"nextCursor"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 7 || page.Page != tt.page || page.Limit != tt.limit || page.TotalPages != tt.totalPages || len(page.Items) != tt.items {
+				t.Errorf("got total %d, page %d, limit %d, %d pages and %d items, want 7, %d, %d, %d and %d",
+					page.Total, page.Page, page.Limit, page.TotalPages, len(page.Items), tt.page, tt.limit, tt.totalPages, tt.items)
+			}
+			if page.Next != tt.next || page.Prev != tt.prev {
+				t.Errorf("got next %q and prev %q, want %q and %q", page.Next, page.Prev, tt.next, tt.prev)
+			}
+			if (page.NextCursor != "") != (tt.next != "") {
+				t.Errorf("got nextCursor %q with next %q", page.NextCursor, page.Next)
+			}
+			if link := w.Header().Get("Link"); link != tt.link {
+				t.Errorf("got Link %s, want %s", link, tt.link)
+			}
+		})
+	}
+
+	t.Run("empty list", func(t *testing.T) {
+		w := newTestUser(t, a, "grace").serve("GET", "/api/v1/todos", "")
+		var page struct {
+			Items      []model.Todo `json:"items"`
+			TotalPages int          `json:"totalPages"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Items == nil || len(page.Items) != 0 || page.TotalPages != 0 {
+			t.Errorf("want no items in no pages, got %v in %d", page.Items, page.TotalPages)
+		}
+		if link := w.Header().Get("Link"); link != `</api/v1/todos?page=1>; rel="first"` {
+			t.Errorf("got Link %s, want only the first page", link)
+		}
+	})
+
+	for _, query := range []string{"?limit=6", "?limit=0", "?limit=-1", "?limit=x", "?page=0", "?page=-1", "?page=x"} {
+		t.Run(query, func(t *testing.T) {
+			if w := u.serve("GET", "/api/v1/todos"+query, ""); w.Code != http.StatusBadRequest {
+				t.Errorf("want status 400, got %d", w.Code)
+			}
+		})
+	}
+}
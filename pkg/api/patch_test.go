@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON decodes a JSON document of a test into interface{} values
+func decodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	return doc
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":1,"b":2}`, `{"a":3}`, `{"a":3,"b":2}`},
+		{`{"a":1,"b":2}`, `{"a":null}`, `{"b":2}`},
+		{`{"a":1}`, `{"missing":null}`, `{"a":1}`},
+		{`{"a":{"b":1,"c":2}}`, `{"a":{"b":null,"d":3}}`, `{"a":{"c":2,"d":3}}`},
+		{`{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{`{"a":1}`, `{"a":{"b":null}}`, `{"a":{}}`},
+		{`{"a":1}`, `[1]`, `[1]`},
+		{`[1]`, `{"a":1}`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patch, func(t *testing.T) {
+			patch, err := mergePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := patch(decodeJSON(t, tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := mergePatch([]byte(`{"a":`)); err == nil {
+		t.Error("invalid JSON: want an error")
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	const doc = `{"title":"milk","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":1}}`
+	tests := []struct {
+		name, patch, want string
+		// wantErr is true for a patch that fails to apply
+		wantErr bool
+	}{
+		{"add member", `[{"op":"add","path":"/description","value":"2%"}]`,
+			`{"title":"milk","description":"2%","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"add replaces a member", `[{"op":"add","path":"/title","value":"oat milk"}]`,
+			`{"title":"oat milk","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"add into array", `[{"op":"add","path":"/tags/1","value":"x"}]`,
+			`{"title":"milk","tags":["a","x","b"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"add at array end", `[{"op":"add","path":"/tags/-","value":"x"}]`,
+			`{"title":"milk","tags":["a","b","x"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"add behind array end", `[{"op":"add","path":"/tags/2","value":"x"}]`,
+			`{"title":"milk","tags":["a","b","x"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"add out of range", `[{"op":"add","path":"/tags/3","value":"x"}]`, "", true},
+		{"add below a missing member", `[{"op":"add","path":"/missing/x","value":1}]`, "", true},
+		{"remove member", `[{"op":"remove","path":"/obj"}]`,
+			`{"title":"milk","tags":["a","b"],"a/b":1,"m~n":2}`, false},
+		{"remove from array", `[{"op":"remove","path":"/tags/0"}]`,
+			`{"title":"milk","tags":["b"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"remove out of range", `[{"op":"remove","path":"/tags/2"}]`, "", true},
+		{"remove array end", `[{"op":"remove","path":"/tags/-"}]`, "", true},
+		{"remove missing member", `[{"op":"remove","path":"/missing"}]`, "", true},
+		{"remove leading zero index", `[{"op":"remove","path":"/tags/01"}]`, "", true},
+		{"replace member", `[{"op":"replace","path":"/obj/x","value":2}]`,
+			`{"title":"milk","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":2}}`, false},
+		{"replace array element", `[{"op":"replace","path":"/tags/1","value":"c"}]`,
+			`{"title":"milk","tags":["a","c"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"replace missing member", `[{"op":"replace","path":"/missing","value":1}]`, "", true},
+		{"move member", `[{"op":"move","from":"/title","path":"/description"}]`,
+			`{"description":"milk","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"move array element", `[{"op":"move","from":"/tags/0","path":"/tags/-"}]`,
+			`{"title":"milk","tags":["b","a"],"a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"move into itself", `[{"op":"move","from":"/obj","path":"/obj/y"}]`, "", true},
+		{"copy member", `[{"op":"copy","from":"/obj","path":"/copy"}]`,
+			`{"title":"milk","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":1},"copy":{"x":1}}`, false},
+		{"copy is independent", `[{"op":"copy","from":"/obj","path":"/copy"},{"op":"replace","path":"/copy/x","value":5}]`,
+			`{"title":"milk","tags":["a","b"],"a/b":1,"m~n":2,"obj":{"x":1},"copy":{"x":5}}`, false},
+		{"copy missing member", `[{"op":"copy","from":"/missing","path":"/copy"}]`, "", true},
+		{"test", `[{"op":"test","path":"/tags","value":["a","b"]},{"op":"remove","path":"/tags"}]`,
+			`{"title":"milk","a/b":1,"m~n":2,"obj":{"x":1}}`, false},
+		{"escaped slash", `[{"op":"replace","path":"/a~1b","value":3}]`,
+			`{"title":"milk","tags":["a","b"],"a/b":3,"m~n":2,"obj":{"x":1}}`, false},
+		{"escaped tilde", `[{"op":"remove","path":"/m~0n"}]`,
+			`{"title":"milk","tags":["a","b"],"a/b":1,"obj":{"x":1}}`, false},
+		{"whole document", `[{"op":"replace","path":"","value":{"title":"new"}}]`, `{"title":"new"}`, false},
+		{"pointer without slash", `[{"op":"remove","path":"title"}]`, "", true},
+		{"later operation fails", `[{"op":"remove","path":"/title"},{"op":"remove","path":"/title"}]`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := jsonPatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := patch(decodeJSON(t, doc))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("want an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchTest(t *testing.T) {
+	patch, err := jsonPatch([]byte(`[{"op":"test","path":"/title","value":"oat milk"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := patch(decodeJSON(t, `{"title":"milk"}`)); !errors.Is(err, errPatchTest) {
+		t.Errorf("want errPatchTest, got %v", err)
+	}
+}
+
+func TestInvalidJSONPatch(t *testing.T) {
+	for _, patch := range []string{
+		`{"op":"remove","path":"/a"}`,
+		`[{"op":"remove"}]`,
+		`[{"op":"add","path":"/a"}]`,
+		`[{"op":"replace","path":"/a"}]`,
+		`[{"op":"test","path":"/a"}]`,
+		`[{"op":"move","path":"/a"}]`,
+		`[{"op":"copy","path":"/a"}]`,
+		`[{"op":"rename","path":"/a","value":1}]`,
+	} {
+		if _, err := jsonPatch([]byte(patch)); err == nil {
+			t.Errorf("%s: want an error", patch)
+		}
+	}
+}
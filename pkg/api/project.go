@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// pathID returns the id path variable of r
+func pathID(r *http.Request) (int, error) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, errors.New("id is required")
+	}
+	return strconv.Atoi(id)
+}
+
+// writeProject writes a project with its entity tag, or 304 Not Modified when the client has it already
+func writeProject(w http.ResponseWriter, r *http.Request, project *model.Project) {
+	etag := projectETag(project)
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	response.Write(w, r, project)
+}
+
+func (a *API) GetProjects(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	projects, err := a.app.GetProjects(ctx)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.Write(w, r, projects)
+}
+
+func (a *API) GetProject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	project, err := a.app.GetProject(ctx, id)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	writeProject(w, r, project)
+}
+
+// AddProject creates a project and returns it with its id
+func (a *API) AddProject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	project := model.Project{}
+	if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.app.CreateProject(ctx, &project); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", projectETag(&project))
+	response.Write(w, r, project)
+}
+
+func (a *API) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update := model.Project{}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	if update.ID != 0 && update.ID != id {
+		err := errors.New("id in body does not match the path")
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	project, err := a.app.UpdateProject(ctx, id, &update, ifMatchProject(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", projectETag(project))
+	response.Write(w, r, project)
+}
+
+func (a *API) ArchiveProject(w http.ResponseWriter, r *http.Request) {
+	a.archiveProject(w, r, true)
+}
+
+func (a *API) UnarchiveProject(w http.ResponseWriter, r *http.Request) {
+	a.archiveProject(w, r, false)
+}
+
+func (a *API) archiveProject(w http.ResponseWriter, r *http.Request, archived bool) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	project, err := a.app.ArchiveProject(ctx, id, archived, ifMatchProject(r))
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", projectETag(project))
+	response.Write(w, r, project)
+}
+
+// DeleteProject deletes a project together with its todos
+func (a *API) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.app.DeleteProject(ctx, id, ifMatchProject(r)); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.Write(w, r, "OK")
+}
+
+// GetProjectTodos lists the todos of a project with the parameters of GetTodos. Archived todos are included.
+func (a *API) GetProjectTodos(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// an empty list of a project that does not exist would hide the mistake. The todos of a shared project
+	// are listed in the scope of its owner.
+	ctx, err = a.app.AuthorizeProject(ctx, id, model.RoleViewer)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	a.listTodos(ctx, w, r, model.Filter{ProjectID: id})
+}
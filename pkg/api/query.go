@@ -0,0 +1,379 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// maxQueryDepth bounds the nesting of parentheses and negations in a query
+const maxQueryDepth = 32
+
+// parseQuery parses the query of the filter parameter into a filter expression, or nil for an empty query.
+// Dates without an offset are read in the time zone tz.
+//
+//	query = or
+//	or    = and { "OR" and }
+//	and   = unary { [ "AND" ] unary }
+//	unary = ( "-" | "NOT" ) unary | "(" or ")" | term
+//	term  = field ( ":" | "=" | "<" | "<=" | ">" | ">=" ) value | value
+//	value = word | '"' { character | '\"' | '\\' } '"'
+//
+// A term without a field matches the title or description.
+func parseQuery(query string, tz string) (model.Expr, error) {
+	p := &queryParser{input: query, tz: tz}
+	p.skipSpace()
+	if p.done() {
+		return nil, nil
+	}
+
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return expr, nil
+}
+
+type queryParser struct {
+	input string
+	pos   int
+	tz    string
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid filter at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryParser) skipSpace() {
+	for !p.done() && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isWordEnd reports whether c ends a field name or a value without a field
+func isWordEnd(c byte) bool {
+	return isSpace(c) || strings.IndexByte(`()":<>=`, c) >= 0
+}
+
+// keyword consumes the keyword k if it is the next word
+func (p *queryParser) keyword(k string) bool {
+	if !strings.HasPrefix(p.input[p.pos:], k) {
+		return false
+	}
+	end := p.pos + len(k)
+	if end < len(p.input) && !isSpace(p.input[end]) && p.input[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) parseOr(depth int) (model.Expr, error) {
+	expr, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	or := model.Or{expr}
+	for p.keyword("OR") {
+		p.skipSpace()
+		expr, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, expr)
+	}
+
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *queryParser) parseAnd(depth int) (model.Expr, error) {
+	var and model.And
+	for {
+		p.skipSpace()
+		if p.done() || p.input[p.pos] == ')' {
+			break
+		}
+		start := p.pos
+		if p.keyword("OR") {
+			p.pos = start
+			break
+		}
+		if p.keyword("AND") {
+			if len(and) == 0 {
+				p.pos = start
+				return nil, p.errorf("AND needs a term before it")
+			}
+			continue
+		}
+
+		expr, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, expr)
+	}
+
+	switch len(and) {
+	case 0:
+		if p.done() {
+			return nil, p.errorf("unexpected end of filter")
+		}
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	case 1:
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *queryParser) parseUnary(depth int) (model.Expr, error) {
+	if depth >= maxQueryDepth {
+		return nil, p.errorf("filter nested too deeply")
+	}
+
+	switch {
+	case p.input[p.pos] == '-':
+		p.pos++
+		return p.parseNot(depth)
+	case p.keyword("NOT"):
+		p.skipSpace()
+		return p.parseNot(depth)
+	case p.input[p.pos] == '(':
+		p.pos++
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.done() || p.input[p.pos] != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *queryParser) parseNot(depth int) (model.Expr, error) {
+	if p.done() || isSpace(p.input[p.pos]) {
+		return nil, p.errorf("nothing to negate")
+	}
+	expr, err := p.parseUnary(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	return model.Not{Expr: expr}, nil
+}
+
+func (p *queryParser) parseTerm() (model.Expr, error) {
+	start := p.pos
+	if p.input[p.pos] == '"' {
+		text, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return model.Condition{Field: model.FieldText, Op: model.OpContains, Value: text}, nil
+	}
+
+	for !p.done() && !isWordEnd(p.input[p.pos]) {
+		p.pos++
+	}
+	word := p.input[start:p.pos]
+	if p.done() || strings.IndexByte(":<>=", p.input[p.pos]) < 0 {
+		if word == "" {
+			return nil, p.errorf("unexpected %q", p.input[p.pos])
+		}
+		return model.Condition{Field: model.FieldText, Op: model.OpContains, Value: word}, nil
+	}
+	if word == "" {
+		return nil, p.errorf("missing field before %q", p.input[p.pos])
+	}
+
+	op := string(p.input[p.pos])
+	p.pos++
+	if !p.done() && p.input[p.pos] == '=' && (op == "<" || op == ">") {
+		op += "="
+		p.pos++
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := p.condition(word, op, value)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("%v", err)
+	}
+	return expr, nil
+}
+
+// parseValue reads the value of a term, quoted or up to the next space or parenthesis
+func (p *queryParser) parseValue() (string, error) {
+	if !p.done() && p.input[p.pos] == '"' {
+		return p.parseQuoted()
+	}
+	start := p.pos
+	for !p.done() && !isSpace(p.input[p.pos]) && p.input[p.pos] != '(' && p.input[p.pos] != ')' {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("missing value")
+	}
+	return p.input[start:p.pos], nil
+}
+
+func (p *queryParser) parseQuoted() (string, error) {
+	start := p.pos
+	p.pos++
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return b.String(), nil
+		case c == '\\' && p.pos+1 < len(p.input):
+			p.pos++
+			b.WriteByte(p.input[p.pos])
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+
+	p.pos = start
+	return "", p.errorf("unterminated quote")
+}
+
+// queryFields are the fields of the query language
+var queryFields = map[string]model.Field{
+	"title":       model.FieldTitle,
+	"description": model.FieldDescription,
+	"status":      model.FieldStatus,
+	"due":         model.FieldDue,
+	"completed":   model.FieldCompleted,
+	"tag":         model.FieldTag,
+	// is:overdue, or is: followed by a status
+	"is": model.FieldOverdue,
+}
+
+// condition returns the expression of a term with a field
+func (p *queryParser) condition(name, op, value string) (model.Expr, error) {
+	field, ok := queryFields[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", name)
+	}
+
+	switch field {
+	case model.FieldTitle, model.FieldDescription:
+		if op != ":" {
+			return nil, fmt.Errorf("%s only supports %s:text", name, name)
+		}
+		return model.Condition{Field: field, Op: model.OpContains, Value: value}, nil
+
+	case model.FieldStatus:
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("status only supports status:value")
+		}
+		or := model.Or{}
+		for _, s := range strings.Split(value, ",") {
+			status := model.Status(s)
+			if !status.Valid() {
+				return nil, fmt.Errorf("invalid status %q", s)
+			}
+			or = append(or, model.Condition{Field: model.FieldStatus, Op: model.OpEqual, Value: s})
+		}
+		if len(or) == 1 {
+			return or[0], nil
+		}
+		return or, nil
+
+	case model.FieldTag:
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("tag only supports tag:name")
+		}
+		return model.Condition{Field: model.FieldTag, Op: model.OpEqual, Value: model.NormalizeTag(value)}, nil
+
+	case model.FieldDue, model.FieldCompleted:
+		return dateCondition(field, op, value, p.tz)
+
+	default:
+		if op != ":" {
+			return nil, fmt.Errorf("is only supports is:value")
+		}
+		if value == "overdue" {
+			return model.Condition{Field: model.FieldOverdue}, nil
+		}
+		if status := model.Status(value); status.Valid() {
+			return model.Condition{Field: model.FieldStatus, Op: model.OpEqual, Value: value}, nil
+		}
+		return nil, fmt.Errorf("unknown value %q for is", value)
+	}
+}
+
+// dateCondition compares a date field. A plain date stands for the whole day, so that due<=2026-11-01
+// includes todos due on that day and due:2026-11-01 matches all of them.
+func dateCondition(field model.Field, op, value, tz string) (model.Expr, error) {
+	if value == "none" {
+		if op != ":" && op != "=" {
+			return nil, fmt.Errorf("none can only be compared with %s:none", field)
+		}
+		return model.Not{Expr: model.Condition{Field: field, Op: model.OpExists}}, nil
+	}
+
+	t, allDay, err := model.ParseDueDate(value, tz)
+	if err != nil {
+		return nil, err
+	}
+	// end is the first moment after value
+	end := t
+	if allDay {
+		end = t.AddDate(0, 0, 1)
+	}
+
+	switch op {
+	case "<":
+		return model.Condition{Field: field, Op: model.OpLess, Time: t}, nil
+	case ">=":
+		return model.Condition{Field: field, Op: model.OpGreaterOrEqual, Time: t}, nil
+	case "<=":
+		if allDay {
+			return model.Condition{Field: field, Op: model.OpLess, Time: end}, nil
+		}
+		return model.Condition{Field: field, Op: model.OpLessOrEqual, Time: t}, nil
+	case ">":
+		if allDay {
+			return model.Condition{Field: field, Op: model.OpGreaterOrEqual, Time: end}, nil
+		}
+		return model.Condition{Field: field, Op: model.OpGreater, Time: t}, nil
+	default:
+		if allDay {
+			return model.And{
+				model.Condition{Field: field, Op: model.OpGreaterOrEqual, Time: t},
+				model.Condition{Field: field, Op: model.OpLess, Time: end},
+			}, nil
+		}
+		return model.And{
+			model.Condition{Field: field, Op: model.OpGreaterOrEqual, Time: t},
+			model.Condition{Field: field, Op: model.OpLessOrEqual, Time: t},
+		}, nil
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+func TestParseQuery(t *testing.T) {
+	text := func(field model.Field, value string) model.Condition {
+		return model.Condition{Field: field, Op: model.OpContains, Value: value}
+	}
+	status := func(value model.Status) model.Condition {
+		return model.Condition{Field: model.FieldStatus, Op: model.OpEqual, Value: string(value)}
+	}
+	due := func(op model.Op, t time.Time) model.Condition {
+		return model.Condition{Field: model.FieldDue, Op: op, Time: t}
+	}
+	day := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	next := day.AddDate(0, 0, 1)
+
+	tests := []struct {
+		query string
+		want  model.Expr
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"milk", text(model.FieldText, "milk")},
+		{`"milk (2%)"`, text(model.FieldText, "milk (2%)")},
+		{`"say \"hi\""`, text(model.FieldText, `say "hi"`)},
+		{"buy milk", model.And{text(model.FieldText, "buy"), text(model.FieldText, "milk")}},
+		{"buy AND milk", model.And{text(model.FieldText, "buy"), text(model.FieldText, "milk")}},
+		{"buy OR milk", model.Or{text(model.FieldText, "buy"), text(model.FieldText, "milk")}},
+		{"ORANGE NOTE", model.And{text(model.FieldText, "ORANGE"), text(model.FieldText, "NOTE")}},
+		{"a b OR c", model.Or{model.And{text(model.FieldText, "a"), text(model.FieldText, "b")}, text(model.FieldText, "c")}},
+		{"a (b OR c)", model.And{text(model.FieldText, "a"), model.Or{text(model.FieldText, "b"), text(model.FieldText, "c")}}},
+		{"-milk", model.Not{Expr: text(model.FieldText, "milk")}},
+		{"NOT (a OR b)", model.Not{Expr: model.Or{text(model.FieldText, "a"), text(model.FieldText, "b")}}},
+		{"pre-release", text(model.FieldText, "pre-release")},
+		{`title:"groceries"`, text(model.FieldTitle, "groceries")},
+		{"description:milk", text(model.FieldDescription, "milk")},
+		{"status:open", status(model.StatusOpen)},
+		{"status:done,cancelled", model.Or{status(model.StatusDone), status(model.StatusCancelled)}},
+		{"is:done", status(model.StatusDone)},
+		{"is:overdue", model.Condition{Field: model.FieldOverdue}},
+		{"due<2026-11-01", due(model.OpLess, day)},
+		{"due<=2026-11-01", due(model.OpLess, next)},
+		{"due>2026-11-01", due(model.OpGreaterOrEqual, next)},
+		{"due>=2026-11-01", due(model.OpGreaterOrEqual, day)},
+		{"due:2026-11-01", model.And{due(model.OpGreaterOrEqual, day), due(model.OpLess, next)}},
+		{"due<2026-11-01T10:30:00Z", due(model.OpLess, day.Add(10*time.Hour+30*time.Minute))},
+		{"due:none", model.Not{Expr: model.Condition{Field: model.FieldDue, Op: model.OpExists}}},
+		{"-completed:none", model.Not{Expr: model.Not{Expr: model.Condition{Field: model.FieldCompleted, Op: model.OpExists}}}},
+		{"-tag:Work", model.Not{Expr: model.Condition{Field: model.FieldTag, Op: model.OpEqual, Value: "work"}}},
+		{`status:open due<2026-11-01 title:"groceries"`, model.And{status(model.StatusOpen), due(model.OpLess, day), text(model.FieldTitle, "groceries")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseQuery(tt.query, "UTC")
+			if err != nil {
+				t.Fatalf("parseQuery: %v", err)
+			}
+			if !equalExpr(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"(milk", "position 6: missing )"},
+		{"milk)", "position 5: unexpected ')'"},
+		{"()", "position 2: unexpected ')'"},
+		{`"milk`, "position 1: unterminated quote"},
+		{"milk OR", "position 8: unexpected end of filter"},
+		{"AND milk", "position 1: AND needs a term before it"},
+		{"- milk", "position 2: nothing to negate"},
+		{":milk", "position 1: missing field"},
+		{"title:", "position 7: missing value"},
+		{"label:work", `position 1: unknown field "label"`},
+		{"status:later", `position 1: invalid status "later"`},
+		{"title<b", "position 1: title only supports title:text"},
+		{"due<someday", "position 1: "},
+		{"due<none", "position 1: none can only be compared with due:none"},
+		{"is:late", `position 1: unknown value "late" for is`},
+		{strings.Repeat("(", 40) + "a" + strings.Repeat(")", 40), "nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := parseQuery(tt.query, "UTC")
+			if err == nil {
+				t.Fatal("parseQuery succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// equalExpr compares expressions, comparing times by instant
+func equalExpr(a, b model.Expr) bool {
+	ca, okA := a.(model.Condition)
+	cb, okB := b.(model.Condition)
+	if okA && okB {
+		return ca.Field == cb.Field && ca.Op == cb.Op && ca.Value == cb.Value && ca.Time.Equal(cb.Time)
+	}
+
+	switch a := a.(type) {
+	case model.And:
+		b, ok := b.(model.And)
+		return ok && equalExprs(a, b)
+	case model.Or:
+		b, ok := b.(model.Or)
+		return ok && equalExprs(a, b)
+	case model.Not:
+		b, ok := b.(model.Not)
+		return ok && equalExpr(a.Expr, b.Expr)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func equalExprs(a, b []model.Expr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalExpr(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
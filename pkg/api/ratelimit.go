@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+)
+
+// RateLimit limits the requests each client makes to a group of routes. Clients are told apart by their
+// IP address.
+type RateLimit struct {
+	// Rate is how many requests per second a client may make on average. Zero disables the limit.
+	Rate float64 `yaml:"rate"`
+	// Burst is how many requests a client may make at once. Defaults to the rate, at least 1.
+	Burst int `yaml:"burst"`
+}
+
+// The route groups whose rate can be limited
+const (
+	// groupAuth are the routes registering and signing in users
+	groupAuth = "auth"
+	// groupAPI are the routes that need a session token or API key
+	groupAPI = "api"
+	// groupAdmin are the tenant administration routes
+	groupAdmin = "admin"
+)
+
+// sweepInterval is how often the clients that are back to a full burst are forgotten
+const sweepInterval = time.Minute
+
+// rateLimiter is a token bucket per client
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mtx       sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket holds the requests a client may still make at the time it was last updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limit RateLimit) (*rateLimiter, error) {
+	if limit.Rate < 0 || limit.Burst < 0 {
+		return nil, errors.New("rate and burst must not be negative")
+	}
+	burst := float64(limit.Burst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &rateLimiter{rate: limit.Rate, burst: burst, buckets: map[string]*bucket{}}, nil
+}
+
+// allow takes a request of client from its bucket. When the bucket is empty it returns how long the
+// client has to wait for the next request.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the clients whose bucket has filled up again, which are the same as new ones
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimitMiddleware returns a middleware limiting the requests of each client to the RateLimits of group.
+// Requests over the limit are answered with 429 and a Retry-After header.
+func (a *API) rateLimitMiddleware(group string) (mux.MiddlewareFunc, error) {
+	limit := a.config.RateLimits[group]
+	limiter, err := newRateLimiter(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		if limiter.rate == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if ok, wait := limiter.allow(client, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				err := errors.New("too many requests, try again later")
+				response.Errorf(w, r, err, http.StatusTooManyRequests, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
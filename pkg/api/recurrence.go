@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+const (
+	// defaultOccurrences is the number of occurrences previewed without a count parameter
+	defaultOccurrences = 5
+	// maxOccurrences is the largest number of occurrences that can be previewed at once
+	maxOccurrences = 50
+)
+
+// recurrence is the preview of the occurrences of a recurring todo
+type recurrence struct {
+	Recurrence string `json:"recurrence"`
+	// Occurrences are the due dates following the due date of the todo, formatted like it
+	Occurrences []string `json:"occurrences"`
+}
+
+// GetRecurrence previews the next occurrences of a recurring todo, up to the count parameter.
+// A todo that does not repeat has none.
+func (a *API) GetRecurrence(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	count := defaultOccurrences
+	if value := r.URL.Query().Get("count"); value != "" {
+		count, err = strconv.Atoi(value)
+		if err != nil || count < 1 || count > maxOccurrences {
+			err := fmt.Errorf("invalid count %q, the count is between 1 and %d", value, maxOccurrences)
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	todo, err := a.app.GetTodo(ctx, id)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	etag := todoETag(todo)
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	occurrences, err := todo.Occurrences(count)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	preview := recurrence{Recurrence: todo.Recurrence, Occurrences: []string{}}
+	for _, due := range occurrences {
+		preview.Occurrences = append(preview.Occurrences, model.FormatDueDate(due, todo.AllDay, todo.TimeZone))
+	}
+
+	response.Write(w, r, preview)
+}
+
+// SkipOccurrence moves a recurring todo on to its next occurrence without completing it
+func (a *API) SkipOccurrence(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, a.app.SkipOccurrence)
+}
+
+// EndRecurrence ends the series of a recurring todo, keeping the todo
+func (a *API) EndRecurrence(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, a.app.EndRecurrence)
+}
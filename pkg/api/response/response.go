@@ -1,10 +1,13 @@
 package response
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
 )
 
 // Error represents the structure of an error message
@@ -37,6 +40,31 @@ func Errorf(w http.ResponseWriter, r *http.Request, err error, code int, message
 	return
 }
 
+// StatusCode returns the HTTP status code matching an error of the repository or the authorization of the app
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, repository.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repository.ErrUnavailable), errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Fail return an new error response with the status code matching err
+func Fail(w http.ResponseWriter, r *http.Request, err error) {
+	Errorf(w, r, err, StatusCode(err), err.Error())
+}
+
 // Write return a new json response
 func Write(w http.ResponseWriter, r *http.Request, data interface{}) {
 	logrus.WithFields(logrus.Fields{
@@ -53,3 +81,25 @@ func Write(w http.ResponseWriter, r *http.Request, data interface{}) {
 	json.NewEncoder(w).Encode(&data)
 	return
 }
+
+// Page represents one page of a list together with its position in the whole list
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	Page       int         `json:"page"`
+	Limit      int         `json:"limit"`
+	TotalPages int         `json:"totalPages"`
+	Next       string      `json:"next,omitempty"`
+	Prev       string      `json:"prev,omitempty"`
+	// NextCursor continues the list behind this page, see CursorPage
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// CursorPage represents the part of a list following a cursor
+type CursorPage struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	Limit      int         `json:"limit"`
+	Next       string      `json:"next,omitempty"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,32 @@
+package response
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: editor required", repository.ErrForbidden), http.StatusForbidden},
+		{repository.ErrNotFound, http.StatusNotFound},
+		{repository.ErrShareNotFound, http.StatusNotFound},
+		{fmt.Errorf("%w: taken", repository.ErrConflict), http.StatusConflict},
+		{repository.ErrPreconditionFailed, http.StatusPreconditionFailed},
+		{fmt.Errorf("%w: too deep", repository.ErrValidation), http.StatusUnprocessableEntity},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := StatusCode(tt.err); got != tt.want {
+			t.Errorf("StatusCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
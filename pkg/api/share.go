@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// invitation is the body of the request that shares a todo or project
+type invitation struct {
+	// Name is the name of the user to share with
+	Name string     `json:"name"`
+	Role model.Role `json:"role"`
+}
+
+// GetProjectShares lists who a project is shared with
+func (a *API) GetProjectShares(w http.ResponseWriter, r *http.Request) {
+	a.getShares(w, r, model.ResourceProject)
+}
+
+// ShareProject invites a user to a project
+func (a *API) ShareProject(w http.ResponseWriter, r *http.Request) {
+	a.share(w, r, model.ResourceProject)
+}
+
+// GetTodoShares lists who a todo is shared with
+func (a *API) GetTodoShares(w http.ResponseWriter, r *http.Request) {
+	a.getShares(w, r, model.ResourceTodo)
+}
+
+// ShareTodo invites a user to a todo
+func (a *API) ShareTodo(w http.ResponseWriter, r *http.Request) {
+	a.share(w, r, model.ResourceTodo)
+}
+
+func (a *API) getShares(w http.ResponseWriter, r *http.Request, resourceType model.ResourceType) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	shares, err := a.app.Shares(ctx, resourceType, id)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, shares)
+}
+
+func (a *API) share(w http.ResponseWriter, r *http.Request, resourceType model.ResourceType) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	var invite invitation
+	if err := json.NewDecoder(r.Body).Decode(&invite); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	share, err := a.app.Share(ctx, resourceType, id, invite.Name, invite.Role)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, share)
+}
+
+// GetShares lists the shares and invitations of the user
+func (a *API) GetShares(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	shares, err := a.app.SharedWithMe(ctx)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, shares)
+}
+
+// AcceptShare accepts an invitation
+func (a *API) AcceptShare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	share, err := a.app.AcceptShare(ctx, id)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, share)
+}
+
+// PatchShare changes the role of a share
+func (a *API) PatchShare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	var patch struct {
+		Role model.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	share, err := a.app.ChangeShare(ctx, id, patch.Role)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, share)
+}
+
+// DeleteShare revokes, declines or leaves a share
+func (a *API) DeleteShare(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := pathID(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.app.RemoveShare(ctx, id); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, "OK")
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+)
+
+// tagChange is the result of renaming or merging tags
+type tagChange struct {
+	Changed int `json:"changed"`
+}
+
+// GetTags lists every tag with the number of todos carrying it
+func (a *API) GetTags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tags, err := a.app.Tags(ctx)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.Write(w, r, tags)
+}
+
+// RenameTag renames a tag on every todo, merging it with the new name where a todo has both
+func (a *API) RenameTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tag, ok := mux.Vars(r)["tag"]
+	if !ok {
+		err := errors.New("tag is required")
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var rename struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&rename); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	changed, err := a.app.RenameTags(ctx, []string{tag}, rename.Name)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.Write(w, r, tagChange{Changed: changed})
+}
+
+// MergeTags replaces several tags with one on every todo
+func (a *API) MergeTags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var merge struct {
+		Tags []string `json:"tags"`
+		Into string   `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&merge); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	changed, err := a.app.RenameTags(ctx, merge.Tags, merge.Into)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.Write(w, r, tagChange{Changed: changed})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+)
+
+// tenant is a workspace in the tenant administration endpoints
+type tenant struct {
+	ID string `json:"id"`
+}
+
+// errNoTenants is returned by the tenant administration endpoints when the repository has a single workspace
+var errNoTenants = errors.New("the repository has no tenants")
+
+// GetTenants lists the tenants, without the default workspace
+func (a *API) GetTenants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if a.app.Tenants == nil {
+		response.Errorf(w, r, errNoTenants, http.StatusNotFound, errNoTenants.Error())
+		return
+	}
+	ids, err := a.app.Tenants.Tenants(ctx)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	tenants := make([]tenant, 0, len(ids))
+	for _, id := range ids {
+		tenants = append(tenants, tenant{ID: id})
+	}
+	response.Write(w, r, tenants)
+}
+
+// AddTenant creates a tenant with an empty workspace
+func (a *API) AddTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if a.app.Tenants == nil {
+		response.Errorf(w, r, errNoTenants, http.StatusNotFound, errNoTenants.Error())
+		return
+	}
+	var body tenant
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := a.app.Tenants.CreateTenant(ctx, body.ID); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, body)
+}
+
+// DeleteTenant deletes a tenant with all its data
+func (a *API) DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if a.app.Tenants == nil {
+		response.Errorf(w, r, errNoTenants, http.StatusNotFound, errNoTenants.Error())
+		return
+	}
+	if err := a.app.Tenants.DeleteTenant(ctx, mux.Vars(r)["tenant"]); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, "OK")
+}
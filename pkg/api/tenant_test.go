@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/app"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+func TestRequestTenant(t *testing.T) {
+	a := newTestAPI(t, &Config{TenantDomain: "todo.example.com"})
+	now := time.Now()
+	token := func(tenant string) string {
+		token, err := a.signer.Issue(auth.Claims{UserID: 1, Tenant: tenant, IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name, header, host, token, want string
+	}{
+		{"header first", " Acme ", "globex.todo.example.com", token("initech"), "acme"},
+		{"subdomain before token", "", "Globex.todo.example.com:8080", token("initech"), "globex"},
+		{"token last", "", "todo.example.com", token("initech"), "initech"},
+		{"other domain", "", "globex.example.org", "", ""},
+		{"invalid token", "", "localhost", token("initech") + "x", ""},
+		{"API key", "", "localhost", "todo_abcdef", ""},
+		{"nothing", "", "localhost", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/todos", nil)
+			r.Host = tt.host
+			if tt.header != "" {
+				r.Header.Set(defaultTenantHeader, tt.header)
+			}
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if got := a.requestTenant(r); got != tt.want {
+				t.Errorf("want tenant %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTenantToken(t *testing.T) {
+	dir := t.TempDir()
+	db, err := os.OpenFile(filepath.Join(dir, "db.json"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := repository.NewJSONRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := repository.NewJSONTenantStore(filepath.Join(dir, "tenants"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.NewTenantRepository(base, store)
+	t.Cleanup(func() { repo.Shutdown(context.Background()) })
+	for _, tenant := range []string{"acme", "globex"} {
+		if err := repo.CreateTenant(context.Background(), tenant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	todoApp := app.New(repo)
+	todoApp.Tenants = repo
+	a, err := New(&Config{}, todoApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path, tenant, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if tenant != "" {
+			r.Header.Set(defaultTenantHeader, tenant)
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		a.Router.ServeHTTP(w, r)
+		return w
+	}
+
+	// ada registers in acme and in the default workspace
+	tokens := map[string]string{}
+	for _, tenant := range []string{"acme", ""} {
+		w := serve("POST", "/api/v1/auth/register", tenant, "", `{"name":"ada","password":"correct horse"}`)
+		var session struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&session); err != nil || session.Token == "" {
+			t.Fatalf("register in %q: status %d (%v)", tenant, w.Code, err)
+		}
+		tokens[tenant] = session.Token
+	}
+
+	tests := []struct {
+		name, token, tenant string
+		want                int
+	}{
+		{"tenant of the token", "acme", "acme", http.StatusOK},
+		{"tenant from the token", "acme", "", http.StatusOK},
+		{"another tenant", "acme", "globex", http.StatusUnauthorized},
+		{"default workspace token in a tenant", "", "acme", http.StatusUnauthorized},
+		{"default workspace", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve("GET", "/api/v1/todos", tt.tenant, tokens[tt.token], ""); w.Code != tt.want {
+				t.Errorf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}
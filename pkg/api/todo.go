@@ -1,18 +1,27 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/app"
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
 )
 
 func (a *API) GetTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
 	id, ok := mux.Vars(r)["id"]
 	if !ok {
@@ -26,16 +35,18 @@ func (a *API) GetTodo(w http.ResponseWriter, r *http.Request) {
 		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
-	todo, err := a.app.Repository.Get(idInt)
+	todo, err := a.app.GetTodo(ctx, idInt)
 	if err != nil {
-		response.Errorf(w, r, err, http.StatusInternalServerError, err.Error())
+		response.Fail(w, r, err)
 		return
 	}
 
-	response.Write(w, r, todo)
+	writeTodo(w, r, todo)
 }
 
 func (a *API) AddTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	todo := model.Todo{}
 
 	dec := json.NewDecoder(r.Body)
@@ -45,8 +56,14 @@ func (a *API) AddTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.app.Repository.Create(&todo); err != nil {
-		response.Errorf(w, r, err, http.StatusInternalServerError, err.Error())
+	if todo.Status == "" {
+		todo.Status = model.StatusOpen
+	}
+	todo.CompletedAt = nil
+	todo.SetStatus(todo.Status, time.Now().UTC())
+
+	if err := a.app.CreateTodo(ctx, &todo); err != nil {
+		response.Fail(w, r, err)
 		return
 	}
 
@@ -54,105 +71,322 @@ func (a *API) AddTodo(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) GetTodos(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	unarchived := false
+	a.listTodos(ctx, w, r, model.Filter{Archived: &unarchived})
+}
+
+// listTodos writes the page of todos selected by the parameters of r, within the todos matching filter
+func (a *API) listTodos(ctx context.Context, w http.ResponseWriter, r *http.Request, filter model.Filter) {
 	params := r.URL.Query()
 
 	// filter
-	filter := params.Get("filter")
+	query, err := parseQuery(params.Get("filter"), params.Get("tz"))
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Query = query
+
+	if status := params.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			status := model.Status(strings.TrimSpace(s))
+			if !status.Valid() {
+				err := fmt.Errorf("invalid status %q", status)
+				response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+				return
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
 
-	// sort by
-	sortBy := params.Get("sortBy")
-	var sortByEnum model.SortBy
-	switch sortBy {
-	case "id":
-		sortByEnum = model.SortByID
-	case "title":
-		sortByEnum = model.SortByTitle
-	case "description":
-		sortByEnum = model.SortByDescription
-	case "dueDate":
-		sortByEnum = model.SortByDueDate
+	if tags := params.Get("tags"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			filter.Tags = append(filter.Tags, model.NormalizeTag(tag))
+		}
+	}
+	switch tagMatch := params.Get("tagMatch"); tagMatch {
+	case "", "any":
+		filter.TagMatch = model.TagMatchAny
+	case "all":
+		filter.TagMatch = model.TagMatchAll
 	default:
-		sortByEnum = model.SortByID
+		err := fmt.Errorf("invalid tagMatch %q, use any or all", tagMatch)
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	// sort type
-	sortType := params.Get("sortType")
-	var sortTypeEnum model.SortType
-	switch sortType {
-	case "asc":
-		sortTypeEnum = model.SortAscending
-	case "desc":
-		sortTypeEnum = model.SortDescending
+	if parentID := params.Get("parentId"); parentID != "" {
+		parentIDInt, err := strconv.Atoi(parentID)
+		if err != nil || parentIDInt == 0 {
+			err := fmt.Errorf("invalid parentId %q", parentID)
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.ParentID = parentIDInt
+	}
+
+	switch archived := params.Get("archived"); archived {
+	case "":
+	case "any":
+		filter.Archived = nil
 	default:
-		sortTypeEnum = model.SortAscending
+		archivedBool, err := strconv.ParseBool(archived)
+		if err != nil {
+			err := fmt.Errorf("invalid archived %q, use true, false or any", archived)
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.Archived = &archivedBool
+	}
+
+	// due date range, read in the time zone tz when no offset is given
+	tz := params.Get("tz")
+	if dueBefore := params.Get("dueBefore"); dueBefore != "" {
+		t, _, err := model.ParseDueDate(dueBefore, tz)
+		if err != nil {
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.DueBefore = &t
+	}
+	if dueAfter := params.Get("dueAfter"); dueAfter != "" {
+		t, _, err := model.ParseDueDate(dueAfter, tz)
+		if err != nil {
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.DueAfter = &t
+	}
+	if overdue := params.Get("overdue"); overdue != "" {
+		overdueBool, err := strconv.ParseBool(overdue)
+		if err != nil {
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.Overdue = overdueBool
 	}
 
-	// pagination
-	limit := params.Get("limit")
-	if limit == "" {
-		limit = "10"
+	// sort type, the direction of the sort keys without one
+	sortType := params.Get("sortType")
+	sortTypeEnum := model.SortAscending
+	if sortType != "" {
+		sortTypeEnum, err = model.ParseSortType(sortType)
+		if err != nil {
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
 
-	limitInt, err := strconv.Atoi(limit)
+	// sort by, a list of keys like dueDate:asc,title:desc
+	sortBy := params.Get("sortBy")
+	sorting, err := model.ParseSorting(sortBy, sortTypeEnum)
 	if err != nil {
 		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	page := params.Get("page")
-	if page == "" {
-		page = "1"
+	if len(sorting.Keys) == 0 {
+		sorting = model.SortByKeys(model.SortKey{SortBy: model.SortByID, SortType: sortTypeEnum})
+	}
+	if sorting.Has(model.SortBySmart) {
+		// the cursors of the list keep this moment, so that the smart order does not shift between pages
+		sorting.Now = time.Now().UTC().Truncate(time.Millisecond)
 	}
 
-	pageInt, err := strconv.Atoi(page)
+	// pagination, by page number or behind a cursor
+	pagination, err := parsePagination(params, a.config.MaxLimit)
 	if err != nil {
 		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
+	if cursor := params.Get("cursor"); cursor != "" {
+		if params.Get("page") != "" {
+			err := errors.New("page and cursor cannot be combined")
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		cursorSorting, after, err := decodeCursor(cursor)
+		if err != nil {
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		if (sortBy != "" || sortType != "") && cursorSorting.String() != sorting.String() {
+			err := errors.New("the cursor belongs to a different sortBy or sortType")
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		sorting = cursorSorting
+		pagination.After = after
+	}
 
-	sorting := model.Sorting{
-		SortBy:   sortByEnum,
-		SortType: sortTypeEnum,
+	// one more todo than asked for tells whether there is a next page behind a cursor
+	fetch := pagination
+	if fetch.After != nil {
+		fetch.Limit++
+	}
+	todos, total, err := a.app.GetTodos(ctx, filter, sorting, fetch)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
 	}
-	pagination := model.Pagination{
-		Page:  pageInt,
-		Limit: limitInt,
+
+	var page interface{}
+	if pagination.After != nil {
+		page = newCursorPage(w, r, todos, total, pagination, sorting)
+	} else {
+		page = newPage(w, r, todos, total, pagination, sorting)
 	}
 
-	todos, err := a.app.Repository.GetAll(filter, sorting, pagination)
-	if err != nil {
-		response.Errorf(w, r, err, http.StatusInternalServerError, err.Error())
+	etag := listETag(todos, total)
+	w.Header().Set("ETag", etag)
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	response.Write(w, r, todos)
+	response.Write(w, r, page)
 }
 
 func (a *API) UpdateTodo(w http.ResponseWriter, r *http.Request) {
-	todo := model.Todo{}
+	ctx := r.Context()
+
+	update := model.Todo{}
 
 	dec := json.NewDecoder(r.Body)
 
-	if err := dec.Decode(&todo); err != nil {
+	if err := dec.Decode(&update); err != nil {
 		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	_, err := a.app.Repository.Get(todo.ID)
+	// the id is taken from the path of /api/v1/todos/{id} or from the body of /api/v1/todos
+	idInt := update.ID
+	if id, ok := mux.Vars(r)["id"]; ok {
+		var err error
+		idInt, err = strconv.Atoi(id)
+		if err != nil {
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+		if update.ID != 0 && update.ID != idInt {
+			err := errors.New("id in body does not match the path")
+			response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	check := ifMatch(r)
+	todo, err := a.app.ModifyTodo(ctx, idInt, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		*todo = update
+		return nil
+	})
 	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", todoETag(todo))
+	response.Write(w, r, "OK")
+}
+
+// PatchTodo applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to a todo,
+// depending on the Content-Type of the request.
+func (a *API) PatchTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		err := errors.New("id is required")
 		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := a.app.Repository.Update(&todo); err != nil {
-		response.Errorf(w, r, err, http.StatusInternalServerError, err.Error())
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	response.Write(w, r, "OK")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var patch patchFunc
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case jsonPatchType:
+		patch, err = jsonPatch(body)
+	case mergePatchType, "application/json", "":
+		patch, err = mergePatch(body)
+	default:
+		err := fmt.Errorf("unsupported patch type %q, use %s or %s", contentType, mergePatchType, jsonPatchType)
+		response.Errorf(w, r, err, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	check := ifMatch(r)
+	todo, err := a.app.ModifyTodo(ctx, idInt, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		return applyPatch(todo, patch)
+	})
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", todoETag(todo))
+	response.Write(w, r, todoView{todo})
+}
+
+// applyPatch patches the JSON representation of a todo
+func applyPatch(todo *model.Todo, patch patchFunc) error {
+	data, err := json.Marshal(todo)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	doc, err = patch(doc)
+	if errors.Is(err, errPatchTest) {
+		return fmt.Errorf("%w: %v", repository.ErrConflict, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", repository.ErrValidation, err)
+	}
+
+	if data, err = json.Marshal(doc); err != nil {
+		return err
+	}
+	var patched model.Todo
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return fmt.Errorf("%w: %v", repository.ErrValidation, err)
+	}
+	if patched.ID != todo.ID {
+		return fmt.Errorf("%w: id cannot be changed", repository.ErrValidation)
+	}
+
+	*todo = patched
+	return nil
 }
 
 func (a *API) DeleteTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	id, ok := mux.Vars(r)["id"]
 	if !ok {
 		err := errors.New("id is required")
@@ -166,16 +400,70 @@ func (a *API) DeleteTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = a.app.Repository.Get(idInt)
+	if err := a.app.DeleteTodo(ctx, idInt, ifMatch(r)); err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+
+	response.Write(w, r, "OK")
+}
+
+func (a *API) CompleteTodo(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, a.app.CompleteTodo)
+}
+
+func (a *API) ReopenTodo(w http.ResponseWriter, r *http.Request) {
+	a.changeStatus(w, r, a.app.ReopenTodo)
+}
+
+func (a *API) changeStatus(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id int, check app.Precondition) (*model.Todo, error)) {
+	ctx := r.Context()
+
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		err := errors.New("id is required")
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	idInt, err := strconv.Atoi(id)
 	if err != nil {
 		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if err := a.app.Repository.Delete(idInt); err != nil {
-		response.Errorf(w, r, err, http.StatusInternalServerError, err.Error())
+	todo, err := change(ctx, idInt, ifMatch(r))
+	if err != nil {
+		response.Fail(w, r, err)
 		return
 	}
 
-	response.Write(w, r, "OK")
+	w.Header().Set("ETag", todoETag(todo))
+	response.Write(w, r, todoView{todo})
+}
+
+// todoView is the representation of a todo in responses, with the progress of its checklist
+type todoView struct {
+	*model.Todo
+}
+
+// MarshalJSON adds the percentage of done checklist items as progress to the fields of the todo
+func (v todoView) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(v.Todo)
+	if err != nil {
+		return nil, err
+	}
+	progress, ok := v.Todo.Progress()
+	if !ok {
+		return data, nil
+	}
+	return append(data[:len(data)-1], fmt.Sprintf(`,"progress":%d}`, progress)...), nil
+}
+
+func todoViews(todos []*model.Todo) []todoView {
+	views := make([]todoView, len(todos))
+	for i, todo := range todos {
+		views[i] = todoView{todo}
+	}
+	return views
 }
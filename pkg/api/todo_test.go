@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// testUser sends requests to an API with the session token of a registered user
+type testUser struct {
+	t   *testing.T
+	api *API
+	// ctx is the context of the user's calls to the app
+	ctx   context.Context
+	token string
+}
+
+func newTestUser(t *testing.T, a *API, name string) *testUser {
+	user, err := a.app.Register(context.Background(), name, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, err := a.signer.Issue(auth.Claims{UserID: user.ID, IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testUser{t: t, api: a, ctx: repository.WithOwner(context.Background(), user.ID), token: token}
+}
+
+// serve sends a request with the headers in header, given as name and value pairs
+func (u *testUser) serve(method, path, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+u.token)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	u.api.Router.ServeHTTP(w, r)
+	return w
+}
+
+// addTodo creates a todo of the user, open unless it has a status
+func (u *testUser) addTodo(todo *model.Todo) *model.Todo {
+	u.t.Helper()
+	if todo.Status == "" {
+		todo.Status = model.StatusOpen
+	}
+	if err := u.api.app.CreateTodo(u.ctx, todo); err != nil {
+		u.t.Fatal(err)
+	}
+	return todo
+}
+
+// getTodo reads a todo of the user from the app
+func (u *testUser) getTodo(id int) *model.Todo {
+	u.t.Helper()
+	todo, err := u.api.app.GetTodo(u.ctx, id)
+	if err != nil {
+		u.t.Fatal(err)
+	}
+	return todo
+}
+
+func TestPatchTodo(t *testing.T) {
+	u := newTestUser(t, newTestAPI(t, &Config{}), "ada")
+
+	tests := []struct {
+		name, contentType, patch string
+		want                     int
+		check                    func(todo *model.Todo) bool
+	}{
+		{"merge patch", mergePatchType, `{"title":"oat milk","description":null}`, http.StatusOK,
+			func(todo *model.Todo) bool { return todo.Title == "oat milk" && todo.Description == "" }},
+		{"merge patch as JSON", "application/json", `{"tags":["shop"]}`, http.StatusOK,
+			func(todo *model.Todo) bool { return len(todo.Tags) == 1 && todo.Tags[0] == "shop" }},
+		{"JSON patch", jsonPatchType, `[{"op":"test","path":"/title","value":"milk"},{"op":"replace","path":"/status","value":"done"}]`, http.StatusOK,
+			func(todo *model.Todo) bool { return todo.Status == model.StatusDone && todo.CompletedAt != nil }},
+		{"failed test", jsonPatchType, `[{"op":"test","path":"/title","value":"bread"},{"op":"replace","path":"/title","value":"x"}]`, http.StatusConflict,
+			func(todo *model.Todo) bool { return todo.Title == "milk" }},
+		{"out of range", jsonPatchType, `[{"op":"add","path":"/tags/5","value":"x"}]`, http.StatusUnprocessableEntity, nil},
+		{"id change by merge patch", mergePatchType, `{"id":0}`, http.StatusUnprocessableEntity, nil},
+		{"id change by JSON patch", jsonPatchType, `[{"op":"remove","path":"/id"}]`, http.StatusUnprocessableEntity, nil},
+		{"invalid todo", mergePatchType, `{"status":"sleeping"}`, http.StatusUnprocessableEntity, nil},
+		{"invalid patch", jsonPatchType, `[{"op":"rename","path":"/title"}]`, http.StatusBadRequest, nil},
+		{"malformed patch", mergePatchType, `{"title":`, http.StatusBadRequest, nil},
+		{"unknown type", "text/plain", `title=x`, http.StatusUnsupportedMediaType, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			todo := u.addTodo(&model.Todo{Title: "milk", Description: "2%"})
+			w := u.serve("PATCH", fmt.Sprintf("/api/v1/todos/%d", todo.ID), tt.patch, "Content-Type", tt.contentType)
+			if w.Code != tt.want {
+				t.Fatalf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+			if tt.check != nil && !tt.check(u.getTodo(todo.ID)) {
+				t.Errorf("unexpected todo after the patch: %+v", u.getTodo(todo.ID))
+			}
+			if w.Code != http.StatusOK {
+				if got := u.getTodo(todo.ID); got.Version != todo.Version {
+					t.Errorf("a failed patch changed the todo to version %d", got.Version)
+				}
+			}
+		})
+	}
+
+	t.Run("response", func(t *testing.T) {
+		todo := u.addTodo(&model.Todo{Title: "milk"})
+		w := u.serve("PATCH", fmt.Sprintf("/api/v1/todos/%d", todo.ID), `{"title":"bread"}`)
+		var got model.Todo
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != todo.ID || got.Title != "bread" || got.Version != todo.Version+1 {
+			t.Errorf("got %+v, want the patched todo", got)
+		}
+		if etag := w.Header().Get("ETag"); etag != todoETag(&got) {
+			t.Errorf("want ETag %s, got %s", todoETag(&got), etag)
+		}
+	})
+}
+
+func TestUpdateTodo(t *testing.T) {
+	u := newTestUser(t, newTestAPI(t, &Config{}), "ada")
+	todo := u.addTodo(&model.Todo{Title: "milk"})
+	other := u.addTodo(&model.Todo{Title: "bread"})
+
+	tests := []struct {
+		name, path, body string
+		want             int
+	}{
+		{"path id", fmt.Sprintf("/api/v1/todos/%d", todo.ID), `{"title":"oat milk","status":"open"}`, http.StatusOK},
+		{"same id in body and path", fmt.Sprintf("/api/v1/todos/%d", todo.ID), fmt.Sprintf(`{"id":%d,"title":"soy milk","status":"open"}`, todo.ID), http.StatusOK},
+		{"body id", "/api/v1/todos", fmt.Sprintf(`{"id":%d,"title":"rice milk","status":"open"}`, todo.ID), http.StatusOK},
+		{"body id not matching the path", fmt.Sprintf("/api/v1/todos/%d", todo.ID), fmt.Sprintf(`{"id":%d,"title":"x","status":"open"}`, other.ID), http.StatusBadRequest},
+		{"invalid path id", "/api/v1/todos/x", `{"title":"x","status":"open"}`, http.StatusBadRequest},
+		{"malformed body", fmt.Sprintf("/api/v1/todos/%d", todo.ID), `{"title":`, http.StatusBadRequest},
+		{"invalid todo", fmt.Sprintf("/api/v1/todos/%d", todo.ID), `{"title":"x","status":"sleeping"}`, http.StatusUnprocessableEntity},
+		{"missing todo", "/api/v1/todos/1", `{"title":"x","status":"open"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := u.serve("PUT", tt.path, tt.body); w.Code != tt.want {
+				t.Errorf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+
+	if got := u.getTodo(todo.ID); got.Title != "rice milk" {
+		t.Errorf("want title rice milk, got %q", got.Title)
+	}
+	if got := u.getTodo(other.ID); got.Title != "bread" {
+		t.Errorf("the other todo changed to %q", got.Title)
+	}
+}
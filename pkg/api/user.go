@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/api/response"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/app"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// credentials are the body of the register and login requests
+type credentials struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// session is a signed in user with the token that authorizes their requests
+type session struct {
+	Token     string      `json:"token"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+	User      *model.User `json:"user"`
+}
+
+func decodeCredentials(r *http.Request) (credentials, error) {
+	var c credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		return c, err
+	}
+	if c.Name == "" || c.Password == "" {
+		return c, errors.New("name and password are required")
+	}
+	return c, nil
+}
+
+// Register creates a user account and signs the user in
+func (a *API) Register(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	c, err := decodeCredentials(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := a.app.Register(ctx, c.Name, c.Password)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	a.writeSession(w, r, user)
+}
+
+// Login signs a user in with their name and password
+func (a *API) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	c, err := decodeCredentials(r)
+	if err != nil {
+		response.Errorf(w, r, err, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := a.app.Login(ctx, c.Name, c.Password)
+	if errors.Is(err, app.ErrInvalidCredentials) {
+		unauthorized(w, r, err, "")
+		return
+	}
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	a.writeSession(w, r, user)
+}
+
+// GetCurrentUser returns the signed in user
+func (a *API) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := a.app.CurrentUser(ctx)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, user)
+}
+
+// writeSession issues a token for user, valid for TokenTTL
+func (a *API) writeSession(w http.ResponseWriter, r *http.Request, user *model.User) {
+	ttl := a.config.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	claims := auth.Claims{UserID: user.ID, Tenant: repository.Tenant(r.Context()), IssuedAt: now, ExpiresAt: now.Add(ttl)}
+
+	token, err := a.signer.Issue(claims)
+	if err != nil {
+		response.Fail(w, r, err)
+		return
+	}
+	response.Write(w, r, session{Token: token, ExpiresAt: claims.ExpiresAt, User: user})
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// touchInterval is how old the last used time of a key must be before it is updated again, so that a
+// busy script does not write on every request
+const touchInterval = time.Minute
+
+// CreateAPIKey creates an API key for the user in ctx. The key itself is returned only here, the
+// repository keeps its hash.
+func (a *App) CreateAPIKey(ctx context.Context, name string, scope model.Scope) (*model.APIKey, string, error) {
+	secret, hash, prefix, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &model.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scope:     scope,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := a.Repository.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// AuthenticateAPIKey returns the API key secret belongs to and records that it was used at now.
+// A failure to record the use is logged, it does not fail the request.
+func (a *App) AuthenticateAPIKey(ctx context.Context, secret string, now time.Time) (*model.APIKey, error) {
+	key, err := a.Repository.GetAPIKeyByHash(ctx, auth.HashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= touchInterval {
+		usedAt := now.UTC().Truncate(time.Millisecond)
+		if err := a.Repository.TouchAPIKey(repository.WithOwner(ctx, key.OwnerID), key.ID, usedAt); err != nil {
+			logrus.WithError(err).WithField("key", key.ID).Warn("Recording the use of an API key failed")
+		} else {
+			key.LastUsedAt = &usedAt
+		}
+	}
+	return key, nil
+}
+
+// GetAPIKeys lists the API keys of the user of ctx
+func (a *App) GetAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	return a.Repository.GetAPIKeys(ctx)
+}
+
+// DeleteAPIKey revokes an API key of the user of ctx
+func (a *App) DeleteAPIKey(ctx context.Context, id int) error {
+	return a.Repository.DeleteAPIKey(ctx, id)
+}
@@ -1,9 +1,26 @@
 package app
 
-import "github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
 
 type App struct {
 	Repository repository.Repository
+	// MaxDepth is how deep subtasks nest below a top-level todo, 1 allowing subtasks but no subtasks
+	// of subtasks. Zero means DefaultMaxDepth.
+	MaxDepth int
+	// Tenants creates and deletes the workspaces of the repository. It is nil when the repository has a
+	// single workspace.
+	Tenants repository.TenantManager
+	// OwnerlessUser is the name of the user who gets the todos and projects stored before user accounts
+	// existed, see AssignOwnerless. Empty leaves them to no one.
+	OwnerlessUser string
 }
 
 func New(repository repository.Repository) *App {
@@ -11,3 +28,205 @@ func New(repository repository.Repository) *App {
 		Repository: repository,
 	}
 }
+
+// Precondition is checked against the current state of a todo before it is changed.
+// A nil Precondition always holds.
+type Precondition func(todo *model.Todo) error
+
+// Holds returns the error of check for todo
+func (check Precondition) Holds(todo *model.Todo) error {
+	if check == nil {
+		return nil
+	}
+	return check(todo)
+}
+
+// CompleteTodo marks a todo as done
+func (a *App) CompleteTodo(ctx context.Context, id int, check Precondition) (*model.Todo, error) {
+	return a.setStatus(ctx, id, model.StatusDone, check)
+}
+
+// ReopenTodo marks a completed or cancelled todo as open again
+func (a *App) ReopenTodo(ctx context.Context, id int, check Precondition) (*model.Todo, error) {
+	return a.setStatus(ctx, id, model.StatusOpen, check)
+}
+
+func (a *App) setStatus(ctx context.Context, id int, status model.Status, check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		todo.Status = status
+		return nil
+	})
+}
+
+// GetTodos returns a page of the todos of the user of ctx, or of the owner a project was authorized in,
+// that match filter, and the number of all matching todos
+func (a *App) GetTodos(ctx context.Context, filter model.Filter, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, int, error) {
+	todos, err := a.Repository.GetAll(ctx, filter, sorting, pagination)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := a.Repository.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	return todos, total, nil
+}
+
+// GetTodo returns a todo the user of ctx may view
+func (a *App) GetTodo(ctx context.Context, id int) (*model.Todo, error) {
+	ctx, err := a.AuthorizeTodo(ctx, id, model.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return a.Repository.Get(ctx, id)
+}
+
+// DeleteTodo deletes a todo if check holds for its current state. Todos with subtasks cannot be deleted.
+// Editors may delete todos.
+func (a *App) DeleteTodo(ctx context.Context, id int, check Precondition) error {
+	ctx, err := a.AuthorizeTodo(ctx, id, model.RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	subtasks, err := a.Repository.Count(ctx, model.Filter{ParentID: id})
+	if err != nil {
+		return err
+	}
+	if subtasks > 0 {
+		return fmt.Errorf("%w: the todo has %d subtasks, delete or move them first", repository.ErrConflict, subtasks)
+	}
+
+	var version int64
+	if check != nil {
+		todo, err := a.Repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := check(todo); err != nil {
+			return err
+		}
+		// deleting only the version that was checked keeps a concurrent change from slipping in between
+		version = todo.Version
+	}
+	if err := a.Repository.Delete(ctx, id, version); err != nil {
+		return err
+	}
+	a.deleteShares(ctx, model.ResourceTodo, id)
+	return nil
+}
+
+// ModifyTodo changes a todo atomically. CompletedAt follows the status set by change,
+// an empty status keeps the current one. A new parent or project is checked like in CreateTodo.
+// Completing a recurring todo creates the todo of its next occurrence, see nextInstance.
+// Editors may modify todos. The comments are kept, they change through AddComment and RemoveComment only.
+func (a *App) ModifyTodo(ctx context.Context, id int, change func(todo *model.Todo) error) (*model.Todo, error) {
+	userCtx := ctx
+	ctx, err := a.AuthorizeTodo(ctx, id, model.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	checked, checkedParent, checkedProject := false, 0, 0
+	for attempt := 0; attempt < maxMoveChecks; attempt++ {
+		var next *model.Todo
+		todo, err := a.Repository.Modify(ctx, id, func(todo *model.Todo) error {
+			status, completedAt, parentID, projectID := todo.Status, todo.CompletedAt, todo.ParentID, todo.ProjectID
+			comments, lastCommentID, lastChecklistID := todo.Comments, todo.LastCommentID, todo.LastChecklistID
+			if err := change(todo); err != nil {
+				return err
+			}
+			todo.Comments, todo.LastCommentID, todo.LastChecklistID = comments, lastCommentID, lastChecklistID
+
+			// the repository must not be read during the modification, so a new parent or project is
+			// checked outside of it
+			newParent := todo.ParentID != parentID && !(checked && todo.ParentID == checkedParent)
+			newProject := todo.ProjectID != projectID && !(checked && todo.ProjectID == checkedProject)
+			if newParent || newProject {
+				return errMoveUnchecked{parentID: todo.ParentID, projectID: todo.ProjectID, newParent: newParent, newProject: newProject}
+			}
+
+			newStatus := todo.Status
+			if newStatus == "" {
+				newStatus = status
+			}
+			todo.Status, todo.CompletedAt = status, completedAt
+			todo.SetStatus(newStatus, time.Now().UTC())
+
+			if status != model.StatusDone && todo.Status == model.StatusDone && todo.Recurrence != "" {
+				var err error
+				if next, err = nextInstance(todo); err != nil {
+					return err
+				}
+				// the series goes on with the next todo, so reopening this one does not repeat it twice
+				todo.Recurrence = ""
+			}
+			return nil
+		})
+
+		var unchecked errMoveUnchecked
+		if !errors.As(err, &unchecked) {
+			if err == nil && next != nil {
+				err = a.createNext(ctx, todo, next)
+			}
+			return todo, err
+		}
+		if err := a.checkMove(userCtx, ctx, id, unchecked); err != nil {
+			return nil, err
+		}
+		checked, checkedParent, checkedProject = true, unchecked.parentID, unchecked.projectID
+	}
+
+	return nil, fmt.Errorf("%w: the parent or project of the todo is changed concurrently", repository.ErrConflict)
+}
+
+// checkMove checks that the user of ctx may move the todo id, accessed with ownerCtx, to a new parent or
+// project. Both must belong to the owner of the todo, and the user must be an editor of them.
+func (a *App) checkMove(ctx, ownerCtx context.Context, id int, move errMoveUnchecked) error {
+	projectID, parentID := 0, 0
+	if move.newProject {
+		projectID = move.projectID
+	}
+	if move.newParent {
+		parentID = move.parentID
+	}
+	if _, err := a.authorizeMove(ctx, ownerCtx, projectID, parentID); err != nil {
+		return err
+	}
+	if !move.newParent {
+		return nil
+	}
+	return a.checkParent(ownerCtx, id, move.parentID)
+}
+
+// Tags lists the tags of the todos of the user of ctx with the number of todos carrying them
+func (a *App) Tags(ctx context.Context) ([]model.TagCount, error) {
+	return a.Repository.Tags(ctx)
+}
+
+// RenameTags merges the tags from into the tag to on every todo, creating to where needed.
+// It returns the number of changed todos.
+func (a *App) RenameTags(ctx context.Context, from []string, to string) (int, error) {
+	if len(from) == 0 {
+		return 0, fmt.Errorf("%w: no tags to rename", repository.ErrValidation)
+	}
+	to = model.NormalizeTag(to)
+	if err := model.ValidateTag(to); err != nil {
+		return 0, fmt.Errorf("%w: %v", repository.ErrValidation, err)
+	}
+
+	// todos carrying only to already are left alone
+	var tags []string
+	for _, tag := range model.NormalizeTags(from) {
+		if tag != to {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return 0, nil
+	}
+	return a.Repository.RenameTags(ctx, tags, to)
+}
@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// The repository scopes every call to the user of the context. The todos and projects shared with the user
+// belong to someone else, so once the role of the user is checked, they are accessed in the scope of their
+// owner. Todos created in a shared project or below a shared todo thereby belong to its owner, too.
+
+// AuthorizeTodo checks that the user of ctx has the role required on a todo and returns the context to
+// access the todo with. Todos the user has no role on are not found.
+func (a *App) AuthorizeTodo(ctx context.Context, id int, required model.Role) (context.Context, error) {
+	_, ctx, err := a.todoAccess(ctx, id, required)
+	return ctx, err
+}
+
+// AuthorizeProject checks that the user of ctx has the role required on a project and returns the context
+// to access the project and its todos with. Projects the user has no role on are not found.
+func (a *App) AuthorizeProject(ctx context.Context, id int, required model.Role) (context.Context, error) {
+	_, ctx, err := a.projectAccess(ctx, id, required)
+	return ctx, err
+}
+
+// authorize checks the role of the user of ctx on a todo or project
+func (a *App) authorize(ctx context.Context, resourceType model.ResourceType, id int, required model.Role) (context.Context, error) {
+	switch resourceType {
+	case model.ResourceTodo:
+		return a.AuthorizeTodo(ctx, id, required)
+	case model.ResourceProject:
+		return a.AuthorizeProject(ctx, id, required)
+	default:
+		return nil, fmt.Errorf("%w: invalid resource type %q, the type is project or todo", repository.ErrValidation, resourceType)
+	}
+}
+
+// todoAccess returns the role of the user of ctx on a todo, which is at least required, and the context
+// to access it with. Sharing a todo shares its subtasks, and sharing a project shares its todos, so the
+// role is the highest one on the todo, its ancestors and their projects.
+func (a *App) todoAccess(ctx context.Context, id int, required model.Role) (model.Role, context.Context, error) {
+	user := repository.Owner(ctx)
+	if user == 0 {
+		return model.RoleOwner, ctx, nil
+	}
+
+	unscoped := repository.WithOwner(ctx, 0)
+	todo, err := a.Repository.Get(unscoped, id)
+	if err != nil {
+		return "", nil, err
+	}
+	role := model.RoleOwner
+	if todo.OwnerID != user {
+		todoIDs, projectIDs, err := a.lineage(unscoped, todo)
+		if err != nil {
+			return "", nil, err
+		}
+		if role, err = a.sharedRole(ctx, user, todoIDs, projectIDs); err != nil {
+			return "", nil, err
+		}
+		if role == "" {
+			return "", nil, repository.ErrNotFound
+		}
+	}
+	if !role.Includes(required) {
+		return "", nil, fmt.Errorf("%w: the %s of the todo may not do this", repository.ErrForbidden, role)
+	}
+	return role, repository.WithOwner(ctx, todo.OwnerID), nil
+}
+
+// lineage returns the ids of a todo and its ancestors, at most MaxDepth of them, and of the projects they
+// are in. Ancestors of another owner are left out, since they do not share the todo.
+func (a *App) lineage(ctx context.Context, todo *model.Todo) (todoIDs, projectIDs []int, err error) {
+	for depth := 0; ; depth++ {
+		todoIDs = append(todoIDs, todo.ID)
+		if todo.ProjectID != 0 {
+			projectIDs = append(projectIDs, todo.ProjectID)
+		}
+		if todo.ParentID == 0 || depth == a.maxDepth() {
+			return todoIDs, projectIDs, nil
+		}
+
+		owner := todo.OwnerID
+		todo, err = a.Repository.Get(ctx, todo.ParentID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return todoIDs, projectIDs, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if todo.OwnerID != owner {
+			return todoIDs, projectIDs, nil
+		}
+	}
+}
+
+// projectAccess returns the role of the user of ctx on a project, which is at least required, and the
+// context to access it with
+func (a *App) projectAccess(ctx context.Context, id int, required model.Role) (model.Role, context.Context, error) {
+	user := repository.Owner(ctx)
+	if user == 0 {
+		return model.RoleOwner, ctx, nil
+	}
+
+	project, err := a.Repository.GetProject(repository.WithOwner(ctx, 0), id)
+	if err != nil {
+		return "", nil, err
+	}
+	role := model.RoleOwner
+	if project.OwnerID != user {
+		if role, err = a.sharedRole(ctx, user, nil, []int{project.ID}); err != nil {
+			return "", nil, err
+		}
+		if role == "" {
+			return "", nil, repository.ErrProjectNotFound
+		}
+	}
+	if !role.Includes(required) {
+		return "", nil, fmt.Errorf("%w: the %s of the project may not do this", repository.ErrForbidden, role)
+	}
+	return role, repository.WithOwner(ctx, project.OwnerID), nil
+}
+
+// sharedRole returns the highest role the accepted shares of user give on any of the todos todoIDs and
+// the projects projectIDs, empty for none
+func (a *App) sharedRole(ctx context.Context, user int, todoIDs, projectIDs []int) (model.Role, error) {
+	shares, err := a.Repository.GetShares(ctx, model.ShareFilter{UserID: user})
+	if err != nil {
+		return "", err
+	}
+
+	var role model.Role
+	for _, share := range shares {
+		if !share.Accepted {
+			continue
+		}
+		if share.ResourceType == model.ResourceTodo && slices.Contains(todoIDs, share.ResourceID) ||
+			share.ResourceType == model.ResourceProject && slices.Contains(projectIDs, share.ResourceID) {
+			role = role.Max(share.Role)
+		}
+	}
+	return role, nil
+}
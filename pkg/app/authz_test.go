@@ -0,0 +1,359 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// newTestApp returns an app on an empty JSON repository
+func newTestApp(t *testing.T) *App {
+	db, err := os.OpenFile(filepath.Join(t.TempDir(), "db.json"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := repository.NewJSONRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Shutdown(context.Background()) })
+	return New(repo)
+}
+
+// addUser creates a user and returns the context of their requests
+func addUser(t *testing.T, a *App, name string) context.Context {
+	user := &model.User{Name: name, PasswordHash: "hash", CreatedAt: time.Now().UTC()}
+	if err := a.Repository.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return repository.WithOwner(context.Background(), user.ID)
+}
+
+// sharing is a project and todos of ada shared with a user in a role
+type sharing struct {
+	app *App
+	// ada owns everything, user has the role on it and other has none
+	ada, user, other context.Context
+	project          *model.Project
+	// todo is in the project, loose is not in a project and has the subtask sub
+	todo, loose, sub *model.Todo
+}
+
+// newSharing shares the project, or the todo loose when onTodo is set, with user in role. An empty role
+// leaves user without share, and RoleOwner makes user ada.
+func newSharing(t *testing.T, role model.Role, onTodo bool) *sharing {
+	a := newTestApp(t)
+	s := &sharing{app: a, ada: addUser(t, a, "ada"), other: addUser(t, a, "other")}
+	s.user = addUser(t, a, "user")
+
+	s.project = &model.Project{Name: "home"}
+	if err := a.CreateProject(s.ada, s.project); err != nil {
+		t.Fatal(err)
+	}
+	s.todo = s.addTodo(t, s.ada, &model.Todo{Title: "in project", ProjectID: s.project.ID})
+	s.loose = s.addTodo(t, s.ada, &model.Todo{Title: "loose"})
+	s.sub = s.addTodo(t, s.ada, &model.Todo{Title: "sub", ParentID: s.loose.ID})
+
+	switch role {
+	case "":
+	case model.RoleOwner:
+		s.user = s.ada
+	default:
+		resourceType, id := model.ResourceProject, s.project.ID
+		if onTodo {
+			resourceType, id = model.ResourceTodo, s.loose.ID
+		}
+		share, err := a.Share(s.ada, resourceType, id, "user", role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.AcceptShare(s.user, share.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func (s *sharing) addTodo(t *testing.T, ctx context.Context, todo *model.Todo) *model.Todo {
+	todo.Status = model.StatusOpen
+	if err := s.app.CreateTodo(ctx, todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo
+}
+
+// roles are the roles a user may have, the empty one for none
+var roles = []model.Role{"", model.RoleViewer, model.RoleCommenter, model.RoleEditor, model.RoleOwner}
+
+// wantAccess returns the error a user with role gets for a request that needs required: todos and projects
+// without a role are not found, and an insufficient role is forbidden
+func wantAccess(role, required model.Role) error {
+	switch {
+	case role == "":
+		return repository.ErrNotFound
+	case !role.Includes(required):
+		return repository.ErrForbidden
+	default:
+		return nil
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	rename := func(todo *model.Todo) error {
+		todo.Title = "renamed"
+		return nil
+	}
+	ops := []struct {
+		name     string
+		required model.Role
+		run      func(s *sharing) error
+	}{
+		{"GetTodo", model.RoleViewer, func(s *sharing) error {
+			_, err := s.app.GetTodo(s.user, s.todo.ID)
+			return err
+		}},
+		{"GetProject", model.RoleViewer, func(s *sharing) error {
+			_, err := s.app.GetProject(s.user, s.project.ID)
+			return err
+		}},
+		{"GetProjects", model.RoleViewer, func(s *sharing) error {
+			projects, err := s.app.GetProjects(s.user)
+			if err != nil {
+				return err
+			}
+			for _, project := range projects {
+				if project.ID == s.project.ID {
+					return nil
+				}
+			}
+			return repository.ErrProjectNotFound
+		}},
+		{"AddComment", model.RoleCommenter, func(s *sharing) error {
+			_, err := s.app.AddComment(s.user, s.todo.ID, "nice", nil)
+			return err
+		}},
+		{"ModifyTodo", model.RoleEditor, func(s *sharing) error {
+			_, err := s.app.ModifyTodo(s.user, s.todo.ID, rename)
+			return err
+		}},
+		{"DeleteTodo", model.RoleEditor, func(s *sharing) error {
+			return s.app.DeleteTodo(s.user, s.todo.ID, nil)
+		}},
+		{"UpdateProject", model.RoleEditor, func(s *sharing) error {
+			_, err := s.app.UpdateProject(s.user, s.project.ID, &model.Project{Name: "work"}, nil)
+			return err
+		}},
+		{"Share", model.RoleOwner, func(s *sharing) error {
+			_, err := s.app.Share(s.user, model.ResourceProject, s.project.ID, "other", model.RoleViewer)
+			return err
+		}},
+		{"DeleteProject", model.RoleOwner, func(s *sharing) error {
+			return s.app.DeleteProject(s.user, s.project.ID, nil)
+		}},
+	}
+
+	for _, op := range ops {
+		for _, role := range roles {
+			name := string(role)
+			if name == "" {
+				name = "none"
+			}
+			t.Run(op.name+"/"+name, func(t *testing.T) {
+				s := newSharing(t, role, false)
+				err := op.run(s)
+				if want := wantAccess(role, op.required); !errors.Is(err, want) || want == nil && err != nil {
+					t.Errorf("want %v, got %v", want, err)
+				}
+			})
+		}
+	}
+}
+
+func TestAuthorizationOfSubtasks(t *testing.T) {
+	for _, role := range roles[1:4] {
+		t.Run(string(role), func(t *testing.T) {
+			s := newSharing(t, role, true)
+
+			// sharing a todo shares its subtasks
+			_, err := s.app.GetTodo(s.user, s.sub.ID)
+			if err != nil {
+				t.Errorf("get the subtask of a shared todo: %v", err)
+			}
+			_, err = s.app.ModifyTodo(s.user, s.sub.ID, func(todo *model.Todo) error { return nil })
+			if want := wantAccess(role, model.RoleEditor); !errors.Is(err, want) || want == nil && err != nil {
+				t.Errorf("modify the subtask: want %v, got %v", want, err)
+			}
+			// the project is not shared by sharing a todo
+			if _, err := s.app.GetTodo(s.user, s.todo.ID); !errors.Is(err, repository.ErrNotFound) {
+				t.Errorf("get a todo that is not shared: want ErrNotFound, got %v", err)
+			}
+
+			// editors create subtasks that belong to the owner and stay visible to them
+			sub := &model.Todo{Title: "new sub", Status: model.StatusOpen, ParentID: s.sub.ID}
+			err = s.app.CreateTodo(s.user, sub)
+			if want := wantAccess(role, model.RoleEditor); !errors.Is(err, want) || want == nil && err != nil {
+				t.Fatalf("create a subtask: want %v, got %v", want, err)
+			}
+			if err != nil {
+				return
+			}
+			got, err := s.app.GetTodo(s.user, sub.ID)
+			if err != nil {
+				t.Fatalf("get the new subtask: %v", err)
+			}
+			if got.OwnerID != repository.Owner(s.ada) {
+				t.Errorf("want the subtask owned by ada, got owner %d", got.OwnerID)
+			}
+		})
+	}
+}
+
+func TestAuthorizationOfMoves(t *testing.T) {
+	s := newSharing(t, model.RoleEditor, false)
+	// a todo of ada the editor has no role on
+	private := s.addTodo(t, s.ada, &model.Todo{Title: "private"})
+	otherProject := &model.Project{Name: "other's"}
+	if err := s.app.CreateProject(s.other, otherProject); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"create below an unshared parent", func() error {
+			return s.app.CreateTodo(s.user, &model.Todo{Title: "t", Status: model.StatusOpen, ProjectID: s.project.ID, ParentID: private.ID})
+		}, repository.ErrValidation},
+		{"create in the user's project below a shared parent", func() error {
+			mine := &model.Project{Name: "mine"}
+			if err := s.app.CreateProject(s.user, mine); err != nil {
+				return err
+			}
+			return s.app.CreateTodo(s.user, &model.Todo{Title: "t", Status: model.StatusOpen, ProjectID: mine.ID, ParentID: s.todo.ID})
+		}, repository.ErrValidation},
+		{"move below an unshared parent", func() error {
+			_, err := s.app.ModifyTodo(s.user, s.todo.ID, func(todo *model.Todo) error {
+				todo.ParentID = private.ID
+				return nil
+			})
+			return err
+		}, repository.ErrValidation},
+		{"move to a project of someone else", func() error {
+			_, err := s.app.ModifyTodo(s.user, s.todo.ID, func(todo *model.Todo) error {
+				todo.ProjectID = otherProject.ID
+				return nil
+			})
+			return err
+		}, repository.ErrValidation},
+		{"move within the shared project", func() error {
+			sibling := s.addTodo(t, s.user, &model.Todo{Title: "sibling", ProjectID: s.project.ID})
+			_, err := s.app.ModifyTodo(s.user, s.todo.ID, func(todo *model.Todo) error {
+				todo.ParentID = sibling.ID
+				return nil
+			})
+			return err
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if !errors.Is(err, tt.want) || tt.want == nil && err != nil {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCommentRemoval(t *testing.T) {
+	s := newSharing(t, model.RoleCommenter, false)
+	editor := addUser(t, s.app, "editor")
+	share, err := s.app.Share(s.ada, model.ResourceProject, s.project.ID, "editor", model.RoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.app.AcceptShare(editor, share.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	comment := func(ctx context.Context) int {
+		todo, err := s.app.AddComment(ctx, s.todo.ID, "hi", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return todo.Comments[len(todo.Comments)-1].ID
+	}
+	adas, own, commenters := comment(s.ada), comment(s.user), comment(s.user)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		comment int
+		want    error
+	}{
+		{"commenter removes another's comment", s.user, adas, repository.ErrForbidden},
+		{"commenter removes their own comment", s.user, own, nil},
+		{"editor removes another's comment", editor, commenters, nil},
+		{"removed comment", editor, commenters, repository.ErrCommentNotFound},
+		{"stranger", s.other, adas, repository.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.app.RemoveComment(tt.ctx, s.todo.ID, tt.comment, nil)
+			if !errors.Is(err, tt.want) || tt.want == nil && err != nil {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestShareLifecycle(t *testing.T) {
+	s := newSharing(t, "", false)
+	share, err := s.app.Share(s.ada, model.ResourceProject, s.project.ID, "user", model.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an invitation grants nothing before it is accepted
+	if _, err := s.app.GetProject(s.user, s.project.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("get before accepting: want ErrNotFound, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"owner accepts", func() error { _, err := s.app.AcceptShare(s.ada, share.ID); return err }, repository.ErrForbidden},
+		{"stranger accepts", func() error { _, err := s.app.AcceptShare(s.other, share.ID); return err }, repository.ErrShareNotFound},
+		{"invitee accepts", func() error { _, err := s.app.AcceptShare(s.user, share.ID); return err }, nil},
+		{"invitee changes the role", func() error {
+			_, err := s.app.ChangeShare(s.user, share.ID, model.RoleEditor)
+			return err
+		}, repository.ErrForbidden},
+		{"owner changes the role", func() error {
+			_, err := s.app.ChangeShare(s.ada, share.ID, model.RoleEditor)
+			return err
+		}, nil},
+		{"editor edits", func() error {
+			_, err := s.app.UpdateProject(s.user, s.project.ID, &model.Project{Name: "edited"}, nil)
+			return err
+		}, nil},
+		{"stranger removes", func() error { return s.app.RemoveShare(s.other, share.ID) }, repository.ErrShareNotFound},
+		{"invitee leaves", func() error { return s.app.RemoveShare(s.user, share.ID) }, nil},
+		{"left project", func() error { _, err := s.app.GetProject(s.user, s.project.ID); return err }, repository.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if !errors.Is(err, tt.want) || tt.want == nil && err != nil {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// AddChecklistItem inserts an item into the checklist of a todo at position, or appends it when position
+// is outside of the checklist. The item gets the next free id.
+func (a *App) AddChecklistItem(ctx context.Context, id int, item model.ChecklistItem, position int, check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		item.ID = 0
+		at := position
+		if at < 0 || at > len(todo.Checklist) {
+			at = len(todo.Checklist)
+		}
+		checklist := append([]model.ChecklistItem{}, todo.Checklist[:at]...)
+		checklist = append(checklist, item)
+		todo.Checklist = append(checklist, todo.Checklist[at:]...)
+		return nil
+	})
+}
+
+// ChangeChecklistItem changes an item of the checklist of a todo
+func (a *App) ChangeChecklistItem(ctx context.Context, id, itemID int, change func(item *model.ChecklistItem), check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		i := todo.ChecklistIndex(itemID)
+		if i < 0 {
+			return repository.ErrChecklistItemNotFound
+		}
+		change(&todo.Checklist[i])
+		todo.Checklist[i].ID = itemID
+		return nil
+	})
+}
+
+// RemoveChecklistItem removes an item from the checklist of a todo
+func (a *App) RemoveChecklistItem(ctx context.Context, id, itemID int, check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		i := todo.ChecklistIndex(itemID)
+		if i < 0 {
+			return repository.ErrChecklistItemNotFound
+		}
+		todo.Checklist = append(todo.Checklist[:i:i], todo.Checklist[i+1:]...)
+		return nil
+	})
+}
+
+// ReorderChecklist puts the checklist items of a todo in the order of itemIDs, which must name every item once
+func (a *App) ReorderChecklist(ctx context.Context, id int, itemIDs []int, check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		if len(itemIDs) != len(todo.Checklist) {
+			return fmt.Errorf("%w: the order has %d items, the checklist %d", repository.ErrValidation, len(itemIDs), len(todo.Checklist))
+		}
+
+		checklist := make([]model.ChecklistItem, 0, len(itemIDs))
+		seen := map[int]bool{}
+		for _, itemID := range itemIDs {
+			i := todo.ChecklistIndex(itemID)
+			if i < 0 || seen[itemID] {
+				return fmt.Errorf("%w: the order must name every checklist item once, not %d", repository.ErrValidation, itemID)
+			}
+			seen[itemID] = true
+			checklist = append(checklist, todo.Checklist[i])
+		}
+		todo.Checklist = checklist
+		return nil
+	})
+}
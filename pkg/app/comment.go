@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// AddComment adds a comment of the user of ctx to a todo. Commenters may comment.
+func (a *App) AddComment(ctx context.Context, id int, text string, check Precondition) (*model.Todo, error) {
+	if err := model.ValidateComment(text); err != nil {
+		return nil, fmt.Errorf("%w: %v", repository.ErrValidation, err)
+	}
+	author := repository.Owner(ctx)
+	ctx, err := a.AuthorizeTodo(ctx, id, model.RoleCommenter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return a.Repository.Modify(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		comment := model.Comment{ID: todo.NextCommentID(), AuthorID: author, Text: text, CreatedAt: now}
+		todo.Comments = append(todo.Comments[:len(todo.Comments):len(todo.Comments)], comment)
+		return nil
+	})
+}
+
+// RemoveComment removes a comment from a todo. Commenters remove their own comments, editors any.
+func (a *App) RemoveComment(ctx context.Context, id, commentID int, check Precondition) (*model.Todo, error) {
+	user := repository.Owner(ctx)
+	role, ctx, err := a.todoAccess(ctx, id, model.RoleCommenter)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Repository.Modify(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		i := todo.CommentIndex(commentID)
+		if i < 0 {
+			return repository.ErrCommentNotFound
+		}
+		if todo.Comments[i].AuthorID != user && !role.Includes(model.RoleEditor) {
+			return fmt.Errorf("%w: the comment is not yours", repository.ErrForbidden)
+		}
+		todo.Comments = append(todo.Comments[:i:i], todo.Comments[i+1:]...)
+		return nil
+	})
+}
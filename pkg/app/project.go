@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// ProjectPrecondition is checked against the current state of a project before it is changed.
+// A nil ProjectPrecondition always holds.
+type ProjectPrecondition func(project *model.Project) error
+
+// Holds returns the error of check for project
+func (check ProjectPrecondition) Holds(project *model.Project) error {
+	if check == nil {
+		return nil
+	}
+	return check(project)
+}
+
+// CreateProject creates a project of the user of ctx
+func (a *App) CreateProject(ctx context.Context, project *model.Project) error {
+	project.Archived = false
+	return a.Repository.CreateProject(ctx, project)
+}
+
+// GetProject returns a project the user of ctx may view
+func (a *App) GetProject(ctx context.Context, id int) (*model.Project, error) {
+	ctx, err := a.AuthorizeProject(ctx, id, model.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return a.Repository.GetProject(ctx, id)
+}
+
+// GetProjects returns the projects of the user of ctx followed by the projects shared with them
+func (a *App) GetProjects(ctx context.Context) ([]*model.Project, error) {
+	projects, err := a.Repository.GetProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user := repository.Owner(ctx)
+	if user == 0 {
+		return projects, nil
+	}
+	shares, err := a.Repository.GetShares(ctx, model.ShareFilter{ResourceType: model.ResourceProject, UserID: user})
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if !share.Accepted {
+			continue
+		}
+		project, err := a.Repository.GetProject(repository.WithOwner(ctx, 0), share.ResourceID)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// UpdateProject replaces the name and description of a project. It is archived only by ArchiveProject.
+// Editors may update projects.
+func (a *App) UpdateProject(ctx context.Context, id int, update *model.Project, check ProjectPrecondition) (*model.Project, error) {
+	ctx, err := a.AuthorizeProject(ctx, id, model.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	return a.Repository.ModifyProject(ctx, id, func(project *model.Project) error {
+		if err := check.Holds(project); err != nil {
+			return err
+		}
+		project.Name = update.Name
+		project.Description = update.Description
+		return nil
+	})
+}
+
+// ArchiveProject archives or unarchives a project together with its todos. Editors may archive projects.
+func (a *App) ArchiveProject(ctx context.Context, id int, archived bool, check ProjectPrecondition) (*model.Project, error) {
+	ctx, err := a.AuthorizeProject(ctx, id, model.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	return a.Repository.ModifyProject(ctx, id, func(project *model.Project) error {
+		if err := check.Holds(project); err != nil {
+			return err
+		}
+		project.Archived = archived
+		return nil
+	})
+}
+
+// DeleteProject deletes a project and its todos if check holds for the current state of the project.
+// Only the owner deletes a project.
+func (a *App) DeleteProject(ctx context.Context, id int, check ProjectPrecondition) error {
+	ctx, err := a.AuthorizeProject(ctx, id, model.RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	if check != nil {
+		project, err := a.Repository.GetProject(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := check(project); err != nil {
+			return err
+		}
+		version = project.Version
+	}
+	if err := a.Repository.DeleteProject(ctx, id, version); err != nil {
+		return err
+	}
+	a.deleteShares(ctx, model.ResourceProject, id)
+	return nil
+}
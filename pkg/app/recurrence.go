@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+var errNotRecurring = fmt.Errorf("%w: the todo does not repeat", repository.ErrConflict)
+
+// nextInstance returns the open todo of the occurrence that follows a completed recurring todo, or nil when
+// the series ends with it. The next todo is due on the next occurrence after the due date of the completed
+// one, no matter when it was completed.
+func nextInstance(todo *model.Todo) (*model.Todo, error) {
+	next := todo.Clone()
+	next.ID, next.Version = 0, 0
+	next.Status, next.CompletedAt = model.StatusOpen, nil
+	next.Comments, next.LastCommentID = nil, 0
+	ok, err := next.Advance()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", repository.ErrValidation, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return next, nil
+}
+
+// createNext creates the todo of the next occurrence after completed. When that fails, the recurrence is
+// put back on the completed todo, so that the series is not lost.
+func (a *App) createNext(ctx context.Context, completed, next *model.Todo) error {
+	err := a.Repository.Create(ctx, next)
+	if err == nil {
+		return nil
+	}
+
+	rule := next.Recurrence
+	_, restoreErr := a.Repository.Modify(ctx, completed.ID, func(todo *model.Todo) error {
+		if todo.Recurrence == "" {
+			todo.Recurrence = rule
+		}
+		return nil
+	})
+	if restoreErr != nil {
+		logrus.WithError(restoreErr).WithFields(logrus.Fields{"todo": completed.ID, "recurrence": rule}).
+			Error("Putting the recurrence back on a completed todo failed, the series ends")
+	}
+	return fmt.Errorf("the todo is completed, but its next occurrence could not be created: %w", err)
+}
+
+// SkipOccurrence moves a recurring todo on to its next occurrence without completing it
+func (a *App) SkipOccurrence(ctx context.Context, id int, check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		if todo.Recurrence == "" {
+			return errNotRecurring
+		}
+		ok, err := todo.Advance()
+		if err != nil {
+			return fmt.Errorf("%w: %v", repository.ErrValidation, err)
+		}
+		if !ok {
+			return fmt.Errorf("%w: the series has no further occurrence", repository.ErrConflict)
+		}
+		return nil
+	})
+}
+
+// EndRecurrence ends the series of a recurring todo. The todo is kept as the last occurrence.
+func (a *App) EndRecurrence(ctx context.Context, id int, check Precondition) (*model.Todo, error) {
+	return a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+		if err := check.Holds(todo); err != nil {
+			return err
+		}
+		if todo.Recurrence == "" {
+			return errNotRecurring
+		}
+		todo.Recurrence = ""
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// Share invites the user called name to a todo or project with role. The share grants the role once the
+// user accepts it. Only the owner shares.
+func (a *App) Share(ctx context.Context, resourceType model.ResourceType, id int, name string, role model.Role) (*model.Share, error) {
+	if _, err := a.authorize(ctx, resourceType, id, model.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	user, err := a.Repository.GetUserByName(ctx, name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("%w: user %q does not exist", repository.ErrValidation, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == repository.Owner(ctx) {
+		return nil, fmt.Errorf("%w: the %s is yours already", repository.ErrValidation, resourceType)
+	}
+
+	share := &model.Share{
+		ResourceType: resourceType,
+		ResourceID:   id,
+		UserID:       user.ID,
+		Role:         role,
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := a.Repository.CreateShare(ctx, share); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// Shares returns the shares of a todo or project, which everyone with a role on it may see
+func (a *App) Shares(ctx context.Context, resourceType model.ResourceType, id int) ([]*model.Share, error) {
+	if _, err := a.authorize(ctx, resourceType, id, model.RoleViewer); err != nil {
+		return nil, err
+	}
+	return a.Repository.GetShares(ctx, model.ShareFilter{ResourceType: resourceType, ResourceID: id})
+}
+
+// SharedWithMe returns the shares and invitations of the user of ctx
+func (a *App) SharedWithMe(ctx context.Context) ([]*model.Share, error) {
+	return a.Repository.GetShares(ctx, model.ShareFilter{UserID: repository.Owner(ctx)})
+}
+
+// AcceptShare accepts an invitation of the user of ctx
+func (a *App) AcceptShare(ctx context.Context, id int) (*model.Share, error) {
+	share, _, err := a.shareAccess(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if share.UserID != repository.Owner(ctx) {
+		return nil, fmt.Errorf("%w: only the invited user accepts an invitation", repository.ErrForbidden)
+	}
+
+	share.Accepted = true
+	if err := a.Repository.UpdateShare(ctx, share); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// ChangeShare changes the role of a share. Only the owner of the todo or project changes roles.
+func (a *App) ChangeShare(ctx context.Context, id int, role model.Role) (*model.Share, error) {
+	share, owner, err := a.shareAccess(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !owner {
+		return nil, fmt.Errorf("%w: only the owner changes the role of a share", repository.ErrForbidden)
+	}
+
+	share.Role = role
+	if err := a.Repository.UpdateShare(ctx, share); err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// RemoveShare revokes a share. The owner revokes shares, the invited user declines or leaves them.
+func (a *App) RemoveShare(ctx context.Context, id int) error {
+	if _, _, err := a.shareAccess(ctx, id); err != nil {
+		return err
+	}
+	return a.Repository.DeleteShare(ctx, id)
+}
+
+// shareAccess returns a share the user of ctx owns the resource of or is invited by, and whether they
+// are the owner. Other shares are not found.
+func (a *App) shareAccess(ctx context.Context, id int) (*model.Share, bool, error) {
+	share, err := a.Repository.GetShare(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = a.authorize(ctx, share.ResourceType, share.ResourceID, model.RoleOwner)
+	switch {
+	case err == nil:
+		return share, true, nil
+	case share.UserID == repository.Owner(ctx):
+		return share, false, nil
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrForbidden):
+		return nil, false, repository.ErrShareNotFound
+	default:
+		return nil, false, err
+	}
+}
+
+// deleteShares deletes the shares of a deleted todo or project. A failure is logged, since the shares
+// grant nothing without the resource.
+func (a *App) deleteShares(ctx context.Context, resourceType model.ResourceType, id int) {
+	shares, err := a.Repository.GetShares(ctx, model.ShareFilter{ResourceType: resourceType, ResourceID: id})
+	for _, share := range shares {
+		if err != nil {
+			break
+		}
+		if err = a.Repository.DeleteShare(ctx, share.ID); errors.Is(err, repository.ErrNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		logrus.WithError(err).WithField(string(resourceType), id).Warn("Deleting the shares of a deleted resource failed")
+	}
+}
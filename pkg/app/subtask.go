@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// DefaultMaxDepth is the MaxDepth of an App without one
+const DefaultMaxDepth = 3
+
+// maxMoveChecks bounds how often ModifyTodo checks a new parent or project when the todo is moved concurrently
+const maxMoveChecks = 3
+
+// errMoveUnchecked stops a modification that moves a todo to a parent or project which was not checked yet
+type errMoveUnchecked struct {
+	parentID, projectID int
+	// newParent and newProject tell which of the two changed
+	newParent, newProject bool
+}
+
+func (e errMoveUnchecked) Error() string {
+	return fmt.Sprintf("parent %d and project %d are not checked", e.parentID, e.projectID)
+}
+
+func (a *App) maxDepth() int {
+	if a.MaxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return a.MaxDepth
+}
+
+// CreateTodo creates a todo, checking its parent first. Todos are created in a shared project or below a
+// shared todo by its editors, and belong to its owner.
+func (a *App) CreateTodo(ctx context.Context, todo *model.Todo) error {
+	ctx, err := a.authorizeMove(ctx, nil, todo.ProjectID, todo.ParentID)
+	if err != nil {
+		return err
+	}
+	todo.Comments, todo.LastCommentID = nil, 0
+
+	if err := a.checkParent(ctx, 0, todo.ParentID); err != nil {
+		return err
+	}
+	return a.Repository.Create(ctx, todo)
+}
+
+// authorizeMove checks that the user of ctx is an editor of the project projectID and the todo parentID,
+// zero for none, and that both belong to the owner of ownerCtx, the context of the todo that moves. It
+// returns the context of their owner. New todos pass a nil ownerCtx and belong to the owner of the project
+// and parent, or to the user when there are none.
+func (a *App) authorizeMove(ctx, ownerCtx context.Context, projectID, parentID int) (context.Context, error) {
+	for _, target := range []struct {
+		resourceType model.ResourceType
+		id           int
+	}{{model.ResourceProject, projectID}, {model.ResourceTodo, parentID}} {
+		if target.id == 0 {
+			continue
+		}
+		targetCtx, err := a.authorize(ctx, target.resourceType, target.id, model.RoleEditor)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %v", repository.ErrValidation, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if ownerCtx == nil {
+			ownerCtx = targetCtx
+		} else if repository.Owner(targetCtx) != repository.Owner(ownerCtx) {
+			return nil, fmt.Errorf("%w: the %s %d belongs to someone else", repository.ErrValidation, target.resourceType, target.id)
+		}
+	}
+	if ownerCtx == nil {
+		return ctx, nil
+	}
+	return ownerCtx, nil
+}
+
+// checkParent checks that the todo id, zero for a new todo, can become a subtask of parentID without
+// becoming its own ancestor and without subtasks nesting deeper than MaxDepth. A todo whose parent
+// is gone counts as a top-level todo. Concurrent moves of other todos are not taken into account.
+func (a *App) checkParent(ctx context.Context, id, parentID int) error {
+	if parentID == 0 {
+		return nil
+	}
+	maxDepth := a.maxDepth()
+
+	// depth is the number of ancestors the todo gets
+	depth := 0
+	for ancestor := parentID; ancestor != 0; {
+		if ancestor == id {
+			return fmt.Errorf("%w: a todo cannot be a subtask of itself or its subtasks", repository.ErrValidation)
+		}
+		depth++
+		if depth > maxDepth {
+			return fmt.Errorf("%w: subtasks nest at most %d levels deep", repository.ErrValidation, maxDepth)
+		}
+
+		todo, err := a.Repository.Get(ctx, ancestor)
+		if errors.Is(err, repository.ErrNotFound) {
+			if ancestor == parentID {
+				return fmt.Errorf("%w: parent todo %d does not exist", repository.ErrValidation, parentID)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		ancestor = todo.ParentID
+	}
+
+	if id == 0 {
+		return nil
+	}
+	height, err := a.subtaskLevels(ctx, id, maxDepth-depth)
+	if err != nil {
+		return err
+	}
+	if depth+height > maxDepth {
+		return fmt.Errorf("%w: subtasks nest at most %d levels deep", repository.ErrValidation, maxDepth)
+	}
+	return nil
+}
+
+// subtaskLevels returns the number of levels of subtasks below the todo id, counting at most limit+1
+func (a *App) subtaskLevels(ctx context.Context, id int, limit int) (int, error) {
+	level := []int{id}
+	levels := 0
+	for levels <= limit {
+		// the subtasks of a whole level are read at once
+		or := model.Or{}
+		for _, parent := range level {
+			or = append(or, model.Condition{Field: model.FieldParent, Op: model.OpEqual, Value: strconv.Itoa(parent)})
+		}
+		subtasks, err := a.Repository.GetAll(ctx, model.Filter{Query: or}, model.Sorting{}, model.Pagination{})
+		if err != nil {
+			return 0, err
+		}
+		if len(subtasks) == 0 {
+			break
+		}
+
+		levels++
+		level = level[:0]
+		for _, subtask := range subtasks {
+			level = append(level, subtask.ID)
+		}
+	}
+	return levels, nil
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// addSubtask creates a todo below parent, zero for a top-level todo
+func addSubtask(t *testing.T, a *App, ctx context.Context, parent int) int {
+	todo := &model.Todo{Title: "step", Status: model.StatusOpen, ParentID: parent}
+	if err := a.CreateTodo(ctx, todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo.ID
+}
+
+func TestSubtasks(t *testing.T) {
+	a := newTestApp(t)
+	a.MaxDepth = 2
+	ctx := addUser(t, a, "ada")
+
+	// root has the subtask child, which has the subtask grandchild at the deepest level
+	root := addSubtask(t, a, ctx, 0)
+	child := addSubtask(t, a, ctx, root)
+	grandchild := addSubtask(t, a, ctx, child)
+	// other has the subtask otherChild
+	other := addSubtask(t, a, ctx, 0)
+	otherChild := addSubtask(t, a, ctx, other)
+
+	move := func(id, parent int) func() error {
+		return func() error {
+			_, err := a.ModifyTodo(ctx, id, func(todo *model.Todo) error {
+				todo.ParentID = parent
+				return nil
+			})
+			return err
+		}
+	}
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"create below the deepest level", func() error {
+			return a.CreateTodo(ctx, &model.Todo{Title: "too deep", Status: model.StatusOpen, ParentID: grandchild})
+		}, repository.ErrValidation},
+		{"create below a missing parent", func() error {
+			return a.CreateTodo(ctx, &model.Todo{Title: "orphan", Status: model.StatusOpen, ParentID: -1})
+		}, repository.ErrValidation},
+		{"move below itself", move(root, root), repository.ErrValidation},
+		{"move below its subtask", move(root, child), repository.ErrValidation},
+		{"move below its deepest subtask", move(root, grandchild), repository.ErrValidation},
+		{"move a subtree below the deepest level", move(other, child), repository.ErrValidation},
+		{"move a subtree one level down", move(other, root), nil},
+		{"move a leaf to the deepest level", move(otherChild, child), nil},
+		{"move a subtree to the top", move(child, 0), nil},
+		{"move a subtree below a subtask", move(child, other), repository.ErrValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			if !errors.Is(err, tt.want) || tt.want == nil && err != nil {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSubtaskLevels(t *testing.T) {
+	a := newTestApp(t)
+	ctx := addUser(t, a, "ada")
+	root := addSubtask(t, a, ctx, 0)
+	parent := root
+	for i := 0; i < 3; i++ {
+		parent = addSubtask(t, a, ctx, parent)
+	}
+	addSubtask(t, a, ctx, root)
+
+	tests := []struct {
+		limit, want int
+	}{{0, 1}, {1, 2}, {2, 3}, {3, 3}, {10, 3}}
+	for _, tt := range tests {
+		levels, err := a.subtaskLevels(ctx, root, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if levels != tt.want {
+			t.Errorf("subtaskLevels with limit %d = %d, want %d", tt.limit, levels, tt.want)
+		}
+	}
+}
+
+func TestModifyTodoRechecksMoves(t *testing.T) {
+	a := newTestApp(t)
+	ctx := addUser(t, a, "ada")
+	todo := addSubtask(t, a, ctx, 0)
+	// the todo moves below parents[0] first, then below each of the others
+	parents := make([]int, maxMoveChecks+1)
+	for i := range parents {
+		parents[i] = addSubtask(t, a, ctx, 0)
+	}
+
+	// a change that picks the same parent again is applied once the parent is checked
+	calls := 0
+	changed, err := a.ModifyTodo(ctx, todo, func(todo *model.Todo) error {
+		calls++
+		todo.ParentID = parents[0]
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.ParentID != parents[0] || calls != 2 {
+		t.Errorf("moved below %d after %d calls, want below %d after 2", changed.ParentID, calls, parents[0])
+	}
+
+	// a change that picks another parent on every call, as if the todo was moved concurrently, gives up
+	calls = 0
+	_, err = a.ModifyTodo(ctx, todo, func(todo *model.Todo) error {
+		calls++
+		todo.ParentID = parents[calls]
+		return nil
+	})
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("want ErrConflict, got %v", err)
+	}
+	if calls != maxMoveChecks {
+		t.Errorf("change called %d times, want %d", calls, maxMoveChecks)
+	}
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/auth"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// ErrInvalidCredentials is returned by Login when the user does not exist or the password is wrong.
+// Which of the two is not told, so that user names cannot be probed.
+var ErrInvalidCredentials = errors.New("invalid user name or password")
+
+// Register creates a user with a bcrypt hash of password
+func (a *App) Register(ctx context.Context, name, password string) (*model.User, error) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", repository.ErrValidation, err)
+	}
+
+	user := &model.User{
+		Name:         name,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := a.Repository.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	if a.OwnerlessUser != "" && user.Name == model.NormalizeUserName(a.OwnerlessUser) {
+		// the user is registered anyway, the next AssignOwnerless tries again
+		if _, err := a.assignOwnerless(ctx, user); err != nil {
+			logrus.WithError(err).Warn("Could not assign the todos and projects without owner")
+		}
+	}
+	return user, nil
+}
+
+// AssignOwnerless gives the todos and projects stored before user accounts existed to OwnerlessUser.
+// It returns their number, zero when OwnerlessUser is not set or not registered yet; Register assigns
+// them once it is.
+func (a *App) AssignOwnerless(ctx context.Context) (int, error) {
+	if a.OwnerlessUser == "" {
+		return 0, nil
+	}
+	user, err := a.Repository.GetUserByName(ctx, a.OwnerlessUser)
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return a.assignOwnerless(ctx, user)
+}
+
+func (a *App) assignOwnerless(ctx context.Context, user *model.User) (int, error) {
+	n, err := a.Repository.AssignOwnerless(ctx, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		logrus.WithFields(logrus.Fields{"user": user.Name, "count": n}).Info("Assigned the todos and projects without owner")
+	}
+	return n, nil
+}
+
+// Login returns the user with name if password is theirs
+func (a *App) Login(ctx context.Context, name, password string) (*model.User, error) {
+	user, err := a.Repository.GetUserByName(ctx, name)
+	if errors.Is(err, repository.ErrNotFound) {
+		auth.CheckPassword("", password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !auth.CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// CurrentUser returns the user of ctx
+func (a *App) CurrentUser(ctx context.Context) (*model.User, error) {
+	return a.Repository.GetUser(ctx, repository.Owner(ctx))
+}
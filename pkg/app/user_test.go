@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+func TestAssignOwnerless(t *testing.T) {
+	ctx := context.Background()
+	a := newTestApp(t)
+	a.OwnerlessUser = "Ada"
+	legacy := &model.Todo{Title: "legacy", Status: model.StatusOpen}
+	if err := a.Repository.Create(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	// the todos wait for the user to register
+	if n, err := a.AssignOwnerless(ctx); err != nil || n != 0 {
+		t.Errorf("AssignOwnerless before registering = %d, %v, want 0", n, err)
+	}
+	other, err := a.Register(ctx, "grace", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetTodo(repository.WithOwner(ctx, other.ID), legacy.ID); err == nil {
+		t.Error("another user got the todo without owner")
+	}
+
+	ada, err := a.Register(ctx, "ada", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo, err := a.GetTodo(repository.WithOwner(ctx, ada.ID), legacy.ID)
+	if err != nil {
+		t.Fatalf("get the assigned todo: %v", err)
+	}
+	if todo.OwnerID != ada.ID {
+		t.Errorf("todo has owner %d, want %d", todo.OwnerID, ada.ID)
+	}
+	if n, err := a.AssignOwnerless(ctx); err != nil || n != 0 {
+		t.Errorf("AssignOwnerless after registering = %d, %v, want 0", n, err)
+	}
+}
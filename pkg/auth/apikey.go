@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, which tells keys apart from session tokens
+const APIKeyPrefix = "todo_"
+
+// apiKeyBytes is the number of random bytes in a key
+const apiKeyBytes = 32
+
+// displayLength is the length of the start of a key that is kept to tell keys apart
+const displayLength = len(APIKeyPrefix) + 8
+
+// NewAPIKey returns a new random API key, the SHA-256 hash it is stored as and its start for display
+func NewAPIKey() (key, hash, prefix string, err error) {
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+	return key, HashAPIKey(key), key[:displayLength], nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of a key. The keys are random, so an unsalted fast hash
+// keeps them as safe as bcrypt keeps passwords and allows looking them up by hash.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether a credential is an API key rather than a session token
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
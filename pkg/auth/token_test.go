@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestToken(t *testing.T) {
+	signer, err := NewSigner(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := time.Date(2026, time.March, 10, 9, 30, 0, 0, time.UTC)
+	claims := Claims{UserID: 42, Tenant: "acme", IssuedAt: issued, ExpiresAt: issued.Add(time.Hour)}
+	token, err := signer.Issue(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := signer.Verify(token, issued.Add(59*time.Minute))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got != claims {
+		t.Errorf("got %+v, want %+v", got, claims)
+	}
+
+	other, err := NewSigner([]byte(strings.Repeat("x", MinSecretLength)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":0,"exp":9999999999}`))
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		token  string
+		now    time.Time
+	}{
+		{"expired", signer, token, issued.Add(time.Hour)},
+		{"other secret", other, token, issued},
+		{"changed claims", signer, parts[0] + "." + forged + "." + parts[2], issued},
+		{"no algorithm", signer, none + "." + parts[1] + ".", issued},
+		{"malformed", signer, "token", issued},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.signer.Verify(tt.token, tt.now); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("got %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	if _, err := NewSigner(secret[:MinSecretLength-1]); err == nil {
+		t.Error("NewSigner accepted a short secret")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("the password does not match its hash")
+	}
+	if CheckPassword(hash, "wrong horse") {
+		t.Error("a wrong password matches")
+	}
+	if CheckPassword("", "correct horse") {
+		t.Error("a password matches the empty hash")
+	}
+
+	for _, password := range []string{"short", strings.Repeat("x", MaxPasswordLength+1)} {
+		if _, err := HashPassword(password); err == nil {
+			t.Errorf("HashPassword accepted a password of %d bytes", len(password))
+		}
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	key, hash, prefix, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix) || len(prefix) >= len(key) {
+		t.Errorf("NewAPIKey returned key %q with prefix %q", key, prefix)
+	}
+	if HashAPIKey(key) != hash {
+		t.Error("the key does not match its hash")
+	}
+
+	other, _, _, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key || HashAPIKey(other) == hash {
+		t.Error("NewAPIKey returned the same key twice")
+	}
+	if IsAPIKey("eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9") {
+		t.Error("a session token is taken for an API key")
+	}
+}
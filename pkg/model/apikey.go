@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxAPIKeyNameLength is the longest API key name in bytes
+const maxAPIKeyNameLength = 100
+
+// Scope is what a credential may do. Each scope includes the ones before it.
+type Scope string
+
+const (
+	// ScopeRead allows reading todos, projects and tags
+	ScopeRead Scope = "read"
+	// ScopeWrite also allows changing them
+	ScopeWrite Scope = "write"
+	// ScopeAdmin also allows managing the API keys of the user
+	ScopeAdmin Scope = "admin"
+)
+
+var scopeLevels = map[Scope]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// Valid reports whether s is one of the known scopes
+func (s Scope) Valid() bool {
+	_, ok := scopeLevels[s]
+	return ok
+}
+
+// Allows reports whether s includes the scope required
+func (s Scope) Allows(required Scope) bool {
+	return s.Valid() && scopeLevels[s] >= scopeLevels[required]
+}
+
+// APIKey lets scripts act for a user without signing in. Only the hash of the key is stored, the key
+// itself is shown once when it is created.
+type APIKey struct {
+	ID int `json:"id" bson:"id"`
+	// OwnerID is the user the key acts for, set by the repository like Todo.OwnerID
+	OwnerID int    `json:"ownerId" bson:"ownerId"`
+	Name    string `json:"name" bson:"name"`
+	// Prefix is the start of the key, which tells keys apart without revealing them
+	Prefix string `json:"prefix" bson:"prefix"`
+	// Hash is the SHA-256 hash of the key. It is never encoded to JSON.
+	Hash       string     `json:"-" bson:"hash"`
+	Scope      Scope      `json:"scope" bson:"scope"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+// Clone returns a copy of the key that shares no memory with it
+func (k *APIKey) Clone() *APIKey {
+	c := *k
+	if k.LastUsedAt != nil {
+		lastUsedAt := *k.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	return &c
+}
+
+// Validate checks the fields a client is allowed to set
+func (k *APIKey) Validate() error {
+	if strings.TrimSpace(k.Name) == "" {
+		return fmt.Errorf("key name is required")
+	}
+	if len(k.Name) > maxAPIKeyNameLength {
+		return fmt.Errorf("key name is longer than %d bytes", maxAPIKeyNameLength)
+	}
+	if !k.Scope.Valid() {
+		return fmt.Errorf("invalid scope %q, the scope is read, write or admin", k.Scope)
+	}
+	if k.Hash == "" {
+		return fmt.Errorf("key hash is required")
+	}
+	return nil
+}
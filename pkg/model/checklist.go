@@ -0,0 +1,79 @@
+package model
+
+import "fmt"
+
+const (
+	// maxChecklistItems is the largest number of checklist items of a todo
+	maxChecklistItems = 100
+	// maxChecklistTextLength is the longest text of a checklist item in bytes
+	maxChecklistTextLength = 500
+)
+
+// ChecklistItem is a step of a todo that is checked off on its own
+type ChecklistItem struct {
+	// ID identifies the item within its todo
+	ID   int    `json:"id" bson:"id"`
+	Text string `json:"text" bson:"text"`
+	Done bool   `json:"done" bson:"done"`
+}
+
+// NumberChecklist gives the checklist items without an ID the next IDs of the todo. IDs are not reused,
+// not even the ID of the last item after it is removed.
+func (t *Todo) NumberChecklist() {
+	for _, item := range t.Checklist {
+		if item.ID > t.LastChecklistID {
+			t.LastChecklistID = item.ID
+		}
+	}
+	for i := range t.Checklist {
+		if t.Checklist[i].ID == 0 {
+			t.LastChecklistID++
+			t.Checklist[i].ID = t.LastChecklistID
+		}
+	}
+}
+
+// ChecklistIndex returns the position of the checklist item with the given ID, or -1
+func (t *Todo) ChecklistIndex(id int) int {
+	for i, item := range t.Checklist {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Progress returns the percentage of done checklist items, rounded down. It is false without a checklist.
+func (t *Todo) Progress() (int, bool) {
+	if len(t.Checklist) == 0 {
+		return 0, false
+	}
+	done := 0
+	for _, item := range t.Checklist {
+		if item.Done {
+			done++
+		}
+	}
+	return done * 100 / len(t.Checklist), true
+}
+
+// validateChecklist rejects empty or overlong items, duplicate IDs and too many items
+func validateChecklist(items []ChecklistItem) error {
+	if len(items) > maxChecklistItems {
+		return fmt.Errorf("a checklist has at most %d items", maxChecklistItems)
+	}
+	seen := map[int]bool{}
+	for _, item := range items {
+		if item.ID <= 0 || seen[item.ID] {
+			return fmt.Errorf("invalid checklist item id %d", item.ID)
+		}
+		seen[item.ID] = true
+		if item.Text == "" {
+			return fmt.Errorf("checklist item %d has no text", item.ID)
+		}
+		if len(item.Text) > maxChecklistTextLength {
+			return fmt.Errorf("checklist item %d is longer than %d bytes", item.ID, maxChecklistTextLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxCommentLength is the longest text of a comment in bytes
+const maxCommentLength = 2000
+
+// Comment is a remark on a todo. Comments are added and removed on their own, changes of the todo keep them.
+type Comment struct {
+	// ID identifies the comment within its todo
+	ID        int       `json:"id" bson:"id"`
+	AuthorID  int       `json:"authorId" bson:"authorId"`
+	Text      string    `json:"text" bson:"text"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// NextCommentID takes the ID of the next comment of the todo. IDs are not reused, not even the ID of the
+// last comment after it is removed.
+func (t *Todo) NextCommentID() int {
+	for _, comment := range t.Comments {
+		if comment.ID > t.LastCommentID {
+			t.LastCommentID = comment.ID
+		}
+	}
+	t.LastCommentID++
+	return t.LastCommentID
+}
+
+// CommentIndex returns the position of the comment with the given ID, or -1
+func (t *Todo) CommentIndex(id int) int {
+	for i, comment := range t.Comments {
+		if comment.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidateComment rejects empty and overlong comment texts
+func ValidateComment(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("comment text is required")
+	}
+	if len(text) > maxCommentLength {
+		return fmt.Errorf("comment text is longer than %d bytes", maxCommentLength)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Priority is how important a todo is. It is stored as its level and encoded by name in JSON.
+type Priority int
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = []string{"none", "low", "medium", "high", "urgent"}
+
+// Valid reports whether p is one of the known priorities
+func (p Priority) Valid() bool {
+	return p >= PriorityNone && p <= PriorityUrgent
+}
+
+func (p Priority) String() string {
+	if !p.Valid() {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority returns the priority of a name like "high"
+func ParsePriority(name string) (Priority, error) {
+	for p, priorityName := range priorityNames {
+		if name == priorityName {
+			return Priority(p), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid priority %q", name)
+}
+
+func (p Priority) MarshalText() ([]byte, error) {
+	if !p.Valid() {
+		return nil, fmt.Errorf("invalid priority %d", int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+func (p *Priority) UnmarshalText(text []byte) error {
+	priority, err := ParsePriority(string(text))
+	if err != nil {
+		return err
+	}
+	*p = priority
+	return nil
+}
+
+// The weights of SmartScore. The repositories compute the score in their query languages from them.
+const (
+	// SmartPriorityWeight is the score of each priority level above none
+	SmartPriorityWeight = 10
+	// SmartOverdueScore is the score of an overdue todo, which grows by a point for each full day
+	// past its deadline up to SmartMaxOverdueDays
+	SmartOverdueScore   = 30
+	SmartMaxOverdueDays = 10
+	// SmartDueScore is the score of a todo due within a day. It shrinks by SmartDueDecay for each full
+	// day to its deadline, down to zero.
+	SmartDueScore = 20
+	SmartDueDecay = 2
+)
+
+// SmartScore ranks what to do next at the moment now, the higher the sooner. An unfinished todo scores
+// 1 plus the weight of its priority plus how overdue or how close to its deadline it is. Finished todos
+// score 0 and come last.
+func (t *Todo) SmartScore(now time.Time) int {
+	if t.Status == StatusDone || t.Status == StatusCancelled {
+		return 0
+	}
+
+	score := 1 + int(t.Priority)*SmartPriorityWeight
+	deadline, ok := t.Deadline()
+	switch {
+	case !ok:
+	case deadline.Before(now):
+		days := int(now.Sub(deadline) / (24 * time.Hour))
+		score += SmartOverdueScore + min(days, SmartMaxOverdueDays)
+	default:
+		days := int(deadline.Sub(now) / (24 * time.Hour))
+		score += max(0, SmartDueScore-SmartDueDecay*days)
+	}
+	return score
+}
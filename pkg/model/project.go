@@ -0,0 +1,38 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxProjectNameLength is the longest project name in bytes
+const maxProjectNameLength = 200
+
+// Project is a list todos are organized in. Archiving a project archives its todos and deleting it deletes them.
+type Project struct {
+	ID int `json:"id" bson:"id"`
+	// OwnerID is the user the project belongs to, set by the repository like Todo.OwnerID
+	OwnerID     int    `json:"ownerId,omitempty" bson:"ownerId,omitempty"`
+	Name        string `json:"name" bson:"name"`
+	Description string `json:"description" bson:"description"`
+	Archived    bool   `json:"archived" bson:"archived"`
+	// Version is incremented by the repository on every change
+	Version int64 `json:"version" bson:"version"`
+}
+
+// Clone returns a copy of the project
+func (p *Project) Clone() *Project {
+	c := *p
+	return &c
+}
+
+// Validate checks the fields a client is allowed to set
+func (p *Project) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("project name is required")
+	}
+	if len(p.Name) > maxProjectNameLength {
+		return fmt.Errorf("project name is longer than %d bytes", maxProjectNameLength)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// Expr is a node of a filter expression. The API parses queries into expressions and every
+// repository translates them into its own query language.
+//
+// Expressions are And, Or, Not and Condition.
+type Expr interface {
+	expr()
+}
+
+// And matches todos matching all of its expressions, an empty And matches every todo
+type And []Expr
+
+// Or matches todos matching any of its expressions, an empty Or matches no todo
+type Or []Expr
+
+// Not matches todos not matching Expr
+type Not struct {
+	Expr Expr
+}
+
+// Condition compares a field of a todo with a value
+type Condition struct {
+	Field Field
+	Op    Op
+	// Value of the text fields, FieldStatus, FieldTag and the decimal ID of FieldProject and FieldParent
+	Value string
+	// Time of the date fields
+	Time time.Time
+}
+
+func (And) expr()       {}
+func (Or) expr()        {}
+func (Not) expr()       {}
+func (Condition) expr() {}
+
+// Field is the part of a todo a Condition looks at
+type Field string
+
+const (
+	// FieldText is the title or the description
+	FieldText        Field = "text"
+	FieldTitle       Field = "title"
+	FieldDescription Field = "description"
+	FieldStatus      Field = "status"
+	FieldDue         Field = "due"
+	FieldCompleted   Field = "completed"
+	// FieldTag matches todos carrying the tag Value with OpEqual
+	FieldTag Field = "tag"
+	// FieldProject matches the todos of the project with the ID Value with OpEqual
+	FieldProject Field = "project"
+	// FieldParent matches the subtasks of the todo with the ID Value with OpEqual
+	FieldParent Field = "parent"
+	// FieldArchived is set for the todos of archived projects. It takes no operator or value.
+	FieldArchived Field = "archived"
+	// FieldOverdue is set for unfinished todos past their deadline. It takes no operator or value.
+	FieldOverdue Field = "overdue"
+)
+
+// Op is the comparison of a Condition
+type Op string
+
+const (
+	// OpContains matches text fields containing Value, ignoring case
+	OpContains Op = "contains"
+	// OpEqual matches a status equal to Value, a tag, a project or a parent
+	OpEqual Op = "="
+	// The ordering operators compare date fields with Time. Missing dates never match.
+	OpLess           Op = "<"
+	OpLessOrEqual    Op = "<="
+	OpGreater        Op = ">"
+	OpGreaterOrEqual Op = ">="
+	// OpExists matches date fields that are set
+	OpExists Op = "exists"
+)
+
+// Expr returns the filter as a single expression
+func (f Filter) Expr() Expr {
+	and := And{}
+	if f.Text != "" {
+		and = append(and, Condition{Field: FieldText, Op: OpContains, Value: f.Text})
+	}
+	if len(f.Statuses) > 0 {
+		or := Or{}
+		for _, status := range f.Statuses {
+			or = append(or, Condition{Field: FieldStatus, Op: OpEqual, Value: string(status)})
+		}
+		and = append(and, or)
+	}
+	if len(f.Tags) > 0 {
+		tags := make([]Expr, len(f.Tags))
+		for i, tag := range f.Tags {
+			tags[i] = Condition{Field: FieldTag, Op: OpEqual, Value: tag}
+		}
+		if f.TagMatch == TagMatchAll {
+			and = append(and, And(tags))
+		} else {
+			and = append(and, Or(tags))
+		}
+	}
+	if f.DueBefore != nil {
+		and = append(and, Condition{Field: FieldDue, Op: OpLess, Time: *f.DueBefore})
+	}
+	if f.DueAfter != nil {
+		and = append(and, Condition{Field: FieldDue, Op: OpGreaterOrEqual, Time: *f.DueAfter})
+	}
+	if f.Overdue {
+		and = append(and, Condition{Field: FieldOverdue})
+	}
+	if f.ProjectID != 0 {
+		and = append(and, Condition{Field: FieldProject, Op: OpEqual, Value: strconv.Itoa(f.ProjectID)})
+	}
+	if f.ParentID != 0 {
+		and = append(and, Condition{Field: FieldParent, Op: OpEqual, Value: strconv.Itoa(f.ParentID)})
+	}
+	if f.Archived != nil {
+		if *f.Archived {
+			and = append(and, Condition{Field: FieldArchived})
+		} else {
+			and = append(and, Not{Expr: Condition{Field: FieldArchived}})
+		}
+	}
+	if f.Query != nil {
+		and = append(and, f.Query)
+	}
+
+	if len(and) == 1 {
+		return and[0]
+	}
+	return and
+}
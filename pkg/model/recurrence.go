@@ -0,0 +1,79 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/recurrence"
+)
+
+// Rule returns the parsed recurrence rule of the todo, nil for a todo that does not repeat
+func (t *Todo) Rule() (*recurrence.Rule, error) {
+	if t.Recurrence == "" {
+		return nil, nil
+	}
+	rule, err := recurrence.Parse(t.Recurrence)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recurrence %q: %w", t.Recurrence, err)
+	}
+	return rule, nil
+}
+
+// NormalizeRecurrence rewrites a valid recurrence rule in its canonical form, e.g. without the "RRULE:" prefix
+func (t *Todo) NormalizeRecurrence() {
+	if rule, err := t.Rule(); err == nil && rule != nil {
+		t.Recurrence = rule.String()
+	}
+}
+
+// validateRecurrence rejects invalid rules and recurring todos without a due date, which is the start of the series
+func (t *Todo) validateRecurrence() error {
+	rule, err := t.Rule()
+	if err != nil {
+		return err
+	}
+	if rule != nil && t.DueDate == nil {
+		return fmt.Errorf("a recurring todo needs a due date")
+	}
+	return nil
+}
+
+// Occurrences returns up to n due dates that follow the due date of a recurring todo. They are computed
+// on the calendar of the todo's time zone, so a todo due at 09:00 stays due at 09:00 across daylight saving time.
+func (t *Todo) Occurrences(n int) ([]time.Time, error) {
+	rule, err := t.Rule()
+	if err != nil || rule == nil || t.DueDate == nil {
+		return nil, err
+	}
+	loc, err := LoadLocation(t.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := rule.Next(t.DueDate.In(loc), n)
+	for i := range occurrences {
+		occurrences[i] = occurrences[i].UTC()
+	}
+	return occurrences, nil
+}
+
+// Advance moves a recurring todo on to its next occurrence: the due date follows the rule, a COUNT is
+// lowered by the occurrence left behind and the checklist is unchecked. It reports false when the
+// series has no further occurrence, leaving the todo unchanged.
+func (t *Todo) Advance() (bool, error) {
+	next, err := t.Occurrences(1)
+	if err != nil || len(next) == 0 {
+		return false, err
+	}
+
+	rule, _ := t.Rule()
+	if rule.Count > 0 {
+		rule.Count--
+		t.Recurrence = rule.String()
+	}
+	t.DueDate = &next[0]
+	for i := range t.Checklist {
+		t.Checklist[i].Done = false
+	}
+	return true, nil
+}
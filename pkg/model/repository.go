@@ -1,10 +1,113 @@
 package model
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Filter struct {
+	// Text is matched case-insensitively against title and description
+	Text string
+	// Statuses restricts the result to todos in any of the given statuses
+	Statuses []Status
+	// DueBefore and DueAfter restrict the result to todos due in [DueAfter, DueBefore)
+	DueBefore *time.Time
+	DueAfter  *time.Time
+	// Overdue restricts the result to unfinished todos past their deadline
+	Overdue bool
+	// Tags restricts the result to todos carrying any or, with TagMatchAll, all of the tags
+	Tags     []string
+	TagMatch TagMatch
+	// ProjectID restricts the result to the todos of a project
+	ProjectID int
+	// ParentID restricts the result to the subtasks of a todo
+	ParentID int
+	// Archived restricts the result to archived or, when false, to unarchived todos. Nil includes both.
+	Archived *bool
+	// Query restricts the result to todos matching an expression
+	Query Expr
+}
+
+// Sorting orders todos by its keys, in order of precedence. Todos equal in every key are
+// ordered by ascending ID, so that the order is always the same.
 type Sorting struct {
+	Keys []SortKey
+	// Now is the moment the smart score is computed at, zero for the current time. Keeping it
+	// keeps the order of a list the same from one page to the next.
+	Now time.Time
+}
+
+// Time returns the moment the smart score is computed at
+func (s Sorting) Time() time.Time {
+	if s.Now.IsZero() {
+		return time.Now()
+	}
+	return s.Now
+}
+
+// Has reports whether the todos are sorted by sortBy
+func (s Sorting) Has(sortBy SortBy) bool {
+	for _, key := range s.Keys {
+		if key.SortBy == sortBy {
+			return true
+		}
+	}
+	return false
+}
+
+// SortKey is a field to sort by and its direction
+type SortKey struct {
 	SortBy   SortBy
 	SortType SortType
 }
 
+// SortByKeys returns the sorting by the given keys
+func SortByKeys(keys ...SortKey) Sorting {
+	return Sorting{Keys: keys}
+}
+
+// String returns the sorting in the format of ParseSorting
+func (s Sorting) String() string {
+	keys := make([]string, len(s.Keys))
+	for i, key := range s.Keys {
+		keys[i] = key.SortBy.String() + ":" + key.SortType.String()
+	}
+	return strings.Join(keys, ",")
+}
+
+// ParseSorting parses a comma separated list of keys like "dueDate:asc,title:desc".
+// Keys without a direction get sortType.
+func ParseSorting(s string, sortType SortType) (Sorting, error) {
+	var sorting Sorting
+	if s == "" {
+		return sorting, nil
+	}
+
+	seen := map[SortBy]bool{}
+	for _, key := range strings.Split(s, ",") {
+		name, direction, hasDirection := strings.Cut(strings.TrimSpace(key), ":")
+		sortBy, err := ParseSortBy(name)
+		if err != nil {
+			return Sorting{}, err
+		}
+		if seen[sortBy] {
+			return Sorting{}, fmt.Errorf("duplicate sort key %q", name)
+		}
+		seen[sortBy] = true
+
+		keyType := sortType
+		if hasDirection {
+			if keyType, err = ParseSortType(direction); err != nil {
+				return Sorting{}, err
+			}
+		}
+		sorting.Keys = append(sorting.Keys, SortKey{SortBy: sortBy, SortType: keyType})
+	}
+	return sorting, nil
+}
+
 type SortBy int
 
 const (
@@ -12,8 +115,47 @@ const (
 	SortByTitle
 	SortByDescription
 	SortByDueDate
+	SortByStatus
+	SortByCompletedAt
+	SortByPriority
+	// SortBySmart orders by the rank of SmartScore: ascending puts the todo to do next first
+	SortBySmart
+	// SortByProject and SortByParent group the todos of a project or parent todo, those without one first
+	SortByProject
+	SortByParent
 )
 
+// sortByNames are the names of the SortBy values in the API
+var sortByNames = map[SortBy]string{
+	SortByID:          "id",
+	SortByTitle:       "title",
+	SortByDescription: "description",
+	SortByDueDate:     "dueDate",
+	SortByStatus:      "status",
+	SortByCompletedAt: "completedAt",
+	SortByPriority:    "priority",
+	SortBySmart:       "smart",
+	SortByProject:     "projectId",
+	SortByParent:      "parentId",
+}
+
+func (s SortBy) String() string {
+	if name, ok := sortByNames[s]; ok {
+		return name
+	}
+	return "SortBy(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParseSortBy returns the SortBy of a name like "dueDate"
+func ParseSortBy(name string) (SortBy, error) {
+	for sortBy, sortByName := range sortByNames {
+		if name == sortByName {
+			return sortBy, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid sort key %q", name)
+}
+
 type SortType int
 
 const (
@@ -21,7 +163,28 @@ const (
 	SortDescending
 )
 
+func (s SortType) String() string {
+	if s == SortDescending {
+		return "desc"
+	}
+	return "asc"
+}
+
+// ParseSortType returns the SortType of "asc" or "desc"
+func ParseSortType(name string) (SortType, error) {
+	switch name {
+	case "asc":
+		return SortAscending, nil
+	case "desc":
+		return SortDescending, nil
+	}
+	return 0, fmt.Errorf("invalid sort direction %q", name)
+}
+
 type Pagination struct {
 	Page  int
 	Limit int
+	// After continues the list behind this todo instead of skipping to Page (keyset pagination).
+	// Only its ID and the sorted fields are used, the todo itself may be gone by now.
+	After *Todo
 }
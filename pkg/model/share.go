@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Role is what a user may do with a todo or project. Each role includes the ones before it.
+type Role string
+
+const (
+	// RoleViewer allows reading
+	RoleViewer Role = "viewer"
+	// RoleCommenter also allows commenting on todos
+	RoleCommenter Role = "commenter"
+	// RoleEditor also allows changing, creating and deleting todos and changing projects
+	RoleEditor Role = "editor"
+	// RoleOwner also allows sharing and deleting projects. It belongs to the owner only and cannot be shared.
+	RoleOwner Role = "owner"
+)
+
+var roleLevels = map[Role]int{RoleViewer: 1, RoleCommenter: 2, RoleEditor: 3, RoleOwner: 4}
+
+// Valid reports whether r is one of the known roles
+func (r Role) Valid() bool {
+	_, ok := roleLevels[r]
+	return ok
+}
+
+// Includes reports whether r allows everything the role required allows
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && roleLevels[r] >= roleLevels[required]
+}
+
+// Max returns the role of r and other that allows more
+func (r Role) Max(other Role) Role {
+	if roleLevels[other] > roleLevels[r] {
+		return other
+	}
+	return r
+}
+
+// ResourceType is the kind of thing a share gives access to
+type ResourceType string
+
+const (
+	ResourceProject ResourceType = "project"
+	ResourceTodo    ResourceType = "todo"
+)
+
+// Valid reports whether t is one of the known resource types
+func (t ResourceType) Valid() bool {
+	return t == ResourceProject || t == ResourceTodo
+}
+
+// Share gives a user a role on a project or todo of another user. It starts as an invitation and grants
+// the role once the user accepts it. Sharing a project shares all of its todos.
+type Share struct {
+	ID           int          `json:"id" bson:"id"`
+	ResourceType ResourceType `json:"resourceType" bson:"resourceType"`
+	ResourceID   int          `json:"resourceId" bson:"resourceId"`
+	// UserID is the user the resource is shared with
+	UserID    int       `json:"userId" bson:"userId"`
+	Role      Role      `json:"role" bson:"role"`
+	Accepted  bool      `json:"accepted" bson:"accepted"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Clone returns a copy of the share
+func (s *Share) Clone() *Share {
+	c := *s
+	return &c
+}
+
+// Validate checks the fields of a share
+func (s *Share) Validate() error {
+	if !s.ResourceType.Valid() {
+		return fmt.Errorf("invalid resource type %q, the type is project or todo", s.ResourceType)
+	}
+	if s.ResourceID == 0 || s.UserID == 0 {
+		return fmt.Errorf("a share needs a resource and a user")
+	}
+	if !s.Role.Valid() || s.Role == RoleOwner {
+		return fmt.Errorf("invalid role %q, the role is viewer, commenter or editor", s.Role)
+	}
+	return nil
+}
+
+// ShareFilter selects shares. Zero fields match every share.
+type ShareFilter struct {
+	ResourceType ResourceType
+	ResourceID   int
+	UserID       int
+}
+
+// Matches reports whether the share is selected by f
+func (f ShareFilter) Matches(s *Share) bool {
+	return (f.ResourceType == "" || s.ResourceType == f.ResourceType) &&
+		(f.ResourceID == 0 || s.ResourceID == f.ResourceID) &&
+		(f.UserID == 0 || s.UserID == f.UserID)
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxTagLength is the longest tag in bytes
+const maxTagLength = 64
+
+// TagCount is a tag and the number of todos carrying it
+type TagCount struct {
+	Tag   string `json:"tag" bson:"_id"`
+	Count int    `json:"count" bson:"count"`
+}
+
+// TagMatch tells whether a todo needs any or all tags of a filter
+type TagMatch int
+
+const (
+	TagMatchAny TagMatch = iota
+	TagMatchAll
+)
+
+// NormalizeTag returns the canonical form of a tag, without surrounding space and in lower case
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// NormalizeTags normalizes every tag and returns them sorted and without duplicates, or nil without tags
+func NormalizeTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		normalized = append(normalized, NormalizeTag(tag))
+	}
+	sort.Strings(normalized)
+
+	unique := normalized[:1]
+	for _, tag := range normalized[1:] {
+		if tag != unique[len(unique)-1] {
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
+
+// ValidateTag rejects tags that are empty, too long or contain spaces, commas or control characters
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	}
+	if len(tag) > maxTagLength {
+		return fmt.Errorf("tag %q is longer than %d bytes", tag, maxTagLength)
+	}
+	if strings.IndexFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) || r == ',' }) >= 0 {
+		return fmt.Errorf("tag %q must not contain spaces or commas", tag)
+	}
+	if tag != NormalizeTag(tag) {
+		return fmt.Errorf("tag %q must be lower case", tag)
+	}
+	return nil
+}
@@ -1,8 +1,252 @@
 package model
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 type Todo struct {
-	ID          int    `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	DueDate     string `json:"dueDate"`
+	ID int `json:"id" bson:"id"`
+	// OwnerID is the user the todo belongs to. It is set by the repository, zero for todos stored before
+	// user accounts existed.
+	OwnerID     int    `json:"ownerId,omitempty" bson:"ownerId,omitempty"`
+	Title       string `json:"title" bson:"title"`
+	Description string `json:"description" bson:"description"`
+	// DueDate is stored in UTC. All-day due dates hold midnight of the day in TimeZone.
+	DueDate     *time.Time `json:"dueDate,omitempty" bson:"dueDate,omitempty"`
+	AllDay      bool       `json:"allDay,omitempty" bson:"allDay,omitempty"`
+	TimeZone    string     `json:"timeZone,omitempty" bson:"timeZone,omitempty"`
+	Status      Status     `json:"status" bson:"status"`
+	Priority    Priority   `json:"priority,omitempty" bson:"priority,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	// Tags are normalized, sorted and unique, see NormalizeTags
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	// ProjectID is the project the todo belongs to, zero for none
+	ProjectID int `json:"projectId,omitempty" bson:"projectId,omitempty"`
+	// Archived is set by the repository while the project of the todo is archived
+	Archived bool `json:"archived,omitempty" bson:"archived,omitempty"`
+	// ParentID is the todo this todo is a subtask of, zero for a top-level todo
+	ParentID int `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	// Checklist holds the steps of the todo in order
+	Checklist []ChecklistItem `json:"checklist,omitempty" bson:"checklist,omitempty"`
+	// LastChecklistID is the highest ID a checklist item of the todo ever had, see NumberChecklist
+	LastChecklistID int `json:"lastChecklistId,omitempty" bson:"lastChecklistId,omitempty"`
+	// Recurrence is the RFC 5545 RRULE the todo repeats by, empty for a todo that does not repeat
+	Recurrence string `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	// Comments are in the order they were written
+	Comments []Comment `json:"comments,omitempty" bson:"comments,omitempty"`
+	// LastCommentID is the highest ID a comment of the todo ever had, see NextCommentID
+	LastCommentID int `json:"lastCommentId,omitempty" bson:"lastCommentId,omitempty"`
+	// Version is incremented by the repository on every change
+	Version int64 `json:"version" bson:"version"`
+}
+
+// Status is the lifecycle state of a todo
+type Status string
+
+const (
+	StatusOpen       Status = "open"
+	StatusInProgress Status = "in-progress"
+	StatusDone       Status = "done"
+	StatusCancelled  Status = "cancelled"
+)
+
+// Valid reports whether s is one of the known statuses
+func (s Status) Valid() bool {
+	switch s {
+	case StatusOpen, StatusInProgress, StatusDone, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// SetStatus changes the status of the todo and keeps CompletedAt in sync with it.
+// An existing completion timestamp is kept when a done todo is marked done again.
+func (t *Todo) SetStatus(status Status, now time.Time) {
+	if status == StatusDone {
+		if t.Status != StatusDone || t.CompletedAt == nil {
+			t.CompletedAt = &now
+		}
+	} else {
+		t.CompletedAt = nil
+	}
+	t.Status = status
+}
+
+// Clone returns a copy of the todo that shares no memory with it
+func (t *Todo) Clone() *Todo {
+	c := *t
+	if t.DueDate != nil {
+		dueDate := *t.DueDate
+		c.DueDate = &dueDate
+	}
+	if t.CompletedAt != nil {
+		completedAt := *t.CompletedAt
+		c.CompletedAt = &completedAt
+	}
+	if t.Tags != nil {
+		c.Tags = append([]string(nil), t.Tags...)
+	}
+	if t.Checklist != nil {
+		c.Checklist = append([]ChecklistItem(nil), t.Checklist...)
+	}
+	if t.Comments != nil {
+		c.Comments = append([]Comment(nil), t.Comments...)
+	}
+	return &c
+}
+
+// Validate checks the fields a client is allowed to set
+func (t *Todo) Validate() error {
+	if !t.Status.Valid() {
+		return fmt.Errorf("invalid status %q", t.Status)
+	}
+	if !t.Priority.Valid() {
+		return fmt.Errorf("invalid priority %d", int(t.Priority))
+	}
+	if _, err := LoadLocation(t.TimeZone); err != nil {
+		return err
+	}
+	for i, tag := range t.Tags {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+		if i > 0 && t.Tags[i-1] >= tag {
+			return fmt.Errorf("tags must be sorted and unique")
+		}
+	}
+	if t.ParentID != 0 && t.ParentID == t.ID {
+		return fmt.Errorf("a todo cannot be its own subtask")
+	}
+	if err := t.validateRecurrence(); err != nil {
+		return err
+	}
+	return validateChecklist(t.Checklist)
+}
+
+// Deadline returns the moment the todo becomes overdue. All-day todos are due by the end of their day.
+func (t *Todo) Deadline() (time.Time, bool) {
+	if t.DueDate == nil {
+		return time.Time{}, false
+	}
+	if t.AllDay {
+		return t.DueDate.Add(24 * time.Hour), true
+	}
+	return *t.DueDate, true
+}
+
+// Overdue reports whether an unfinished todo has passed its deadline
+func (t *Todo) Overdue(now time.Time) bool {
+	if t.Status == StatusDone || t.Status == StatusCancelled {
+		return false
+	}
+	deadline, ok := t.Deadline()
+	return ok && deadline.Before(now)
+}
+
+// dateLayout is the encoding of all-day due dates
+const dateLayout = "2006-01-02"
+
+// localLayouts are accepted for due dates without a UTC offset, which are read in the todo's time zone
+var localLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+}
+
+// LoadLocation returns the location for an IANA time zone name. An empty name is UTC.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q", name)
+	}
+	return loc, nil
+}
+
+// ParseDueDate parses a due date in RFC 3339, a local date-time or a plain date.
+// Values without a UTC offset are read in the time zone tz. A plain date is an all-day due date.
+func ParseDueDate(value string, tz string) (time.Time, bool, error) {
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), false, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), false, nil
+		}
+	}
+	if t, err := time.ParseInLocation(dateLayout, value, loc); err == nil {
+		return t.UTC(), true, nil
+	}
+
+	return time.Time{}, false, fmt.Errorf("invalid due date %q", value)
+}
+
+// UnmarshalJSON accepts every due date format understood by ParseDueDate
+func (t *Todo) UnmarshalJSON(data []byte) error {
+	type alias Todo
+	aux := struct {
+		*alias
+		DueDate *string `json:"dueDate"`
+	}{alias: (*alias)(t)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	t.DueDate = nil
+	if aux.DueDate == nil || *aux.DueDate == "" {
+		t.AllDay = false
+		return nil
+	}
+
+	due, allDay, err := ParseDueDate(*aux.DueDate, t.TimeZone)
+	if err != nil {
+		return err
+	}
+	if t.AllDay && !allDay {
+		// keep only the day of an all-day due date given with a time
+		loc, _ := LoadLocation(t.TimeZone)
+		local := due.In(loc)
+		due = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc).UTC()
+	}
+	t.DueDate = &due
+	t.AllDay = t.AllDay || allDay
+
+	return nil
+}
+
+// MarshalJSON encodes all-day due dates as plain dates in the todo's time zone
+func (t Todo) MarshalJSON() ([]byte, error) {
+	type alias Todo
+	aux := struct {
+		alias
+		DueDate string `json:"dueDate,omitempty"`
+	}{alias: alias(t)}
+
+	if t.DueDate != nil {
+		aux.DueDate = FormatDueDate(*t.DueDate, t.AllDay, t.TimeZone)
+	}
+
+	return json.Marshal(aux)
+}
+
+// FormatDueDate formats a due date like in a todo: all-day due dates as plain dates in the time zone tz,
+// others in RFC 3339 in UTC
+func FormatDueDate(due time.Time, allDay bool, tz string) string {
+	if !allDay {
+		return due.UTC().Format(time.RFC3339Nano)
+	}
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		loc = time.UTC
+	}
+	return due.In(loc).Format(dateLayout)
 }
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The length limits of user names in bytes
+const (
+	minUserNameLength = 3
+	maxUserNameLength = 64
+)
+
+// User is an account that owns todos and projects
+type User struct {
+	ID   int    `json:"id" bson:"id"`
+	Name string `json:"name" bson:"name"`
+	// PasswordHash is the bcrypt hash of the password. It is never encoded to JSON, so that it does not reach clients.
+	PasswordHash string    `json:"-" bson:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Clone returns a copy of the user
+func (u *User) Clone() *User {
+	c := *u
+	return &c
+}
+
+// NormalizeUserName returns the canonical form of a user name, without surrounding space and in lower case
+func NormalizeUserName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Validate checks the fields a client is allowed to set. Names are lower case letters, digits, dots,
+// dashes and underscores.
+func (u *User) Validate() error {
+	if len(u.Name) < minUserNameLength || len(u.Name) > maxUserNameLength {
+		return fmt.Errorf("user name must be %d to %d bytes long", minUserNameLength, maxUserNameLength)
+	}
+	for _, r := range u.Name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return fmt.Errorf("user name %q may only contain lower case letters, digits, '.', '-' and '_'", u.Name)
+		}
+	}
+	if u.PasswordHash == "" {
+		return fmt.Errorf("password is required")
+	}
+	return nil
+}
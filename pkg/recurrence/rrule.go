@@ -0,0 +1,523 @@
+// Package recurrence computes the occurrences of RFC 5545 recurrence rules (RRULE).
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a rule, the period its occurrences repeat in
+type Frequency int
+
+const (
+	Daily Frequency = iota + 1
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencyNames = map[Frequency]string{
+	Daily:   "DAILY",
+	Weekly:  "WEEKLY",
+	Monthly: "MONTHLY",
+	Yearly:  "YEARLY",
+}
+
+func (f Frequency) String() string {
+	return frequencyNames[f]
+}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "MO",
+	time.Tuesday:   "TU",
+	time.Wednesday: "WE",
+	time.Thursday:  "TH",
+	time.Friday:    "FR",
+	time.Saturday:  "SA",
+	time.Sunday:    "SU",
+}
+
+// WeekdayNum is a day of BYDAY. N limits it to the Nth such day of the month or year,
+// counted from the end when negative. Zero means every such day.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return weekdayNames[w.Day]
+	}
+	return strconv.Itoa(w.N) + weekdayNames[w.Day]
+}
+
+// Rule is a parsed recurrence rule. The rule parts on the time of day (BYHOUR, BYMINUTE, BYSECOND),
+// BYYEARDAY and BYWEEKNO are not supported; occurrences keep the time of day of the start.
+type Rule struct {
+	Freq Frequency
+	// Interval is the number of periods between occurrences, at least 1
+	Interval int
+	// Count is the number of occurrences including the start, zero for no limit
+	Count int
+	// Until is the last moment an occurrence may fall on, zero for no limit. When UntilDate is set,
+	// Until holds a date in UTC that is compared with the local date of the occurrences.
+	Until     time.Time
+	UntilDate bool
+	// ByMonth, ByMonthDay, ByDay and BySetPos are sorted and unique
+	ByMonth    []time.Month
+	ByMonthDay []int
+	ByDay      []WeekdayNum
+	BySetPos   []int
+	WeekStart  time.Weekday
+}
+
+const (
+	// maxNumber bounds INTERVAL and COUNT
+	maxNumber = 10000
+	// horizonYears bounds how far after the start occurrences are searched, so that rules matching
+	// rarely or never end
+	horizonYears = 100
+
+	untilDateLayout = "20060102"
+	untilTimeLayout = "20060102T150405Z"
+)
+
+// Parse parses a recurrence rule such as "FREQ=MONTHLY;BYDAY=-1FR", with or without the "RRULE:" prefix
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	if s == "" {
+		return nil, errors.New("empty rule")
+	}
+
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given twice", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq, err = parseFrequency(value)
+		case "INTERVAL":
+			r.Interval, err = parseNumber(name, value, 1, maxNumber)
+		case "COUNT":
+			r.Count, err = parseNumber(name, value, 1, maxNumber)
+		case "UNTIL":
+			r.Until, r.UntilDate, err = parseUntil(value)
+		case "BYMONTH":
+			var months []int
+			months, err = parseNumbers(name, value, 1, 12, false)
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseNumbers(name, value, 1, 31, true)
+		case "BYDAY":
+			r.ByDay, err = parseWeekdays(value)
+		case "BYSETPOS":
+			r.BySetPos, err = parseNumbers(name, value, 1, 366, true)
+		case "WKST":
+			r.WeekStart, err = parseWeekday(value)
+		case "BYSECOND", "BYMINUTE", "BYHOUR", "BYYEARDAY", "BYWEEKNO":
+			err = fmt.Errorf("%s is not supported", name)
+		default:
+			err = fmt.Errorf("unknown rule part %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// check rejects combinations of rule parts RFC 5545 does not allow
+func (r *Rule) check() error {
+	if r.Freq == 0 {
+		return errors.New("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return errors.New("COUNT and UNTIL cannot be combined")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return errors.New("BYMONTHDAY cannot be combined with FREQ=WEEKLY")
+	}
+	// ordinals of BYDAY count within the month, or within the year for a yearly rule without BYMONTH and BYMONTHDAY
+	inMonth := r.Freq == Monthly || len(r.ByMonth) > 0 || len(r.ByMonthDay) > 0
+	for _, w := range r.ByDay {
+		switch {
+		case w.N == 0:
+		case r.Freq == Daily || r.Freq == Weekly:
+			return fmt.Errorf("BYDAY=%s needs FREQ=MONTHLY or FREQ=YEARLY", w)
+		case inMonth && (w.N > 5 || w.N < -5):
+			return fmt.Errorf("BYDAY=%s is not within a month", w)
+		}
+	}
+	if len(r.BySetPos) > 0 && len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		return errors.New("BYSETPOS needs BYMONTH, BYMONTHDAY or BYDAY")
+	}
+	return nil
+}
+
+func parseFrequency(value string) (Frequency, error) {
+	for f, name := range frequencyNames {
+		if name == value {
+			return f, nil
+		}
+	}
+	switch value {
+	case "SECONDLY", "MINUTELY", "HOURLY":
+		return 0, fmt.Errorf("FREQ=%s is not supported", value)
+	}
+	return 0, fmt.Errorf("invalid FREQ %q", value)
+}
+
+func parseNumber(name, value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("invalid %s %q, it is between %d and %d", name, value, min, max)
+	}
+	return n, nil
+}
+
+// parseNumbers parses a comma separated list of numbers between min and max, or between -max and -min
+// when negative is set. The numbers are returned sorted and unique.
+func parseNumbers(name, value string, min, max int, negative bool) ([]int, error) {
+	seen := map[int]bool{}
+	var numbers []int
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		abs := n
+		if negative && n < 0 {
+			abs = -n
+		}
+		if err != nil || abs < min || abs > max {
+			return nil, fmt.Errorf("invalid %s %q", name, s)
+		}
+		if !seen[n] {
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	for day, name := range weekdayNames {
+		if name == value {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", value)
+}
+
+// parseWeekdays parses the days of BYDAY such as MO,-1FR, sorted by ordinal and weekday
+func parseWeekdays(value string) ([]WeekdayNum, error) {
+	seen := map[WeekdayNum]bool{}
+	var days []WeekdayNum
+	for _, s := range strings.Split(value, ",") {
+		if len(s) < 2 {
+			return nil, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		day, err := parseWeekday(s[len(s)-2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		w := WeekdayNum{Day: day}
+		if ordinal := s[:len(s)-2]; ordinal != "" {
+			n, err := strconv.Atoi(ordinal)
+			if err != nil || n == 0 || n > 53 || n < -53 {
+				return nil, fmt.Errorf("invalid BYDAY %q", s)
+			}
+			w.N = n
+		}
+		if !seen[w] {
+			seen[w] = true
+			days = append(days, w)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].N != days[j].N {
+			return days[i].N < days[j].N
+		}
+		return (days[i].Day+6)%7 < (days[j].Day+6)%7
+	})
+	return days, nil
+}
+
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(untilDateLayout, value); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse(untilTimeLayout, value); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid UNTIL %q, it is a date such as 20261231 or a UTC time such as 20261231T235959Z", value)
+}
+
+// String returns the rule in its canonical form, without the "RRULE:" prefix
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq.String()}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]int, len(r.ByMonth))
+		for i, m := range r.ByMonth {
+			months[i] = int(m)
+		}
+		parts = append(parts, "BYMONTH="+joinNumbers(months))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinNumbers(r.ByMonthDay))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, w := range r.ByDay {
+			days[i] = w.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.BySetPos) > 0 {
+		parts = append(parts, "BYSETPOS="+joinNumbers(r.BySetPos))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.UntilDate {
+			parts = append(parts, "UNTIL="+r.Until.Format(untilDateLayout))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilTimeLayout))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinNumbers(numbers []int) string {
+	s := make([]string, len(numbers))
+	for i, n := range numbers {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// Next returns up to n occurrences that follow start, the first occurrence of the series.
+// Occurrences are computed on the calendar of the location of start and keep its time of day.
+// Like in RFC 5545, start counts towards Count even when it does not match the rule.
+func (r *Rule) Next(start time.Time, n int) []time.Time {
+	var next []time.Time
+	if n <= 0 {
+		return next
+	}
+
+	year, month, day := start.Date()
+	first := date(year, month, day)
+	horizon := first.AddDate(horizonYears, 0, 0)
+	occurrences := 1
+	for period := 0; ; period++ {
+		days, begin := r.expand(first, period*r.Interval)
+		if begin.After(horizon) {
+			return next
+		}
+		for _, d := range days {
+			t := r.at(d, start)
+			if !t.After(start) {
+				continue
+			}
+			if r.Count > 0 && occurrences >= r.Count || r.after(t, d) {
+				return next
+			}
+			occurrences++
+			next = append(next, t)
+			if len(next) == n {
+				return next
+			}
+		}
+	}
+}
+
+// date returns a day of the calendar as midnight UTC, which days are counted in
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// at returns the moment of day d at the time of day of start, in its location
+func (r *Rule) at(d time.Time, start time.Time) time.Time {
+	hour, min, sec := start.Clock()
+	return time.Date(d.Year(), d.Month(), d.Day(), hour, min, sec, start.Nanosecond(), start.Location())
+}
+
+// after reports whether the occurrence t on day d is after Until
+func (r *Rule) after(t, d time.Time) bool {
+	if r.Until.IsZero() {
+		return false
+	}
+	if r.UntilDate {
+		return d.After(r.Until)
+	}
+	return t.After(r.Until)
+}
+
+// expand returns the days of the period that is step periods after the period of first, in order,
+// and the first day of that period
+func (r *Rule) expand(first time.Time, step int) ([]time.Time, time.Time) {
+	var days []time.Time
+	var begin time.Time
+	switch r.Freq {
+	case Daily:
+		begin = first.AddDate(0, 0, step)
+		if r.inMonth(begin) && r.onMonthDay(begin) && r.onWeekday(begin, 0, 0) {
+			days = append(days, begin)
+		}
+	case Weekly:
+		offset := (int(first.Weekday()) - int(r.WeekStart) + 7) % 7
+		begin = first.AddDate(0, 0, 7*step-offset)
+		for i := 0; i < 7; i++ {
+			d := begin.AddDate(0, 0, i)
+			if !r.inMonth(d) {
+				continue
+			}
+			if len(r.ByDay) == 0 && d.Weekday() == first.Weekday() || len(r.ByDay) > 0 && r.onWeekday(d, 0, 0) {
+				days = append(days, d)
+			}
+		}
+	case Monthly:
+		begin = date(first.Year(), first.Month()+time.Month(step), 1)
+		if r.inMonth(begin) {
+			days = r.daysOfMonth(begin, first.Day())
+		}
+	case Yearly:
+		begin = date(first.Year()+step, 1, 1)
+		days = r.daysOfYear(begin, first.Month(), first.Day())
+	}
+	return r.setPos(days), begin
+}
+
+// daysOfMonth returns the days of the month starting on begin that match BYMONTHDAY and BYDAY, with
+// the ordinals of BYDAY counted within the month. Without either, the day of the month of the start is used.
+func (r *Rule) daysOfMonth(begin time.Time, startDay int) []time.Time {
+	last := begin.AddDate(0, 1, -1).Day()
+	var days []time.Time
+	for i := 0; i < last; i++ {
+		d := begin.AddDate(0, 0, i)
+		if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+			if d.Day() == startDay {
+				days = append(days, d)
+			}
+			continue
+		}
+		if r.onMonthDay(d) && (len(r.ByDay) == 0 || r.onWeekday(d, i/7+1, (last-1-i)/7+1)) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+// daysOfYear returns the days of the year starting on begin that match the rule. BYDAY alone counts its
+// ordinals within the year, otherwise the months of BYMONTH, all months for BYMONTHDAY or the month of
+// the start are expanded like in a monthly rule.
+func (r *Rule) daysOfYear(begin time.Time, startMonth time.Month, startDay int) []time.Time {
+	var days []time.Time
+	if len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) > 0 {
+		last := begin.AddDate(1, 0, -1).YearDay()
+		for i := 0; i < last; i++ {
+			d := begin.AddDate(0, 0, i)
+			if r.onWeekday(d, i/7+1, (last-1-i)/7+1) {
+				days = append(days, d)
+			}
+		}
+		return days
+	}
+
+	months := r.ByMonth
+	if len(months) == 0 {
+		months = []time.Month{startMonth}
+		if len(r.ByMonthDay) > 0 {
+			months = nil
+			for m := time.January; m <= time.December; m++ {
+				months = append(months, m)
+			}
+		}
+	}
+	for _, m := range months {
+		days = append(days, r.daysOfMonth(date(begin.Year(), m, 1), startDay)...)
+	}
+	return days
+}
+
+// inMonth reports whether d is in one of the months of BYMONTH
+func (r *Rule) inMonth(d time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if d.Month() == m {
+			return true
+		}
+	}
+	return false
+}
+
+// onMonthDay reports whether d is one of the days of BYMONTHDAY, negative days counting from the end of the month
+func (r *Rule) onMonthDay(d time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := date(d.Year(), d.Month()+1, 0).Day()
+	for _, day := range r.ByMonthDay {
+		if day == d.Day() || day == d.Day()-last-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// onWeekday reports whether d is one of the days of BYDAY, being the nth such day of its month or year
+// and the nthFromEnd such day counted from the end
+func (r *Rule) onWeekday(d time.Time, nth, nthFromEnd int) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, w := range r.ByDay {
+		if w.Day == d.Weekday() && (w.N == 0 || w.N == nth || w.N == -nthFromEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// setPos keeps the days of a period at the positions of BYSETPOS, negative positions counting from the end
+func (r *Rule) setPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return days
+	}
+	var kept []time.Time
+	for i, d := range days {
+		for _, pos := range r.BySetPos {
+			if pos == i+1 || pos == i-len(days) {
+				kept = append(kept, d)
+				break
+			}
+		}
+	}
+	return kept
+}
@@ -0,0 +1,141 @@
+package recurrence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone data is not available")
+	}
+
+	// most examples are from RFC 5545, section 3.8.5.3, all at 09:00 in New York
+	tests := []struct {
+		rule  string
+		start string
+		n     int
+		want  []string
+	}{
+		{"FREQ=DAILY", "2026-11-01", 3, []string{"2026-11-02", "2026-11-03", "2026-11-04"}},
+		{"FREQ=DAILY;INTERVAL=2;COUNT=3", "2026-11-01", 5, []string{"2026-11-03", "2026-11-05"}},
+		{"FREQ=DAILY;UNTIL=20261103", "2026-11-01", 5, []string{"2026-11-02", "2026-11-03"}},
+		{"FREQ=DAILY;UNTIL=20261103T133000Z", "2026-11-01", 5, []string{"2026-11-02"}},
+		{"FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", "2026-10-30", 3, []string{"2026-11-02", "2026-11-03", "2026-11-04"}},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO", "1997-08-05", 5, []string{"1997-08-10", "1997-08-19", "1997-08-24"}},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU", "1997-08-05", 5, []string{"1997-08-17", "1997-08-19", "1997-08-31"}},
+		{"FREQ=MONTHLY;COUNT=10;BYDAY=1FR", "1997-09-05", 3, []string{"1997-10-03", "1997-11-07", "1997-12-05"}},
+		{"FREQ=MONTHLY;INTERVAL=2;BYDAY=1SU,-1SU", "1997-09-07", 4, []string{"1997-09-28", "1997-11-02", "1997-11-30", "1998-01-04"}},
+		{"FREQ=MONTHLY;COUNT=6;BYDAY=-2MO", "1997-09-22", 3, []string{"1997-10-20", "1997-11-17", "1997-12-22"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-3", "1997-09-28", 3, []string{"1997-10-29", "1997-11-28", "1997-12-29"}},
+		{"FREQ=MONTHLY;INTERVAL=18;BYMONTHDAY=10,11,12,13,14,15", "1997-09-10", 6, []string{"1997-09-11", "1997-09-12", "1997-09-13", "1997-09-14", "1997-09-15", "1999-03-10"}},
+		{"FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", "1997-09-02", 3, []string{"1998-02-13", "1998-03-13", "1998-11-13"}},
+		{"FREQ=MONTHLY;BYDAY=SA;BYMONTHDAY=7,8,9,10,11,12,13", "1997-09-13", 3, []string{"1997-10-11", "1997-11-08", "1997-12-13"}},
+		{"FREQ=MONTHLY;COUNT=3;BYDAY=TU,WE,TH;BYSETPOS=3", "1997-09-04", 5, []string{"1997-10-07", "1997-11-06"}},
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "1997-09-30", 3, []string{"1997-10-31", "1997-11-28", "1997-12-31"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "2026-10-30", 3, []string{"2026-11-27", "2026-12-25", "2027-01-29"}},
+		{"FREQ=MONTHLY", "2026-01-31", 3, []string{"2026-03-31", "2026-05-31", "2026-07-31"}},
+		{"FREQ=YEARLY;BYMONTH=6,7", "1997-06-10", 3, []string{"1997-07-10", "1998-06-10", "1998-07-10"}},
+		{"FREQ=YEARLY;BYMONTH=3;BYDAY=TH", "1997-03-13", 3, []string{"1997-03-20", "1997-03-27", "1998-03-05"}},
+		{"FREQ=YEARLY;BYDAY=20MO", "1997-05-19", 2, []string{"1998-05-18", "1999-05-17"}},
+		{"FREQ=YEARLY;INTERVAL=4;BYMONTH=11;BYDAY=TU;BYMONTHDAY=2,3,4,5,6,7,8", "1996-11-05", 2, []string{"2000-11-07", "2004-11-02"}},
+		{"FREQ=YEARLY", "2024-02-29", 2, []string{"2028-02-29", "2032-02-29"}},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", "2026-01-01", 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			start := day(t, tt.start, newYork)
+
+			var got []string
+			for _, occurrence := range rule.Next(start, tt.n) {
+				if occurrence.Location() != newYork || occurrence.Hour() != 9 {
+					t.Errorf("occurrence %v is not at 09:00 in New York", occurrence)
+				}
+				got = append(got, occurrence.Format("2006-01-02"))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func day(t *testing.T, value string, loc *time.Location) time.Time {
+	t.Helper()
+	d, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Date(d.Year(), d.Month(), d.Day(), 9, 0, 0, 0, loc)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:freq=weekly;interval=1", "FREQ=WEEKLY"},
+		{"RRULE:FREQ=WEEKLY;BYDAY=FR,MO,MO;WKST=SU", "FREQ=WEEKLY;BYDAY=MO,FR;WKST=SU"},
+		{"FREQ=MONTHLY;COUNT=3;BYDAY=-1FR", "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3"},
+		{"FREQ=YEARLY;UNTIL=20261231;BYMONTH=7,6;BYMONTHDAY=-1,1", "FREQ=YEARLY;BYMONTH=6,7;BYMONTHDAY=-1,1;UNTIL=20261231"},
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;UNTIL=20261231T235959Z", "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;UNTIL=20261231T235959Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := rule.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"", "empty rule"},
+		{"INTERVAL=2", "FREQ is required"},
+		{"FREQ=HOURLY", "FREQ=HOURLY is not supported"},
+		{"FREQ=SOMETIMES", `invalid FREQ "SOMETIMES"`},
+		{"FREQ=DAILY;FREQ=WEEKLY", "FREQ is given twice"},
+		{"FREQ=DAILY;COUNT", `invalid rule part "COUNT"`},
+		{"FREQ=DAILY;INTERVAL=0", `invalid INTERVAL "0"`},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20261231", "COUNT and UNTIL cannot be combined"},
+		{"FREQ=DAILY;UNTIL=2026-12-31", `invalid UNTIL "2026-12-31"`},
+		{"FREQ=DAILY;BYHOUR=9", "BYHOUR is not supported"},
+		{"FREQ=DAILY;COLOR=RED", "unknown rule part COLOR"},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", "BYMONTHDAY cannot be combined with FREQ=WEEKLY"},
+		{"FREQ=WEEKLY;BYDAY=1MO", "BYDAY=1MO needs FREQ=MONTHLY or FREQ=YEARLY"},
+		{"FREQ=MONTHLY;BYDAY=6MO", "BYDAY=6MO is not within a month"},
+		{"FREQ=MONTHLY;BYDAY=XX", `invalid BYDAY "XX"`},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", `invalid BYMONTHDAY "0"`},
+		{"FREQ=YEARLY;BYMONTH=13", `invalid BYMONTH "13"`},
+		{"FREQ=MONTHLY;BYSETPOS=1", "BYSETPOS needs BYMONTH, BYMONTHDAY or BYDAY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := Parse(tt.rule)
+			if err == nil {
+				t.Fatal("Parse succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
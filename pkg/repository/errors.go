@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// Errors returned by every Repository implementation. Backend specific errors are wrapped,
+// so callers check them with errors.Is.
+var (
+	// ErrNotFound is returned when the requested todo does not exist
+	ErrNotFound = errors.New("todo not found")
+	// ErrProjectNotFound is returned when the requested project does not exist. It matches ErrNotFound.
+	ErrProjectNotFound error = notFoundError("project")
+	// ErrChecklistItemNotFound is returned when a todo has no checklist item with the requested id. It matches ErrNotFound.
+	ErrChecklistItemNotFound error = notFoundError("checklist item")
+	// ErrUserNotFound is returned when the requested user does not exist. It matches ErrNotFound.
+	ErrUserNotFound error = notFoundError("user")
+	// ErrAPIKeyNotFound is returned when the requested API key does not exist. It matches ErrNotFound.
+	ErrAPIKeyNotFound error = notFoundError("API key")
+	// ErrShareNotFound is returned when the requested share does not exist. It matches ErrNotFound.
+	ErrShareNotFound error = notFoundError("share")
+	// ErrCommentNotFound is returned when a todo has no comment with the requested id. It matches ErrNotFound.
+	ErrCommentNotFound error = notFoundError("comment")
+	// ErrTenantNotFound is returned when the requested tenant does not exist. It matches ErrNotFound.
+	ErrTenantNotFound error = notFoundError("tenant")
+	// ErrConflict is returned when a write collides with existing data
+	ErrConflict = errors.New("conflict")
+	// ErrValidation is returned when a todo is rejected because of its content
+	ErrValidation = errors.New("validation failed")
+	// ErrPreconditionFailed is returned when a write expects a version of the todo that is not the stored one
+	ErrPreconditionFailed = errors.New("version mismatch")
+	// ErrUnavailable is returned when the storage cannot be reached or written
+	ErrUnavailable = errors.New("repository unavailable")
+	// ErrForbidden is returned when a user may see a todo or project, but their role does not allow the request.
+	// The repository itself does not return it, the authorization of the app does.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// notFoundError is ErrNotFound for things other than todos
+type notFoundError string
+
+func (e notFoundError) Error() string {
+	return string(e) + " not found"
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// wrap annotates err with one of the sentinel errors
+func wrap(sentinel error, err error) error {
+	return fmt.Errorf("%w: %w", sentinel, err)
+}
+
+// validate normalizes the tags and recurrence of a todo, numbers its new checklist items and rejects todos
+// that must not be stored
+func validate(todo *model.Todo) error {
+	todo.Tags = model.NormalizeTags(todo.Tags)
+	todo.NormalizeRecurrence()
+	todo.NumberChecklist()
+	if err := todo.Validate(); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	return nil
+}
+
+// validateProject rejects projects that must not be stored
+func validateProject(project *model.Project) error {
+	if err := project.Validate(); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	return nil
+}
+
+// validateUser normalizes the name of a user and rejects users that must not be stored
+func validateUser(user *model.User) error {
+	user.Name = model.NormalizeUserName(user.Name)
+	if err := user.Validate(); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	return nil
+}
+
+// validateAPIKey rejects API keys that must not be stored
+func validateAPIKey(key *model.APIKey) error {
+	if err := key.Validate(); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	return nil
+}
+
+// validateShare rejects shares that must not be stored
+func validateShare(share *model.Share) error {
+	if err := share.Validate(); err != nil {
+		return wrap(ErrValidation, err)
+	}
+	return nil
+}
+
+// placeTodo checks that a todo may be in its project and archives it with the project. project is the
+// stored project of the todo, nil if it does not exist. moved tells whether the todo is new in the project.
+func placeTodo(todo *model.Todo, project *model.Project, moved bool) error {
+	if todo.ProjectID == 0 {
+		todo.Archived = false
+		return nil
+	}
+	if project == nil {
+		return wrap(ErrValidation, fmt.Errorf("project %d does not exist", todo.ProjectID))
+	}
+	if moved && project.Archived {
+		return wrap(ErrConflict, fmt.Errorf("project %d is archived", todo.ProjectID))
+	}
+	todo.Archived = project.Archived
+	return nil
+}
+
+// conditionID returns the ID of a FieldProject or FieldParent condition
+func conditionID(c model.Condition) (int, error) {
+	id, err := strconv.Atoi(c.Value)
+	if err != nil {
+		return 0, wrap(ErrValidation, fmt.Errorf("invalid %s id %q", c.Field, c.Value))
+	}
+	return id, nil
+}
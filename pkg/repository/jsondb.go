@@ -1,90 +1,189 @@
 package repository
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strings"
-	"sync"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
 )
 
 type JsonRepository struct {
-	mtx   sync.Mutex
-	todos []*model.Todo
-	db    *os.File
+	// mtx holds a value while the repository is locked, so that waiting for it can be cancelled
+	mtx      chan struct{}
+	todos    []*model.Todo
+	projects []*model.Project
+	users    []*model.User
+	apiKeys  []*model.APIKey
+	shares   []*model.Share
+	// tags indexes the ids of the todos carrying each tag
+	tags map[string]map[int]struct{}
+	// path of the db file holding the last snapshot
+	path string
+	// wal is the write-ahead log with the changes since the last snapshot
+	wal        *os.File
+	walRecords int
 }
 
 var _ Repository = (*JsonRepository)(nil)
 
+// NewJSONRepository loads the todos and projects of the db file and replays its write-ahead log.
+// The repository takes ownership of db and closes it.
 func NewJSONRepository(db *os.File) (Repository, error) {
-	var todos []*model.Todo
-	dec := json.NewDecoder(db)
-	if err := dec.Decode(&todos); err != nil && err != io.EOF {
+	defer db.Close()
+
+	snap, err := readSnapshot(db)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &JsonRepository{
+		mtx:      make(chan struct{}, 1),
+		todos:    snap.Todos,
+		projects: snap.Projects,
+		shares:   snap.Shares,
+		tags:     map[string]map[int]struct{}{},
+		path:     db.Name(),
+	}
+	for _, todo := range r.todos {
+		r.indexTags(todo)
+	}
+	for _, user := range snap.Users {
+		r.users = append(r.users, user.user())
+	}
+	for _, key := range snap.APIKeys {
+		r.apiKeys = append(r.apiKeys, key.apiKey())
+	}
+
+	removeTempFiles(r.path)
+
+	wal, records, err := openLog(walPath(r.path), r.apply)
+	if err != nil {
 		return nil, err
 	}
+	r.wal = wal
+	r.walRecords = records
 
-	return &JsonRepository{db: db,
-		todos: todos,
-	}, nil
+	// todos written before statuses and versions existed are open and at their first version
+	for _, todo := range r.todos {
+		if todo.Status == "" {
+			todo.Status = model.StatusOpen
+		}
+		if todo.Version == 0 {
+			todo.Version = 1
+		}
+	}
+
+	if records > 0 {
+		logrus.WithField("records", records).Info("Replayed write-ahead log")
+		if err := r.compact(); err != nil {
+			wal.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
 }
 
-// Create a new todo
-func (r *JsonRepository) Create(todo *model.Todo) error {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
+// lock waits for the repository lock unless ctx is done first
+func (r *JsonRepository) lock(ctx context.Context) error {
+	select {
+	case r.mtx <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	var uniqueId int = int(uuid.New().ID())
-	todo.ID = uniqueId
+	// both cases may have been ready
+	if err := ctx.Err(); err != nil {
+		r.unlock()
+		return err
+	}
+	return nil
+}
 
-	r.todos = append(r.todos, todo)
+func (r *JsonRepository) unlock() {
+	<-r.mtx
+}
 
-	if err := r.updateDb(); err != nil {
+// Create a new todo
+func (r *JsonRepository) Create(ctx context.Context, todo *model.Todo) error {
+	if err := validate(todo); err != nil {
 		return err
 	}
 
-	return nil
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	assignOwner(ctx, &todo.OwnerID)
+	if err := placeTodo(todo, r.project(ctx, todo.ProjectID), true); err != nil {
+		return err
+	}
+
+	todo.ID = unusedID(func(id int) bool { return r.position(id) >= 0 })
+	todo.Version = 1
+
+	return r.commit(walRecord{Op: opCreate, Todo: todo.Clone()})
 }
 
 // Get a todo by id
-func (r *JsonRepository) Get(id int) (*model.Todo, error) {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
-
-	for _, todo := range r.todos {
-		if todo.ID == id {
-			return todo, nil
-		}
+func (r *JsonRepository) Get(ctx context.Context, id int) (*model.Todo, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
 	}
+	defer r.unlock()
 
-	return nil, errors.New("todo not found")
+	if i := r.index(ctx, id); i >= 0 {
+		return r.todos[i].Clone(), nil
+	}
+	return nil, ErrNotFound
 }
 
 // Get all todos
-func (r *JsonRepository) GetAll(filter string, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error) {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
+func (r *JsonRepository) GetAll(ctx context.Context, filter model.Filter, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error) {
+	match, err := compilePredicate(filter.Expr())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
 
+	// the todos are filtered and sorted as the stored pointers, and only the page is cloned
 	var todos []*model.Todo
 
 	// step 1: filter todos
+	now := time.Now()
 	for _, todo := range r.todos {
-		if filter == "" || strings.Contains(strings.ToLower(todo.Title), strings.ToLower(filter)) || strings.Contains(strings.ToLower(todo.Description), strings.ToLower(filter)) {
+		if owns(ctx, todo.OwnerID) && match(todo, now) {
 			todos = append(todos, todo)
 		}
 	}
 
 	// step 2: sort todos
+	sorting.Now = sorting.Time()
 	todos = sortTodos(todos, sorting)
 
 	// step 3: paginate todos
-	if pagination.Limit > 0 {
-		start := (pagination.Page - 1) * pagination.Limit
+	if pagination.After != nil {
+		// seek to the first todo behind the cursor
+		start := sort.Search(len(todos), func(i int) bool {
+			return lessTodo(pagination.After, todos[i], sorting)
+		})
+		todos = todos[start:]
+		if pagination.Limit > 0 && pagination.Limit < len(todos) {
+			todos = todos[:pagination.Limit]
+		}
+	} else if pagination.Limit > 0 {
+		start := offset(pagination)
 		if start > len(todos) {
 			start = len(todos)
 		}
@@ -94,115 +193,1096 @@ func (r *JsonRepository) GetAll(filter string, sorting model.Sorting, pagination
 		}
 		todos = todos[start:end]
 	}
-	return todos, nil
+
+	page := make([]*model.Todo, len(todos))
+	for i, todo := range todos {
+		page[i] = todo.Clone()
+	}
+	return page, nil
 }
 
-func sortTodos(todos []*model.Todo, sorting model.Sorting) []*model.Todo {
-	// sort todos
-	switch sorting.SortBy {
-	case model.SortByID:
-		if sorting.SortType == model.SortAscending {
-			sort.Slice(todos, func(i, j int) bool {
-				return todos[i].ID < todos[j].ID
-			})
-		} else {
-			sort.Slice(todos, func(i, j int) bool {
-				return todos[i].ID > todos[j].ID
-			})
+// Count todos
+func (r *JsonRepository) Count(ctx context.Context, filter model.Filter) (int, error) {
+	match, err := compilePredicate(filter.Expr())
+	if err != nil {
+		return 0, err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.unlock()
+
+	count := 0
+	now := time.Now()
+	for _, todo := range r.todos {
+		if owns(ctx, todo.OwnerID) && match(todo, now) {
+			count++
 		}
-	case model.SortByTitle:
-		if sorting.SortType == model.SortAscending {
-			sort.Slice(todos, func(i, j int) bool {
-				return strings.ToLower(todos[i].Title) < strings.ToLower(todos[j].Title)
-			})
-		} else {
-			sort.Slice(todos, func(i, j int) bool {
-				return strings.ToLower(todos[i].Title) > strings.ToLower(todos[j].Title)
-			})
+	}
+	return count, nil
+}
+
+// predicate reports whether a todo matches a filter at the time now
+type predicate func(todo *model.Todo, now time.Time) bool
+
+// compilePredicate translates a filter expression into a predicate
+func compilePredicate(expr model.Expr) (predicate, error) {
+	switch expr := expr.(type) {
+	case model.And:
+		preds, err := compilePredicates(expr)
+		if err != nil {
+			return nil, err
 		}
-	case model.SortByDescription:
-		if sorting.SortType == model.SortAscending {
-			sort.Slice(todos, func(i, j int) bool {
-				return strings.ToLower(todos[i].Description) < strings.ToLower(todos[j].Description)
-			})
-		} else {
-			sort.Slice(todos, func(i, j int) bool {
-				return strings.ToLower(todos[i].Description) > strings.ToLower(todos[j].Description)
-			})
+		return func(todo *model.Todo, now time.Time) bool {
+			for _, pred := range preds {
+				if !pred(todo, now) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case model.Or:
+		preds, err := compilePredicates(expr)
+		if err != nil {
+			return nil, err
 		}
-	case model.SortByDueDate:
-		if sorting.SortType == model.SortAscending {
-			sort.Slice(todos, func(i, j int) bool {
-				return todos[i].DueDate < todos[j].DueDate
-			})
-		} else {
-			sort.Slice(todos, func(i, j int) bool {
-				return todos[i].DueDate > todos[j].DueDate
-			})
+		return func(todo *model.Todo, now time.Time) bool {
+			for _, pred := range preds {
+				if pred(todo, now) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case model.Not:
+		pred, err := compilePredicate(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(todo *model.Todo, now time.Time) bool {
+			return !pred(todo, now)
+		}, nil
+	case model.Condition:
+		return compileCondition(expr)
+	default:
+		return nil, wrap(ErrValidation, fmt.Errorf("unsupported expression %T", expr))
+	}
+}
+
+func compilePredicates(exprs []model.Expr) ([]predicate, error) {
+	preds := make([]predicate, len(exprs))
+	for i, expr := range exprs {
+		pred, err := compilePredicate(expr)
+		if err != nil {
+			return nil, err
+		}
+		preds[i] = pred
+	}
+	return preds, nil
+}
+
+func compileCondition(c model.Condition) (predicate, error) {
+	switch c.Field {
+	case model.FieldText, model.FieldTitle, model.FieldDescription:
+		if c.Op != model.OpContains {
+			break
+		}
+		text := strings.ToLower(c.Value)
+		return func(todo *model.Todo, now time.Time) bool {
+			title := c.Field != model.FieldDescription && strings.Contains(strings.ToLower(todo.Title), text)
+			description := c.Field != model.FieldTitle && strings.Contains(strings.ToLower(todo.Description), text)
+			return title || description
+		}, nil
+	case model.FieldStatus:
+		if c.Op != model.OpEqual {
+			break
+		}
+		return func(todo *model.Todo, now time.Time) bool {
+			return todo.Status == model.Status(c.Value)
+		}, nil
+	case model.FieldDue, model.FieldCompleted:
+		field := func(todo *model.Todo) *time.Time { return todo.DueDate }
+		if c.Field == model.FieldCompleted {
+			field = func(todo *model.Todo) *time.Time { return todo.CompletedAt }
+		}
+		var compare func(t time.Time) bool
+		switch c.Op {
+		case model.OpLess:
+			compare = func(t time.Time) bool { return t.Before(c.Time) }
+		case model.OpLessOrEqual:
+			compare = func(t time.Time) bool { return !t.After(c.Time) }
+		case model.OpGreater:
+			compare = func(t time.Time) bool { return t.After(c.Time) }
+		case model.OpGreaterOrEqual:
+			compare = func(t time.Time) bool { return !t.Before(c.Time) }
+		case model.OpExists:
+			compare = func(t time.Time) bool { return true }
+		}
+		if compare == nil {
+			break
+		}
+		return func(todo *model.Todo, now time.Time) bool {
+			t := field(todo)
+			return t != nil && compare(*t)
+		}, nil
+	case model.FieldTag:
+		if c.Op != model.OpEqual {
+			break
+		}
+		return func(todo *model.Todo, now time.Time) bool {
+			return containsTag(todo.Tags, c.Value)
+		}, nil
+	case model.FieldProject:
+		if c.Op != model.OpEqual {
+			break
 		}
+		id, err := conditionID(c)
+		if err != nil {
+			return nil, err
+		}
+		return func(todo *model.Todo, now time.Time) bool {
+			return todo.ProjectID == id
+		}, nil
+	case model.FieldParent:
+		if c.Op != model.OpEqual {
+			break
+		}
+		id, err := conditionID(c)
+		if err != nil {
+			return nil, err
+		}
+		return func(todo *model.Todo, now time.Time) bool {
+			return todo.ParentID == id
+		}, nil
+	case model.FieldArchived:
+		return func(todo *model.Todo, now time.Time) bool {
+			return todo.Archived
+		}, nil
+	case model.FieldOverdue:
+		return func(todo *model.Todo, now time.Time) bool {
+			return todo.Overdue(now)
+		}, nil
 	}
+	return nil, wrap(ErrValidation, fmt.Errorf("unsupported condition %s %s", c.Field, c.Op))
+}
 
+func sortTodos(todos []*model.Todo, sorting model.Sorting) []*model.Todo {
+	// the ID makes the order total, so the unstable sort.Slice gives the same order every time
+	sort.Slice(todos, func(i, j int) bool {
+		return lessTodo(todos[i], todos[j], sorting)
+	})
 	return todos
 }
 
+// lessTodo reports whether a comes before b. Todos equal in every key are ordered by ascending ID.
+func lessTodo(a, b *model.Todo, sorting model.Sorting) bool {
+	for _, key := range sorting.Keys {
+		c := compareTodos(a, b, key.SortBy, sorting.Now)
+		if c == 0 {
+			continue
+		}
+		if key.SortType == model.SortDescending {
+			return c > 0
+		}
+		return c < 0
+	}
+	return a.ID < b.ID
+}
+
+// compareTodos compares the sorted field of two todos. The smart score is computed at now.
+func compareTodos(a, b *model.Todo, sortBy model.SortBy, now time.Time) int {
+	switch sortBy {
+	case model.SortByTitle:
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	case model.SortByDescription:
+		return strings.Compare(strings.ToLower(a.Description), strings.ToLower(b.Description))
+	case model.SortByDueDate:
+		return compareTimes(a.DueDate, b.DueDate)
+	case model.SortByStatus:
+		return strings.Compare(string(a.Status), string(b.Status))
+	case model.SortByCompletedAt:
+		return compareTimes(a.CompletedAt, b.CompletedAt)
+	case model.SortByPriority:
+		return compareInts(int(a.Priority), int(b.Priority))
+	case model.SortBySmart:
+		// the higher score ranks first
+		return compareInts(b.SmartScore(now), a.SmartScore(now))
+	case model.SortByProject:
+		return compareInts(a.ProjectID, b.ProjectID)
+	case model.SortByParent:
+		return compareInts(a.ParentID, b.ParentID)
+	default:
+		return compareInts(a.ID, b.ID)
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareTimes orders missing times first, as MongoDB does
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
 // Update a todo
-func (r *JsonRepository) Update(todo *model.Todo) error {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
+func (r *JsonRepository) Update(ctx context.Context, todo *model.Todo) error {
+	if err := validate(todo); err != nil {
+		return err
+	}
 
-	for i, t := range r.todos {
-		if t.ID == todo.ID {
-			r.todos[i] = todo
-			if err := r.updateDb(); err != nil {
-				return err
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	i := r.index(ctx, todo.ID)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if todo.Version != 0 && todo.Version != r.todos[i].Version {
+		return ErrPreconditionFailed
+	}
+	todo.OwnerID = r.todos[i].OwnerID
+	if err := placeTodo(todo, r.project(ctx, todo.ProjectID), todo.ProjectID != r.todos[i].ProjectID); err != nil {
+		return err
+	}
+
+	stored := todo.Clone()
+	stored.Version = r.todos[i].Version + 1
+	if err := r.commit(walRecord{Op: opPut, Todo: stored}); err != nil {
+		return err
+	}
+	todo.Version = stored.Version
+	return nil
+}
+
+// Modify a todo
+func (r *JsonRepository) Modify(ctx context.Context, id int, change func(todo *model.Todo) error) (*model.Todo, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	i := r.index(ctx, id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+
+	todo := r.todos[i].Clone()
+	if err := change(todo); err != nil {
+		return nil, err
+	}
+	todo.ID = id
+	todo.OwnerID = r.todos[i].OwnerID
+	todo.Version = r.todos[i].Version + 1
+	if err := validate(todo); err != nil {
+		return nil, err
+	}
+	if err := placeTodo(todo, r.project(ctx, todo.ProjectID), todo.ProjectID != r.todos[i].ProjectID); err != nil {
+		return nil, err
+	}
+
+	if err := r.commit(walRecord{Op: opPut, Todo: todo.Clone()}); err != nil {
+		return nil, err
+	}
+	return todo, nil
+}
+
+// Delete a todo
+func (r *JsonRepository) Delete(ctx context.Context, id int, version int64) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	i := r.index(ctx, id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if version != 0 && version != r.todos[i].Version {
+		return ErrPreconditionFailed
+	}
+
+	return r.commit(walRecord{Op: opDelete, ID: id})
+}
+
+// Tags lists the tags of the index. The tags of an owner are counted from their todos.
+func (r *JsonRepository) Tags(ctx context.Context) ([]model.TagCount, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	counts := map[string]int{}
+	if Owner(ctx) == 0 {
+		for tag, ids := range r.tags {
+			counts[tag] = len(ids)
+		}
+	} else {
+		for _, todo := range r.todos {
+			if !owns(ctx, todo.OwnerID) {
+				continue
+			}
+			for _, tag := range todo.Tags {
+				counts[tag]++
 			}
-			return nil
 		}
 	}
 
-	return errors.New("todo not found")
+	tags := make([]model.TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, model.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
 }
 
-// Delete a todo
-func (r *JsonRepository) Delete(id int) error {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
+// RenameTags finds the todos to change in the index and logs their changes as one record
+func (r *JsonRepository) RenameTags(ctx context.Context, from []string, to string) (int, error) {
+	if err := r.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.unlock()
+
+	ids := map[int]struct{}{}
+	for _, tag := range from {
+		for id := range r.tags[tag] {
+			ids[id] = struct{}{}
+		}
+	}
+
+	var changed []*model.Todo
+	for _, stored := range r.todos {
+		if _, ok := ids[stored.ID]; !ok || !owns(ctx, stored.OwnerID) {
+			continue
+		}
+		todo := stored.Clone()
+		todo.Tags = renameTags(todo.Tags, from, to)
+		todo.Version++
+		if err := validate(todo); err != nil {
+			return 0, err
+		}
+		changed = append(changed, todo)
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	if err := r.commit(walRecord{Op: opPutAll, Todos: changed}); err != nil {
+		return 0, err
+	}
+	return len(changed), nil
+}
+
+// renameTags replaces the tags from with to
+func renameTags(tags []string, from []string, to string) []string {
+	renamed := []string{to}
+	for _, tag := range tags {
+		if !containsTag(from, tag) {
+			renamed = append(renamed, tag)
+		}
+	}
+	return model.NormalizeTags(renamed)
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// AssignOwnerless logs the assignment as one record, which changes the same todos and projects when it is
+// replayed
+func (r *JsonRepository) AssignOwnerless(ctx context.Context, ownerID int) (int, error) {
+	if err := r.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer r.unlock()
+
+	changed := 0
+	for _, todo := range r.todos {
+		if todo.OwnerID == 0 {
+			changed++
+		}
+	}
+	for _, project := range r.projects {
+		if project.OwnerID == 0 {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+	return changed, r.commit(walRecord{Op: opAssignOwnerless, ID: ownerID})
+}
+
+// CreateProject creates a new project
+func (r *JsonRepository) CreateProject(ctx context.Context, project *model.Project) error {
+	if err := validateProject(project); err != nil {
+		return err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	assignOwner(ctx, &project.OwnerID)
+	project.ID = unusedID(func(id int) bool {
+		for _, p := range r.projects {
+			if p.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+	project.Version = 1
+
+	return r.commit(walRecord{Op: opCreateProject, Project: project.Clone()})
+}
+
+// GetProject gets a project by id
+func (r *JsonRepository) GetProject(ctx context.Context, id int) (*model.Project, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
 
+	if project := r.project(ctx, id); project != nil {
+		return project.Clone(), nil
+	}
+	return nil, ErrProjectNotFound
+}
+
+// GetProjects gets all projects
+func (r *JsonRepository) GetProjects(ctx context.Context) ([]*model.Project, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	projects := []*model.Project{}
+	for _, project := range r.projects {
+		if owns(ctx, project.OwnerID) {
+			projects = append(projects, project.Clone())
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		a, b := projects[i], projects[j]
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c < 0
+		}
+		return a.ID < b.ID
+	})
+	return projects, nil
+}
+
+// ModifyProject logs the project together with the todos archived or unarchived with it
+func (r *JsonRepository) ModifyProject(ctx context.Context, id int, change func(project *model.Project) error) (*model.Project, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	stored := r.project(ctx, id)
+	if stored == nil {
+		return nil, ErrProjectNotFound
+	}
+
+	project := stored.Clone()
+	if err := change(project); err != nil {
+		return nil, err
+	}
+	project.ID = id
+	project.OwnerID = stored.OwnerID
+	project.Version = stored.Version + 1
+	if err := validateProject(project); err != nil {
+		return nil, err
+	}
+
+	rec := walRecord{Op: opPutProject, Project: project.Clone()}
+	if project.Archived != stored.Archived {
+		for _, todo := range r.todos {
+			if todo.ProjectID != id {
+				continue
+			}
+			archived := todo.Clone()
+			archived.Archived = project.Archived
+			archived.Version++
+			rec.Todos = append(rec.Todos, archived)
+		}
+	}
+
+	if err := r.commit(rec); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// DeleteProject deletes a project and its todos with a single record
+func (r *JsonRepository) DeleteProject(ctx context.Context, id int, version int64) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	project := r.project(ctx, id)
+	if project == nil {
+		return ErrProjectNotFound
+	}
+	if version != 0 && version != project.Version {
+		return ErrPreconditionFailed
+	}
+
+	return r.commit(walRecord{Op: opDeleteProject, ID: id})
+}
+
+// CreateUser creates a new user
+func (r *JsonRepository) CreateUser(ctx context.Context, user *model.User) error {
+	if err := validateUser(user); err != nil {
+		return err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if r.user(user.Name) != nil {
+		return wrap(ErrConflict, fmt.Errorf("user name %q is taken", user.Name))
+	}
+	user.ID = unusedID(func(id int) bool {
+		for _, u := range r.users {
+			if u.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+
+	return r.commit(walRecord{Op: opCreateUser, User: toStoredUser(user)})
+}
+
+// GetUser gets a user by id
+func (r *JsonRepository) GetUser(ctx context.Context, id int) (*model.User, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	for _, user := range r.users {
+		if user.ID == id {
+			return user.Clone(), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// GetUserByName gets a user by name
+func (r *JsonRepository) GetUserByName(ctx context.Context, name string) (*model.User, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	if user := r.user(model.NormalizeUserName(name)); user != nil {
+		return user.Clone(), nil
+	}
+	return nil, ErrUserNotFound
+}
+
+// user returns the stored user with the given name or nil
+func (r *JsonRepository) user(name string) *model.User {
+	for _, user := range r.users {
+		if user.Name == name {
+			return user
+		}
+	}
+	return nil
+}
+
+// CreateAPIKey creates a new API key
+func (r *JsonRepository) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	if err := validateAPIKey(key); err != nil {
+		return err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	for _, k := range r.apiKeys {
+		if k.Hash == key.Hash {
+			return wrap(ErrConflict, fmt.Errorf("the key exists"))
+		}
+	}
+	assignOwner(ctx, &key.OwnerID)
+	key.ID = unusedID(func(id int) bool {
+		for _, k := range r.apiKeys {
+			if k.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+
+	return r.commit(walRecord{Op: opCreateAPIKey, APIKey: toStoredAPIKey(key)})
+}
+
+// GetAPIKeys gets all API keys in the order they were created
+func (r *JsonRepository) GetAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	keys := []*model.APIKey{}
+	for _, key := range r.apiKeys {
+		if owns(ctx, key.OwnerID) {
+			keys = append(keys, key.Clone())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// GetAPIKeyByHash gets the API key with the given hash
+func (r *JsonRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	for _, key := range r.apiKeys {
+		if key.Hash == hash {
+			return key.Clone(), nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// TouchAPIKey sets the time an API key was last used
+func (r *JsonRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	key := r.apiKey(ctx, id)
+	if key == nil {
+		return ErrAPIKeyNotFound
+	}
+	touched := key.Clone()
+	touched.LastUsedAt = &usedAt
+	return r.commit(walRecord{Op: opPutAPIKey, APIKey: toStoredAPIKey(touched)})
+}
+
+// DeleteAPIKey deletes an API key
+func (r *JsonRepository) DeleteAPIKey(ctx context.Context, id int) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if r.apiKey(ctx, id) == nil {
+		return ErrAPIKeyNotFound
+	}
+	return r.commit(walRecord{Op: opDeleteAPIKey, ID: id})
+}
+
+// apiKey returns the stored API key with the given id in the scope of ctx or nil
+func (r *JsonRepository) apiKey(ctx context.Context, id int) *model.APIKey {
+	for _, key := range r.apiKeys {
+		if key.ID == id && owns(ctx, key.OwnerID) {
+			return key
+		}
+	}
+	return nil
+}
+
+// CreateShare creates a new share
+func (r *JsonRepository) CreateShare(ctx context.Context, share *model.Share) error {
+	if err := validateShare(share); err != nil {
+		return err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	for _, s := range r.shares {
+		if s.ResourceType == share.ResourceType && s.ResourceID == share.ResourceID && s.UserID == share.UserID {
+			return wrap(ErrConflict, fmt.Errorf("the %s is shared with user %d already", share.ResourceType, share.UserID))
+		}
+	}
+	share.ID = unusedID(func(id int) bool {
+		for _, s := range r.shares {
+			if s.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+
+	return r.commit(walRecord{Op: opCreateShare, Share: share.Clone()})
+}
+
+// GetShare gets a share by id
+func (r *JsonRepository) GetShare(ctx context.Context, id int) (*model.Share, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	if share := r.share(id); share != nil {
+		return share.Clone(), nil
+	}
+	return nil, ErrShareNotFound
+}
+
+// GetShares gets the shares matching filter in the order they were created
+func (r *JsonRepository) GetShares(ctx context.Context, filter model.ShareFilter) ([]*model.Share, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer r.unlock()
+
+	shares := []*model.Share{}
+	for _, share := range r.shares {
+		if filter.Matches(share) {
+			shares = append(shares, share.Clone())
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		if !shares[i].CreatedAt.Equal(shares[j].CreatedAt) {
+			return shares[i].CreatedAt.Before(shares[j].CreatedAt)
+		}
+		return shares[i].ID < shares[j].ID
+	})
+	return shares, nil
+}
+
+// UpdateShare stores the role and acceptance of a share
+func (r *JsonRepository) UpdateShare(ctx context.Context, share *model.Share) error {
+	if err := validateShare(share); err != nil {
+		return err
+	}
+
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	stored := r.share(share.ID)
+	if stored == nil {
+		return ErrShareNotFound
+	}
+	updated := stored.Clone()
+	updated.Role, updated.Accepted = share.Role, share.Accepted
+	return r.commit(walRecord{Op: opPutShare, Share: updated})
+}
+
+// DeleteShare deletes a share
+func (r *JsonRepository) DeleteShare(ctx context.Context, id int) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	if r.share(id) == nil {
+		return ErrShareNotFound
+	}
+	return r.commit(walRecord{Op: opDeleteShare, ID: id})
+}
+
+// share returns the stored share with the given id or nil
+func (r *JsonRepository) share(id int) *model.Share {
+	for _, share := range r.shares {
+		if share.ID == id {
+			return share
+		}
+	}
+	return nil
+}
+
+// project returns the stored project with the given id in the scope of ctx or nil
+func (r *JsonRepository) project(ctx context.Context, id int) *model.Project {
+	for _, project := range r.projects {
+		if project.ID == id && owns(ctx, project.OwnerID) {
+			return project
+		}
+	}
+	return nil
+}
+
+// index returns the position of a todo in the scope of ctx in r.todos or -1
+func (r *JsonRepository) index(ctx context.Context, id int) int {
+	i := r.position(id)
+	if i >= 0 && !owns(ctx, r.todos[i].OwnerID) {
+		return -1
+	}
+	return i
+}
+
+// position returns the position of a todo in r.todos or -1
+func (r *JsonRepository) position(id int) int {
 	for i, t := range r.todos {
 		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply changes the todos in memory
+func (r *JsonRepository) apply(rec walRecord) {
+	switch rec.Op {
+	case opCreate:
+		r.todos = append(r.todos, rec.Todo)
+		r.indexTags(rec.Todo)
+	case opCreateProject:
+		r.projects = append(r.projects, rec.Project)
+	case opCreateUser:
+		r.users = append(r.users, rec.User.user())
+	case opCreateAPIKey:
+		r.apiKeys = append(r.apiKeys, rec.APIKey.apiKey())
+	case opCreateShare:
+		r.shares = append(r.shares, rec.Share)
+	case opPut:
+		r.put(rec.Todo)
+	case opPutAll:
+		for _, todo := range rec.Todos {
+			r.put(todo)
+		}
+	case opDelete:
+		if i := r.position(rec.ID); i >= 0 {
+			r.unindexTags(r.todos[i])
 			r.todos = append(r.todos[:i], r.todos[i+1:]...)
-			if err := r.updateDb(); err != nil {
-				return err
+		}
+	case opPutProject:
+		r.putProject(rec.Project)
+		for _, todo := range rec.Todos {
+			r.put(todo)
+		}
+	case opDeleteProject:
+		r.deleteProject(rec.ID)
+	case opPutUser:
+		r.putUser(rec.User.user())
+	case opPutAPIKey:
+		r.putAPIKey(rec.APIKey.apiKey())
+	case opDeleteAPIKey:
+		for i, key := range r.apiKeys {
+			if key.ID == rec.ID {
+				r.apiKeys = append(r.apiKeys[:i], r.apiKeys[i+1:]...)
+				break
+			}
+		}
+	case opPutShare:
+		r.putShare(rec.Share)
+	case opDeleteShare:
+		for i, share := range r.shares {
+			if share.ID == rec.ID {
+				r.shares = append(r.shares[:i], r.shares[i+1:]...)
+				break
 			}
-			return nil
+		}
+	case opAssignOwnerless:
+		r.assignOwnerless(rec.ID)
+	}
+}
+
+// assignOwnerless gives the todos and projects without owner to ownerID
+func (r *JsonRepository) assignOwnerless(ownerID int) {
+	for i, todo := range r.todos {
+		if todo.OwnerID == 0 {
+			todo = todo.Clone()
+			todo.OwnerID = ownerID
+			todo.Version++
+			r.todos[i] = todo
+		}
+	}
+	for i, project := range r.projects {
+		if project.OwnerID == 0 {
+			project = project.Clone()
+			project.OwnerID = ownerID
+			project.Version++
+			r.projects[i] = project
 		}
 	}
+}
 
-	return errors.New("todo not found")
+func (r *JsonRepository) putProject(project *model.Project) {
+	for i, p := range r.projects {
+		if p.ID == project.ID {
+			r.projects[i] = project
+			return
+		}
+	}
+	r.projects = append(r.projects, project)
 }
 
-func (r *JsonRepository) updateDb() error {
+func (r *JsonRepository) deleteProject(id int) {
+	for i, project := range r.projects {
+		if project.ID == id {
+			r.projects = append(r.projects[:i], r.projects[i+1:]...)
+			break
+		}
+	}
 
-	err := r.db.Truncate(0)
-	if err != nil {
-		logrus.Infof("%v - 1", err)
-		return err
+	todos := r.todos[:0]
+	for _, todo := range r.todos {
+		if todo.ProjectID == id {
+			r.unindexTags(todo)
+			continue
+		}
+		todos = append(todos, todo)
 	}
+	r.todos = todos
+}
 
-	_, err = r.db.Seek(0, 0)
-	if err != nil {
-		logrus.Infof("%v - 2", err)
+func (r *JsonRepository) putUser(user *model.User) {
+	for i, u := range r.users {
+		if u.ID == user.ID {
+			r.users[i] = user
+			return
+		}
+	}
+	r.users = append(r.users, user)
+}
+
+func (r *JsonRepository) putAPIKey(key *model.APIKey) {
+	for i, k := range r.apiKeys {
+		if k.ID == key.ID {
+			r.apiKeys[i] = key
+			return
+		}
+	}
+	r.apiKeys = append(r.apiKeys, key)
+}
+
+func (r *JsonRepository) putShare(share *model.Share) {
+	for i, s := range r.shares {
+		if s.ID == share.ID {
+			r.shares[i] = share
+			return
+		}
+	}
+	r.shares = append(r.shares, share)
+}
+
+func (r *JsonRepository) put(todo *model.Todo) {
+	if i := r.position(todo.ID); i >= 0 {
+		r.unindexTags(r.todos[i])
+		r.todos[i] = todo
+	} else {
+		r.todos = append(r.todos, todo)
+	}
+	r.indexTags(todo)
+}
+
+func (r *JsonRepository) indexTags(todo *model.Todo) {
+	for _, tag := range todo.Tags {
+		ids, ok := r.tags[tag]
+		if !ok {
+			ids = map[int]struct{}{}
+			r.tags[tag] = ids
+		}
+		ids[todo.ID] = struct{}{}
+	}
+}
+
+func (r *JsonRepository) unindexTags(todo *model.Todo) {
+	for _, tag := range todo.Tags {
+		delete(r.tags[tag], todo.ID)
+		if len(r.tags[tag]) == 0 {
+			delete(r.tags, tag)
+		}
+	}
+}
+
+// commit logs a change and applies it once it is durable
+func (r *JsonRepository) commit(rec walRecord) error {
+	if err := appendRecord(r.wal, rec); err != nil {
+		return wrap(ErrUnavailable, err)
+	}
+	r.walRecords++
+	r.apply(rec)
+
+	if r.walRecords >= compactAfter {
+		// the change is already durable in the log, a failed compaction is retried with the next change
+		if err := r.compact(); err != nil {
+			logrus.WithError(err).Error("Could not compact write-ahead log")
+		}
+	}
+
+	return nil
+}
+
+// compact writes a new snapshot and empties the log
+func (r *JsonRepository) compact() error {
+	snap := snapshot{Todos: r.todos, Projects: r.projects, Shares: r.shares}
+	for _, user := range r.users {
+		snap.Users = append(snap.Users, toStoredUser(user))
+	}
+	for _, key := range r.apiKeys {
+		snap.APIKeys = append(snap.APIKeys, toStoredAPIKey(key))
+	}
+	if err := writeSnapshot(r.path, snap); err != nil {
 		return err
 	}
 
-	enc := json.NewEncoder(r.db)
-	if err := enc.Encode(r.todos); err != nil {
+	// replaying records already in the snapshot is harmless, so a crash before this point loses nothing
+	if err := r.wal.Truncate(0); err != nil {
 		return err
 	}
+	if _, err := r.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := r.wal.Sync(); err != nil {
+		return err
+	}
+	r.walRecords = 0
 
 	return nil
 }
 
-func (r *JsonRepository) Shutdown() error {
-	return r.db.Close()
+func (r *JsonRepository) Shutdown(ctx context.Context) error {
+	if err := r.lock(ctx); err != nil {
+		return err
+	}
+	defer r.unlock()
+
+	compactErr := r.compact()
+	if err := r.wal.Close(); err != nil {
+		return err
+	}
+	return compactErr
 }
@@ -0,0 +1,189 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository/repositorytest"
+)
+
+func TestJsonRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		db, err := os.OpenFile(filepath.Join(t.TempDir(), "db.json"), os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := repository.NewJSONRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
+
+func TestJsonRepositoryRecovery(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db.json")
+	open := func() repository.Repository {
+		t.Helper()
+		db, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := repository.NewJSONRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+
+	// changes are only in the log until the repository is shut down
+	repo := open()
+	kept := &model.Todo{Title: "kept", Status: model.StatusOpen, Tags: []string{"draft"}}
+	deleted := &model.Todo{Title: "deleted", Status: model.StatusOpen, Tags: []string{"draft"}}
+	for _, todo := range []*model.Todo{kept, deleted} {
+		if err := repo.Create(ctx, todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.Delete(ctx, deleted.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.RenameTags(ctx, []string{"draft"}, "final"); err != nil {
+		t.Fatal(err)
+	}
+	project := &model.Project{Name: "dropped"}
+	if err := repo.CreateProject(ctx, project); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Create(ctx, &model.Todo{Title: "in project", Status: model.StatusOpen, ProjectID: project.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteProject(ctx, project.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	user := &model.User{Name: "ada", PasswordHash: "hash", CreatedAt: time.Now().UTC()}
+	if err := repo.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	keys := []*model.APIKey{
+		{Name: "kept", Prefix: "todo_aaaa", Hash: "hash1", Scope: model.ScopeRead, CreatedAt: user.CreatedAt, OwnerID: user.ID},
+		{Name: "revoked", Prefix: "todo_bbbb", Hash: "hash2", Scope: model.ScopeRead, CreatedAt: user.CreatedAt, OwnerID: user.ID},
+	}
+	shares := []*model.Share{
+		{ResourceType: model.ResourceTodo, ResourceID: kept.ID, UserID: 2, Role: model.RoleViewer, CreatedAt: user.CreatedAt},
+		{ResourceType: model.ResourceTodo, ResourceID: kept.ID, UserID: 3, Role: model.RoleViewer, CreatedAt: user.CreatedAt},
+	}
+	for i := range keys {
+		if err := repo.CreateAPIKey(ctx, keys[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.CreateShare(ctx, shares[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.DeleteAPIKey(ctx, keys[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteShare(ctx, shares[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.AssignOwnerless(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash in the middle of an append leaves a partial record behind
+	wal, err := os.OpenFile(path+".wal", os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wal.WriteString(`0badc0de {"op":"put","todo":{"id":1,"ti`); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	recovered := open()
+	defer recovered.Shutdown(ctx)
+
+	todos, err := recovered.GetAll(ctx, model.Filter{}, model.Sorting{}, model.Pagination{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 1 || todos[0].ID != kept.ID {
+		t.Fatalf("recovered %+v, want only %q", todos, kept.Title)
+	}
+	if todos[0].OwnerID != user.ID {
+		t.Errorf("recovered todo has owner %d, want %d", todos[0].OwnerID, user.ID)
+	}
+	tags, err := recovered.Tags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != (model.TagCount{Tag: "final", Count: 1}) {
+		t.Errorf("recovered tags %+v, want only final", tags)
+	}
+	if _, err := recovered.GetUserByName(ctx, "ada"); err != nil {
+		t.Errorf("recovered user: %v", err)
+	}
+	recoveredKeys, err := recovered.GetAPIKeys(repository.WithOwner(ctx, user.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveredKeys) != 1 || recoveredKeys[0].ID != keys[0].ID {
+		t.Errorf("recovered keys %+v, want only %q", recoveredKeys, keys[0].Name)
+	}
+	recoveredShares, err := recovered.GetShares(ctx, model.ShareFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveredShares) != 1 || recoveredShares[0].ID != shares[0].ID {
+		t.Errorf("recovered shares %+v, want only the share with user 2", recoveredShares)
+	}
+
+	// the recovered state is compacted into the snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot struct {
+		Todos    []*model.Todo    `json:"todos"`
+		Projects []*model.Project `json:"projects"`
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("snapshot is not valid JSON: %v", err)
+	}
+	if len(snapshot.Todos) != 1 || snapshot.Todos[0].ID != kept.ID || len(snapshot.Projects) != 0 {
+		t.Errorf("snapshot holds %+v, want only %q", snapshot, kept.Title)
+	}
+}
+
+func TestJsonRepositoryArraySnapshot(t *testing.T) {
+	// db files written before projects existed hold only the array of todos
+	path := filepath.Join(t.TempDir(), "db.json")
+	if err := os.WriteFile(path, []byte(`[{"id":7,"title":"old","description":""}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := repository.NewJSONRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Shutdown(context.Background())
+
+	todo, err := repo.Get(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if todo.Title != "old" || todo.Status != model.StatusOpen || todo.Version != 1 {
+		t.Errorf("loaded %+v", todo)
+	}
+}
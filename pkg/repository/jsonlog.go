@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// JsonRepository keeps a snapshot of all todos and projects in the db file and appends every change
+// to a write-ahead log next to it (db.json.wal). A change is durable once its log record is
+// synced. The log is folded into a new snapshot every compactAfter records and on shutdown.
+// Snapshots are written to a temporary file, synced and renamed over the db file, so the
+// db file always holds a complete snapshot.
+
+// compactAfter is the number of log records that triggers a new snapshot
+const compactAfter = 100
+
+const (
+	// opCreate and the other create ops add a record, the put ops replace the record with its ID
+	opCreate        = "create"
+	opCreateProject = "createProject"
+	opCreateUser    = "createUser"
+	opCreateAPIKey  = "createAPIKey"
+	opCreateShare   = "createShare"
+	opPut           = "put"
+	opPutAll        = "putAll"
+	opDelete        = "delete"
+	// opPutProject stores a project together with the todos archived or unarchived with it
+	opPutProject = "putProject"
+	// opDeleteProject deletes a project and its todos
+	opDeleteProject = "deleteProject"
+	opPutUser       = "putUser"
+	opPutAPIKey     = "putAPIKey"
+	opDeleteAPIKey  = "deleteAPIKey"
+	opPutShare      = "putShare"
+	opDeleteShare   = "deleteShare"
+	// opAssignOwnerless gives the todos and projects without owner to the user ID
+	opAssignOwnerless = "assignOwnerless"
+)
+
+// walRecord is a single change in the write-ahead log. Replaying a record twice has no further effect.
+type walRecord struct {
+	Op   string      `json:"op"`
+	Todo *model.Todo `json:"todo,omitempty"`
+	// Todos of opPutAll and opPutProject, stored together
+	Todos   []*model.Todo  `json:"todos,omitempty"`
+	Project *model.Project `json:"project,omitempty"`
+	User    *storedUser    `json:"user,omitempty"`
+	APIKey  *storedAPIKey  `json:"apiKey,omitempty"`
+	Share   *model.Share   `json:"share,omitempty"`
+	ID      int            `json:"id,omitempty"`
+}
+
+// snapshot is the content of the db file
+type snapshot struct {
+	Todos    []*model.Todo    `json:"todos"`
+	Projects []*model.Project `json:"projects"`
+	Users    []*storedUser    `json:"users,omitempty"`
+	APIKeys  []*storedAPIKey  `json:"apiKeys,omitempty"`
+	Shares   []*model.Share   `json:"shares,omitempty"`
+}
+
+// storedUser is a user in the db file together with the password hash, which model.User leaves out of JSON
+type storedUser struct {
+	model.User
+	PasswordHash string `json:"passwordHash"`
+}
+
+func toStoredUser(user *model.User) *storedUser {
+	return &storedUser{User: *user, PasswordHash: user.PasswordHash}
+}
+
+func (u *storedUser) user() *model.User {
+	user := u.User
+	user.PasswordHash = u.PasswordHash
+	return &user
+}
+
+// storedAPIKey is an API key in the db file together with its hash, which model.APIKey leaves out of JSON
+type storedAPIKey struct {
+	model.APIKey
+	Hash string `json:"hash"`
+}
+
+func toStoredAPIKey(key *model.APIKey) *storedAPIKey {
+	return &storedAPIKey{APIKey: *key.Clone(), Hash: key.Hash}
+}
+
+func (k *storedAPIKey) apiKey() *model.APIKey {
+	key := k.APIKey.Clone()
+	key.Hash = k.Hash
+	return key
+}
+
+// readSnapshot decodes the db file. Db files written before projects existed hold only the array of todos.
+func readSnapshot(r io.Reader) (snapshot, error) {
+	var snap snapshot
+	var data json.RawMessage
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		if err == io.EOF {
+			return snap, nil
+		}
+		return snap, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(data, &snap.Todos)
+		return snap, err
+	}
+	err := json.Unmarshal(data, &snap)
+	return snap, err
+}
+
+// walPath returns the path of the write-ahead log of a db file
+func walPath(dbPath string) string {
+	return dbPath + ".wal"
+}
+
+// openLog opens the write-ahead log, hands every intact record to apply and drops a corrupt tail
+// left behind by a crash during an append. The returned file is positioned for appending.
+func openLog(path string, apply func(rec walRecord)) (*os.File, int, error) {
+	wal, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader := bufio.NewReader(wal)
+	var offset int64
+	records := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			wal.Close()
+			return nil, 0, err
+		}
+
+		rec, ok := decodeRecord(line)
+		if !ok {
+			logrus.WithFields(logrus.Fields{
+				"wal":    path,
+				"offset": offset,
+			}).Warn("Discarding corrupt tail of write-ahead log")
+			if err := wal.Truncate(offset); err != nil {
+				wal.Close()
+				return nil, 0, err
+			}
+			break
+		}
+
+		apply(rec)
+		offset += int64(len(line))
+		records++
+	}
+
+	if _, err := wal.Seek(offset, io.SeekStart); err != nil {
+		wal.Close()
+		return nil, 0, err
+	}
+
+	return wal, records, nil
+}
+
+// encodeRecord encodes a record as one line: the CRC-32 of the JSON document, a space and the document
+func encodeRecord(rec walRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)), nil
+}
+
+// decodeRecord reports false for incomplete lines, checksum mismatches and undecodable records
+func decodeRecord(line []byte) (walRecord, bool) {
+	var rec walRecord
+	if len(line) < 10 || line[len(line)-1] != '\n' || line[8] != ' ' {
+		return rec, false
+	}
+
+	data := bytes.TrimSuffix(line[9:], []byte("\n"))
+	var sum uint32
+	if _, err := fmt.Sscanf(string(line[:8]), "%08x", &sum); err != nil || sum != crc32.ChecksumIEEE(data) {
+		return rec, false
+	}
+
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, false
+	}
+	switch rec.Op {
+	case opCreate, opPut:
+		return rec, rec.Todo != nil
+	case opCreateProject, opPutProject:
+		return rec, rec.Project != nil
+	case opCreateUser, opPutUser:
+		return rec, rec.User != nil
+	case opCreateAPIKey, opPutAPIKey:
+		return rec, rec.APIKey != nil
+	case opCreateShare, opPutShare:
+		return rec, rec.Share != nil
+	case opPutAll, opDelete, opDeleteProject, opDeleteAPIKey, opDeleteShare, opAssignOwnerless:
+		return rec, true
+	}
+	return rec, false
+}
+
+// appendRecord writes a record to the log and waits until it is on disk
+func appendRecord(wal *os.File, rec walRecord) error {
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	// remember where the record starts, so that a partial write can be taken back
+	offset, err := wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := wal.Write(line); err != nil {
+		wal.Truncate(offset)
+		wal.Seek(offset, io.SeekStart)
+		return err
+	}
+	return wal.Sync()
+}
+
+// writeSnapshot atomically replaces the db file with the given snapshot
+func writeSnapshot(path string, snap snapshot) error {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if snap.Todos == nil {
+		snap.Todos = []*model.Todo{}
+	}
+	if snap.Projects == nil {
+		snap.Projects = []*model.Project{}
+	}
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// persist the rename itself, not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// removeTempFiles deletes snapshots left behind by a crash before their rename
+func removeTempFiles(path string) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, name+".*.tmp"))
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
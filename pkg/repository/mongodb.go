@@ -3,126 +3,1142 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
 type MongoRepository struct {
 	collection *mongo.Collection
+	// projects is the collection of the projects, next to the todos
+	projects *mongo.Collection
+	users    *mongo.Collection
+	apiKeys  *mongo.Collection
+	shares   *mongo.Collection
+	// sharedClient is set when the client is shared with other repositories and must stay connected on shutdown
+	sharedClient bool
 }
 
 var _ Repository = (*MongoRepository)(nil)
 
+// caseInsensitive makes string sorting match the lowercase comparison of JsonRepository
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// indexTimeout bounds the creation of the indexes on startup
+const indexTimeout = 10 * time.Second
+
 func NewMongoRepository(client *mongo.Client, databaseName, collectionName string) (*MongoRepository, error) {
 	collection := client.Database(databaseName).Collection(collectionName)
-	return &MongoRepository{collection: collection}, nil
+	projects := client.Database(databaseName).Collection("projects")
+	users := client.Database(databaseName).Collection("users")
+	apiKeys := client.Database(databaseName).Collection("apiKeys")
+	shares := client.Database(databaseName).Collection("shares")
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+
+	// the unique id index rejects a drawn id that is taken, a multikey index on the tags array serves tag
+	// filters and the tag list, and the others the todos of a project, the subtasks of a todo and the
+	// todos of a user
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "projectId", Value: 1}}},
+		{Keys: bson.D{{Key: "parentId", Value: 1}}},
+		{Keys: bson.D{{Key: "ownerId", Value: 1}}},
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	_, err = projects.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	// the unique name index rejects taken names
+	_, err = users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	// keys are looked up by their unique hash and listed by owner
+	_, err = apiKeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "ownerId", Value: 1}}},
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	// a resource is shared with a user once, and the shares of a user are listed
+	_, err = shares.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "resourceType", Value: 1}, {Key: "resourceId", Value: 1}, {Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	migrateCtx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+	migrated, err := migrateDueDates(migrateCtx, collection)
+	if err != nil {
+		return nil, err
+	}
+	if migrated > 0 {
+		logrus.WithFields(logrus.Fields{"database": databaseName, "todos": migrated}).Info("Migrated legacy due dates")
+	}
+
+	return &MongoRepository{collection: collection, projects: projects, users: users, apiKeys: apiKeys, shares: shares}, nil
+}
+
+// migrationTimeout bounds the migration of legacy documents on startup
+const migrationTimeout = time.Minute
+
+// migrateDueDates stores the due dates of documents written before they were timestamps as dates. The
+// first version stored free-form strings under duedate, its updates strings under dueDate, which win.
+// A due date that cannot be parsed is logged and dropped, so that the todo can be read again.
+func migrateDueDates(ctx context.Context, collection *mongo.Collection) (int, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"duedate": bson.M{"$exists": true}},
+		bson.M{"dueDate": bson.M{"$type": "string"}},
+	}}
+	projection := bson.M{"_id": 1, "id": 1, "duedate": 1, "dueDate": 1, "timeZone": 1}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, mongoError(err)
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ObjectID interface{}   `bson:"_id"`
+			ID       int           `bson:"id"`
+			Legacy   bson.RawValue `bson:"duedate"`
+			DueDate  bson.RawValue `bson:"dueDate"`
+			TimeZone string        `bson:"timeZone"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, mongoError(err)
+		}
+
+		set, unset := bson.M{}, bson.M{"duedate": ""}
+		value, ok := doc.DueDate.StringValueOK()
+		if !ok && doc.DueDate.Type == 0 {
+			// only the first version's field is there
+			value, ok = doc.Legacy.StringValueOK()
+			if !ok {
+				value, ok = "", true
+			}
+		}
+		if ok {
+			due, allDay, err := model.ParseDueDate(value, doc.TimeZone)
+			switch {
+			case value == "":
+				unset["dueDate"], unset["allDay"] = "", ""
+			case err != nil:
+				logrus.WithError(err).WithField("todo", doc.ID).Warn("Dropping a legacy due date that cannot be parsed")
+				unset["dueDate"], unset["allDay"] = "", ""
+			default:
+				set["dueDate"] = due
+				if allDay {
+					set["allDay"] = true
+				}
+			}
+		}
+
+		update := bson.M{"$unset": unset}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if _, err := collection.UpdateByID(ctx, doc.ObjectID, update); err != nil {
+			return migrated, mongoError(err)
+		}
+		migrated++
+	}
+	return migrated, mongoError(cursor.Err())
+}
+
+// mongoOwner limits the query document filter to the owner of ctx
+func mongoOwner(ctx context.Context, filter bson.M) bson.M {
+	if owner := Owner(ctx); owner != 0 {
+		filter["ownerId"] = owner
+	}
+	return filter
+}
+
+// normalize fills in the fields of documents stored before the fields existed
+func normalize(todo *model.Todo) {
+	if todo.Status == "" {
+		todo.Status = model.StatusOpen
+	}
+	// renamed tags are merged without keeping their order
+	todo.Tags = model.NormalizeTags(todo.Tags)
 }
 
 // Create method using MongoDB
-func (r *MongoRepository) Create(todo *model.Todo) error {
-	_, err := r.collection.InsertOne(context.Background(), todo)
-	return err
+func (r *MongoRepository) Create(ctx context.Context, todo *model.Todo) error {
+	if err := validate(todo); err != nil {
+		return err
+	}
+
+	assignOwner(ctx, &todo.OwnerID)
+	if err := r.checkProject(ctx, todo, true); err != nil {
+		return err
+	}
+
+	todo.Version = 1
+	return insertWithID(ctx, r.collection, todo, &todo.ID)
 }
 
-func (r *MongoRepository) Get(id int) (*model.Todo, error) {
-	filter := bson.M{"id": id}
-	result := r.collection.FindOne(context.Background(), filter)
+// checkProject looks up the project of a todo for placeTodo. A project archived or deleted
+// concurrently may miss the todo, unlike in the other backends.
+func (r *MongoRepository) checkProject(ctx context.Context, todo *model.Todo, moved bool) error {
+	var project *model.Project
+	if todo.ProjectID != 0 {
+		var err error
+		project, err = r.GetProject(ctx, todo.ProjectID)
+		if err != nil && !errors.Is(err, ErrProjectNotFound) {
+			return err
+		}
+	}
+	return placeTodo(todo, project, moved)
+}
+
+func (r *MongoRepository) Get(ctx context.Context, id int) (*model.Todo, error) {
+	filter := mongoOwner(ctx, bson.M{"id": id})
+	result := r.collection.FindOne(ctx, filter)
 	if result.Err() != nil {
-		return nil, result.Err()
+		return nil, mongoError(result.Err())
 	}
 	var todo model.Todo
 	err := result.Decode(&todo)
 	if err != nil {
-		return nil, err
+		return nil, mongoError(err)
 	}
+	normalize(&todo)
 	return &todo, nil
 }
 
-func (r *MongoRepository) GetAll(filterS string, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error) {
+func (r *MongoRepository) GetAll(ctx context.Context, filterS model.Filter, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error) {
 	// TODO: Perhaps nice to accept default parameters (or query parameters may be optional)?
-	// Define a filter based on the provided string
-	// You can customize this filter based on your requirements
-	filter := bson.M{}
-	if filterS != "" {
-		filter["$or"] = []bson.M{
-			{"title": bson.M{"$regex": primitive.Regex{Pattern: filterS, Options: "i"}}},
-			{"description": bson.M{"$regex": primitive.Regex{Pattern: filterS, Options: "i"}}},
-		}
+	filter, err := mongoFilter(filterS.Expr(), time.Now())
+	if err != nil {
+		return nil, err
 	}
+	filter = mongoOwner(ctx, filter)
+	// the rank is computed in milliseconds, the precision of MongoDB
+	sorting.Now = sorting.Time().Truncate(time.Millisecond)
 
-	// Define options for sorting and pagination
-	options := options.Find()
-	if sorting.SortBy == model.SortByID {
-		options.SetSort(bson.D{{Key: "id", Value: sorting.SortType}})
-	} else if sorting.SortBy == model.SortByTitle {
-		options.SetSort(bson.D{{Key: "title", Value: sorting.SortType}})
-	} else if sorting.SortBy == model.SortByDescription {
-		options.SetSort(bson.D{{Key: "description", Value: sorting.SortType}})
-	} else if sorting.SortBy == model.SortByDueDate {
-		options.SetSort(bson.D{{Key: "dueDate", Value: sorting.SortType}})
+	var after bson.M
+	if pagination.After != nil {
+		after = mongoAfter(sorting, pagination.After)
 	}
-
+	var skip, limit int64
 	if pagination.Limit > 0 {
-		options.SetLimit(int64(pagination.Limit))
-		options.SetSkip(int64((pagination.Page - 1) * pagination.Limit))
+		limit = int64(pagination.Limit)
+		if pagination.After == nil {
+			skip = int64(offset(pagination))
+		}
+	}
+	var collation *options.Collation
+	if sorting.Has(model.SortByTitle) || sorting.Has(model.SortByDescription) {
+		collation = caseInsensitive
 	}
 
-	// Perform the find operation
-	cursor, err := r.collection.Find(context.Background(), filter, options)
+	var cursor *mongo.Cursor
+	if sorting.Has(model.SortBySmart) {
+		// the smart rank is computed for every todo, which find cannot sort by
+		options := options.Aggregate()
+		if collation != nil {
+			options.SetCollation(collation)
+		}
+		cursor, err = r.collection.Aggregate(ctx, mongoSmartPipeline(filter, after, sorting, skip, limit), options)
+	} else {
+		// Define options for sorting and pagination
+		options := options.Find()
+		options.SetSort(mongoSort(sorting))
+		if collation != nil {
+			options.SetCollation(collation)
+		}
+		if limit > 0 {
+			options.SetLimit(limit)
+			options.SetSkip(skip)
+		}
+		if after != nil {
+			filter = bson.M{"$and": bson.A{filter, after}}
+		}
+
+		// Perform the find operation
+		cursor, err = r.collection.Find(ctx, filter, options)
+	}
 	if err != nil {
-		return nil, err
+		return nil, mongoError(err)
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
 	// Iterate over the cursor and decode documents into []*model.Todo
 	var todos []*model.Todo
-	for cursor.Next(context.Background()) {
+	for cursor.Next(ctx) {
 		var todo model.Todo
 		if err := cursor.Decode(&todo); err != nil {
-			return nil, err
+			return nil, mongoError(err)
 		}
+		normalize(&todo)
 		todos = append(todos, &todo)
 	}
 	if err := cursor.Err(); err != nil {
-		return nil, err
+		return nil, mongoError(err)
 	}
 
 	return todos, nil
 }
 
-func (r *MongoRepository) Update(todo *model.Todo) error {
-	filter := bson.M{"id": todo.ID}
-	update := bson.M{"$set": bson.M{
-		"title":       todo.Title,
-		"description": todo.Description,
-		"dueDate":     todo.DueDate,
+// Count todos
+func (r *MongoRepository) Count(ctx context.Context, filterS model.Filter) (int, error) {
+	filter, err := mongoFilter(filterS.Expr(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+	filter = mongoOwner(ctx, filter)
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, mongoError(err)
+	}
+	return int(count), nil
+}
+
+// mongoFilter builds the query document of a filter expression
+func mongoFilter(expr model.Expr, now time.Time) (bson.M, error) {
+	switch expr := expr.(type) {
+	case model.And:
+		if len(expr) == 0 {
+			return bson.M{}, nil
+		}
+		docs, err := mongoFilters(expr, now)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$and": docs}, nil
+	case model.Or:
+		if len(expr) == 0 {
+			return bson.M{"$expr": false}, nil
+		}
+		docs, err := mongoFilters(expr, now)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$or": docs}, nil
+	case model.Not:
+		doc, err := mongoFilter(expr.Expr, now)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$nor": bson.A{doc}}, nil
+	case model.Condition:
+		return mongoCondition(expr, now)
+	default:
+		return nil, wrap(ErrValidation, fmt.Errorf("unsupported expression %T", expr))
+	}
+}
+
+func mongoFilters(exprs []model.Expr, now time.Time) (bson.A, error) {
+	docs := bson.A{}
+	for _, expr := range exprs {
+		doc, err := mongoFilter(expr, now)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// mongoOps are the query operators of the date comparisons
+var mongoOps = map[model.Op]string{
+	model.OpLess:           "$lt",
+	model.OpLessOrEqual:    "$lte",
+	model.OpGreater:        "$gt",
+	model.OpGreaterOrEqual: "$gte",
+}
+
+func mongoCondition(c model.Condition, now time.Time) (bson.M, error) {
+	switch c.Field {
+	case model.FieldText, model.FieldTitle, model.FieldDescription:
+		if c.Op != model.OpContains {
+			break
+		}
+		// the text is matched literally, as it is by the other backends
+		regex := bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(c.Value), Options: "i"}}
+		switch c.Field {
+		case model.FieldTitle:
+			return bson.M{"title": regex}, nil
+		case model.FieldDescription:
+			return bson.M{"description": regex}, nil
+		}
+		return bson.M{"$or": bson.A{bson.M{"title": regex}, bson.M{"description": regex}}}, nil
+	case model.FieldStatus:
+		if c.Op != model.OpEqual {
+			break
+		}
+		// documents stored before statuses existed are open
+		if model.Status(c.Value) == model.StatusOpen {
+			return bson.M{"status": bson.M{"$in": bson.A{c.Value, nil}}}, nil
+		}
+		return bson.M{"status": c.Value}, nil
+	case model.FieldDue, model.FieldCompleted:
+		key := "dueDate"
+		if c.Field == model.FieldCompleted {
+			key = "completedAt"
+		}
+		if c.Op == model.OpExists {
+			return bson.M{key: bson.M{"$type": "date"}}, nil
+		}
+		if op, ok := mongoOps[c.Op]; ok {
+			return bson.M{key: bson.M{"$type": "date", op: c.Time}}, nil
+		}
+	case model.FieldTag:
+		if c.Op != model.OpEqual {
+			break
+		}
+		return bson.M{"tags": c.Value}, nil
+	case model.FieldProject:
+		if c.Op != model.OpEqual {
+			break
+		}
+		id, err := conditionID(c)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"projectId": id}, nil
+	case model.FieldParent:
+		if c.Op != model.OpEqual {
+			break
+		}
+		id, err := conditionID(c)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"parentId": id}, nil
+	case model.FieldArchived:
+		return bson.M{"archived": true}, nil
+	case model.FieldOverdue:
+		return bson.M{
+			"$or": bson.A{
+				bson.M{"allDay": bson.M{"$ne": true}, "dueDate": bson.M{"$lt": now}},
+				bson.M{"allDay": true, "dueDate": bson.M{"$lt": now.Add(-24 * time.Hour)}},
+			},
+			"status": bson.M{"$nin": bson.A{model.StatusDone, model.StatusCancelled}},
+		}, nil
+	}
+	return nil, wrap(ErrValidation, fmt.Errorf("unsupported condition %s %s", c.Field, c.Op))
+}
+
+// mongoSortKey returns the document field of a SortBy
+func mongoSortKey(sortBy model.SortBy) string {
+	switch sortBy {
+	case model.SortByTitle:
+		return "title"
+	case model.SortByDescription:
+		return "description"
+	case model.SortByDueDate:
+		return "dueDate"
+	case model.SortByStatus:
+		return "status"
+	case model.SortByCompletedAt:
+		return "completedAt"
+	case model.SortByPriority:
+		return "priority"
+	case model.SortBySmart:
+		return mongoSmartField
+	case model.SortByProject:
+		return "projectId"
+	case model.SortByParent:
+		return "parentId"
+	default:
+		return "id"
+	}
+}
+
+// mongoSmartField holds the smart rank computed by mongoSmartPipeline
+const mongoSmartField = "smartRank"
+
+// mongoSmartPipeline finds the todos matching filter and after sorted by sorting, one of whose keys is
+// smart. The rank is added to the documents for sorting and removed again before they are returned.
+func mongoSmartPipeline(filter, after bson.M, sorting model.Sorting, skip, limit int64) mongo.Pipeline {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{mongoSmartField: mongoSmartRank(sorting.Now)}}},
+	}
+	if after != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: after}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: mongoSort(sorting)}})
+	if skip > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: skip}})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	return append(pipeline, bson.D{{Key: "$project", Value: bson.M{mongoSmartField: 0}}})
+}
+
+// mongoSmartRank computes the negated model.Todo.SmartScore at now, so that the todo to do next sorts first
+func mongoSmartRank(now time.Time) bson.M {
+	const day = int64(24 * time.Hour / time.Millisecond)
+	deadline := bson.M{"$add": bson.A{"$dueDate", bson.M{"$cond": bson.A{"$allDay", day, 0}}}}
+	// days returns the full days from from to to, which is later
+	days := func(from, to interface{}) bson.M {
+		return bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{to, from}}, day}}}
+	}
+	base := bson.M{"$add": bson.A{1, bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{"$priority", 0}}, model.SmartPriorityWeight}}}}
+
+	score := bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{
+				"case": bson.M{"$in": bson.A{"$status", bson.A{model.StatusDone, model.StatusCancelled}}},
+				"then": 0,
+			},
+			bson.M{
+				"case": bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$dueDate", nil}}, nil}},
+				"then": base,
+			},
+			bson.M{
+				"case": bson.M{"$lt": bson.A{deadline, now}},
+				"then": bson.M{"$add": bson.A{base, model.SmartOverdueScore, bson.M{"$min": bson.A{days(deadline, now), model.SmartMaxOverdueDays}}}},
+			},
+		},
+		"default": bson.M{"$add": bson.A{base, bson.M{"$max": bson.A{0,
+			bson.M{"$subtract": bson.A{model.SmartDueScore, bson.M{"$multiply": bson.A{model.SmartDueDecay, days(now, deadline)}}}},
+		}}}},
 	}}
+	return bson.M{"$subtract": bson.A{0, score}}
+}
+
+// mongoSort returns the sort document of a sorting. Todos equal in every key are ordered by id.
+func mongoSort(sorting model.Sorting) bson.D {
+	sort := bson.D{}
+	for _, key := range sorting.Keys {
+		direction := 1
+		if key.SortType == model.SortDescending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: mongoSortKey(key.SortBy), Value: direction})
+		if key.SortBy == model.SortByID {
+			return sort
+		}
+	}
+	return append(sort, bson.E{Key: "id", Value: 1})
+}
+
+// mongoSortValue returns the value of a todo stored in the field of sortBy, nil for a missing date,
+// priority, project or parent. The smart rank is computed at now.
+func mongoSortValue(todo *model.Todo, sortBy model.SortBy, now time.Time) interface{} {
+	switch sortBy {
+	case model.SortByTitle:
+		return todo.Title
+	case model.SortByDescription:
+		return todo.Description
+	case model.SortByDueDate:
+		if todo.DueDate != nil {
+			return *todo.DueDate
+		}
+	case model.SortByStatus:
+		return todo.Status
+	case model.SortByCompletedAt:
+		if todo.CompletedAt != nil {
+			return *todo.CompletedAt
+		}
+	case model.SortByPriority:
+		if todo.Priority != model.PriorityNone {
+			return int(todo.Priority)
+		}
+	case model.SortBySmart:
+		return -todo.SmartScore(now)
+	case model.SortByProject:
+		if todo.ProjectID != 0 {
+			return todo.ProjectID
+		}
+	case model.SortByParent:
+		if todo.ParentID != 0 {
+			return todo.ParentID
+		}
+	default:
+		return todo.ID
+	}
+	return nil
+}
+
+// mongoAfter matches the todos sorted behind after: those behind it in the first key, or equal in
+// the first key and behind it in the second and so on. Missing values sort first, as they do in find.
+func mongoAfter(sorting model.Sorting, after *model.Todo) bson.M {
+	keys := sorting.Keys
+	if len(keys) == 0 || keys[len(keys)-1].SortBy != model.SortByID {
+		keys = append(keys[:len(keys):len(keys)], model.SortKey{SortBy: model.SortByID})
+	}
+
+	or := bson.A{}
+	equal := bson.A{}
+	for _, key := range keys {
+		field := mongoSortKey(key.SortBy)
+		value := mongoSortValue(after, key.SortBy, sorting.Now)
+
+		var behind bson.M
+		switch {
+		case key.SortType == model.SortAscending && value == nil:
+			behind = bson.M{field: bson.M{"$ne": nil}}
+		case key.SortType == model.SortAscending:
+			behind = bson.M{field: bson.M{"$gt": value}}
+		case value != nil:
+			behind = bson.M{"$or": bson.A{bson.M{field: bson.M{"$lt": value}}, bson.M{field: nil}}}
+		}
+		if behind != nil {
+			or = append(or, bson.M{"$and": append(equal[:len(equal):len(equal)], behind)})
+		}
+
+		if key.SortBy == model.SortByID {
+			break
+		}
+		equal = append(equal, bson.M{field: value})
+	}
+
+	if len(or) == 0 {
+		return bson.M{"$expr": false}
+	}
+	return bson.M{"$or": or}
+}
+
+func (r *MongoRepository) Update(ctx context.Context, todo *model.Todo) error {
+	stored, err := r.Modify(ctx, todo.ID, func(current *model.Todo) error {
+		if todo.Version != 0 && todo.Version != current.Version {
+			return ErrPreconditionFailed
+		}
+		*current = *todo.Clone()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	todo.Version = stored.Version
+	return nil
+}
+
+// modifyAttempts bounds how often Modify starts over after concurrent changes
+const modifyAttempts = 10
+
+func (r *MongoRepository) Modify(ctx context.Context, id int, change func(todo *model.Todo) error) (*model.Todo, error) {
+	for attempt := 0; attempt < modifyAttempts; attempt++ {
+		todo, err := r.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		version, projectID, ownerID := todo.Version, todo.ProjectID, todo.OwnerID
+		before, err := toDocument(todo)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := change(todo); err != nil {
+			return nil, err
+		}
+		todo.ID = id
+		todo.OwnerID = ownerID
+		todo.Version = version
+		if err := validate(todo); err != nil {
+			return nil, err
+		}
+		if err := r.checkProject(ctx, todo, todo.ProjectID != projectID); err != nil {
+			return nil, err
+		}
+		after, err := toDocument(todo)
+		if err != nil {
+			return nil, err
+		}
+
+		// write only the fields that changed, so that concurrent changes of other fields survive
+		set, unset := bson.M{}, bson.M{}
+		for key, value := range after {
+			if !reflect.DeepEqual(before[key], value) {
+				set[key] = value
+			}
+		}
+		for key := range before {
+			if _, ok := after[key]; !ok {
+				unset[key] = ""
+			}
+		}
+
+		update := bson.M{"$inc": bson.M{"version": 1}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+		filter := bson.M{"id": id, "version": versionFilter(version)}
+		result, err := r.collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return nil, mongoError(err)
+		}
+		if result.MatchedCount == 1 {
+			todo.Version = version + 1
+			return todo, nil
+		}
+		// the todo was changed or deleted since it was read, start over with its current state
+	}
+
+	return nil, wrap(ErrConflict, errors.New("todo is changed concurrently"))
+}
+
+// versionFilter matches a stored version. Documents written before versions existed have none.
+func versionFilter(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// notFoundOrPrecondition tells why a conditional write did not match any document
+func (r *MongoRepository) notFoundOrPrecondition(ctx context.Context, id int) error {
+	n, err := r.collection.CountDocuments(ctx, mongoOwner(ctx, bson.M{"id": id}))
+	if err != nil {
+		return mongoError(err)
+	}
+	if n > 0 {
+		return ErrPreconditionFailed
+	}
+	return ErrNotFound
+}
+
+// toDocument returns the fields of a todo as they are stored
+func toDocument(todo *model.Todo) (bson.M, error) {
+	data, err := bson.Marshal(todo)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (r *MongoRepository) Delete(ctx context.Context, id int, version int64) error {
+	filter := mongoOwner(ctx, bson.M{"id": id})
+	if version != 0 {
+		filter["version"] = version
+	}
+	result, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.DeletedCount == 0 {
+		return r.notFoundOrPrecondition(ctx, id)
+	}
+	return nil
+}
+
+// Tags counts the tags with an aggregation using the multikey index
+func (r *MongoRepository) Tags(ctx context.Context) ([]model.TagCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: mongoOwner(ctx, bson.M{})}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	tags := []model.TagCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, mongoError(err)
+	}
+	return tags, nil
+}
+
+// RenameTags changes every todo with a single update. Unlike the other backends, readers may see
+// some todos renamed before others.
+func (r *MongoRepository) RenameTags(ctx context.Context, from []string, to string) (int, error) {
+	filter := mongoOwner(ctx, bson.M{"tags": bson.M{"$in": from}})
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tags":    bson.M{"$setUnion": bson.A{bson.M{"$setDifference": bson.A{"$tags", from}}, bson.A{to}}},
+			"version": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$version", 1}}, 1}},
+		}}},
+	}
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, mongoError(err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
+// AssignOwnerless updates the todos and then the projects without owner. Documents stored before user
+// accounts existed have no ownerId field.
+func (r *MongoRepository) AssignOwnerless(ctx context.Context, ownerID int) (int, error) {
+	filter := bson.M{"ownerId": bson.M{"$in": bson.A{nil, 0}}}
+	update := bson.M{"$set": bson.M{"ownerId": ownerID}, "$inc": bson.M{"version": 1}}
+	changed := 0
+	for _, collection := range []*mongo.Collection{r.collection, r.projects} {
+		result, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return changed, mongoError(err)
+		}
+		changed += int(result.ModifiedCount)
+	}
+	return changed, nil
+}
+
+// CreateProject creates a new project
+func (r *MongoRepository) CreateProject(ctx context.Context, project *model.Project) error {
+	if err := validateProject(project); err != nil {
+		return err
+	}
+
+	assignOwner(ctx, &project.OwnerID)
+	project.Version = 1
+	return insertWithID(ctx, r.projects, project, &project.ID)
+}
+
+// GetProject gets a project by id
+func (r *MongoRepository) GetProject(ctx context.Context, id int) (*model.Project, error) {
+	var project model.Project
+	err := r.projects.FindOne(ctx, mongoOwner(ctx, bson.M{"id": id})).Decode(&project)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &project, nil
+}
+
+// GetProjects gets all projects, ordered by name ignoring case
+func (r *MongoRepository) GetProjects(ctx context.Context) ([]*model.Project, error) {
+	options := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "id", Value: 1}}).
+		SetCollation(caseInsensitive)
+	cursor, err := r.projects.Find(ctx, mongoOwner(ctx, bson.M{}), options)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	projects := []*model.Project{}
+	if err := cursor.All(ctx, &projects); err != nil {
+		return nil, mongoError(err)
+	}
+	return projects, nil
+}
+
+// ModifyProject replaces the project if it is unchanged since it was read, then archives or unarchives
+// its todos. Readers may see the project archived before its todos.
+func (r *MongoRepository) ModifyProject(ctx context.Context, id int, change func(project *model.Project) error) (*model.Project, error) {
+	for attempt := 0; attempt < modifyAttempts; attempt++ {
+		project, err := r.GetProject(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		version, archived, ownerID := project.Version, project.Archived, project.OwnerID
+
+		if err := change(project); err != nil {
+			return nil, err
+		}
+		project.ID = id
+		project.OwnerID = ownerID
+		project.Version = version + 1
+		if err := validateProject(project); err != nil {
+			return nil, err
+		}
+
+		result, err := r.projects.ReplaceOne(ctx, bson.M{"id": id, "version": version}, project)
+		if err != nil {
+			return nil, mongoError(err)
+		}
+		if result.MatchedCount == 0 {
+			// the project was changed or deleted since it was read, start over with its current state
+			continue
+		}
+
+		if project.Archived != archived {
+			update := bson.M{"$inc": bson.M{"version": 1}}
+			if project.Archived {
+				update["$set"] = bson.M{"archived": true}
+			} else {
+				update["$unset"] = bson.M{"archived": ""}
+			}
+			if _, err := r.collection.UpdateMany(ctx, bson.M{"projectId": id}, update); err != nil {
+				return nil, mongoError(err)
+			}
+		}
+		return project, nil
+	}
+
+	return nil, wrap(ErrConflict, errors.New("project is changed concurrently"))
+}
+
+// DeleteProject deletes the project, then its todos
+func (r *MongoRepository) DeleteProject(ctx context.Context, id int, version int64) error {
+	filter := mongoOwner(ctx, bson.M{"id": id})
+	if version != 0 {
+		filter["version"] = version
+	}
+	result, err := r.projects.DeleteOne(ctx, filter)
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.DeletedCount == 0 {
+		n, err := r.projects.CountDocuments(ctx, mongoOwner(ctx, bson.M{"id": id}))
+		if err != nil {
+			return mongoError(err)
+		}
+		if n > 0 {
+			return ErrPreconditionFailed
+		}
+		return ErrProjectNotFound
+	}
+
+	_, err = r.collection.DeleteMany(ctx, bson.M{"projectId": id})
+	return mongoError(err)
+}
+
+// CreateUser creates a new user. The unique name index rejects taken names.
+func (r *MongoRepository) CreateUser(ctx context.Context, user *model.User) error {
+	if err := validateUser(user); err != nil {
+		return err
+	}
+
+	return insertWithID(ctx, r.users, user, &user.ID)
+}
 
-	result, err := r.collection.UpdateOne(context.Background(), filter, update)
+// GetUser gets a user by id
+func (r *MongoRepository) GetUser(ctx context.Context, id int) (*model.User, error) {
+	return r.getUser(ctx, bson.M{"id": id})
+}
+
+// GetUserByName gets a user by name
+func (r *MongoRepository) GetUserByName(ctx context.Context, name string) (*model.User, error) {
+	return r.getUser(ctx, bson.M{"name": model.NormalizeUserName(name)})
+}
+
+func (r *MongoRepository) getUser(ctx context.Context, filter bson.M) (*model.User, error) {
+	var user model.User
+	err := r.users.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &user, nil
+}
+
+// CreateAPIKey creates a new API key. The unique hash index rejects duplicate keys.
+func (r *MongoRepository) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	if err := validateAPIKey(key); err != nil {
 		return err
 	}
-	if result.ModifiedCount == 0 {
-		return errors.New("todo not found")
+
+	assignOwner(ctx, &key.OwnerID)
+	return insertWithID(ctx, r.apiKeys, key, &key.ID)
+}
+
+// GetAPIKeys gets all API keys in the order they were created
+func (r *MongoRepository) GetAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	options := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "id", Value: 1}})
+	cursor, err := r.apiKeys.Find(ctx, mongoOwner(ctx, bson.M{}), options)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	keys := []*model.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, mongoError(err)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash gets the API key with the given hash
+func (r *MongoRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.apiKeys.FindOne(ctx, bson.M{"hash": hash}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &key, nil
+}
+
+// TouchAPIKey sets the time an API key was last used
+func (r *MongoRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	result, err := r.apiKeys.UpdateOne(ctx, mongoOwner(ctx, bson.M{"id": id}), bson.M{"$set": bson.M{"lastUsedAt": usedAt}})
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
 	}
 	return nil
 }
 
-func (r *MongoRepository) Delete(id int) error {
-	filter := bson.M{"id": id}
-	_, err := r.collection.DeleteOne(context.Background(), filter)
-	return err
+// DeleteAPIKey deletes an API key
+func (r *MongoRepository) DeleteAPIKey(ctx context.Context, id int) error {
+	result, err := r.apiKeys.DeleteOne(ctx, mongoOwner(ctx, bson.M{"id": id}))
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
 }
 
-func (r *MongoRepository) Shutdown() error {
+// CreateShare creates a new share. The unique index rejects sharing a resource with a user twice.
+func (r *MongoRepository) CreateShare(ctx context.Context, share *model.Share) error {
+	if err := validateShare(share); err != nil {
+		return err
+	}
+
+	return insertWithID(ctx, r.shares, share, &share.ID)
+}
+
+// GetShare gets a share by id
+func (r *MongoRepository) GetShare(ctx context.Context, id int) (*model.Share, error) {
+	var share model.Share
+	err := r.shares.FindOne(ctx, bson.M{"id": id}).Decode(&share)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &share, nil
+}
+
+// GetShares gets the shares matching filter in the order they were created
+func (r *MongoRepository) GetShares(ctx context.Context, filter model.ShareFilter) ([]*model.Share, error) {
+	query := bson.M{}
+	if filter.ResourceType != "" {
+		query["resourceType"] = filter.ResourceType
+	}
+	if filter.ResourceID != 0 {
+		query["resourceId"] = filter.ResourceID
+	}
+	if filter.UserID != 0 {
+		query["userId"] = filter.UserID
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "id", Value: 1}})
+	cursor, err := r.shares.Find(ctx, query, options)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	shares := []*model.Share{}
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, mongoError(err)
+	}
+	return shares, nil
+}
+
+// UpdateShare stores the role and acceptance of a share
+func (r *MongoRepository) UpdateShare(ctx context.Context, share *model.Share) error {
+	if err := validateShare(share); err != nil {
+		return err
+	}
+
+	result, err := r.shares.UpdateOne(ctx, bson.M{"id": share.ID}, bson.M{"$set": bson.M{"role": share.Role, "accepted": share.Accepted}})
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// DeleteShare deletes a share
+func (r *MongoRepository) DeleteShare(ctx context.Context, id int) error {
+	result, err := r.shares.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return mongoError(err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func (r *MongoRepository) Shutdown(ctx context.Context) error {
+	if r.sharedClient {
+		return nil
+	}
 	// Disconnect from the MongoDB client
-	err := r.collection.Database().Client().Disconnect(context.Background())
+	err := r.collection.Database().Client().Disconnect(ctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// insertWithID inserts doc with a new id, stored in id. An id taken already is drawn again, the unique
+// id index of the collection rejects it.
+func insertWithID(ctx context.Context, collection *mongo.Collection, doc interface{}, id *int) error {
+	for {
+		*id = newID()
+		_, err := collection.InsertOne(ctx, doc)
+		if !duplicateID(err) {
+			return mongoError(err)
+		}
+	}
+}
+
+// duplicateID reports whether err is the duplicate key error of the unique id index
+func duplicateID(err error) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == 11000 && strings.Contains(e.Message, "index: id_1 ") {
+			return true
+		}
+	}
+	return false
+}
+
+// mongoError maps driver errors to the repository errors
+func mongoError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var selectionErr topology.ServerSelectionError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return wrap(ErrConflict, err)
+	case mongo.IsNetworkError(err), mongo.IsTimeout(err), errors.As(err, &selectionErr), errors.Is(err, mongo.ErrClientDisconnected):
+		return wrap(ErrUnavailable, err)
+	}
+
+	return err
+}
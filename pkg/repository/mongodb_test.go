@@ -0,0 +1,189 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository/repositorytest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMain(m *testing.M) {
+	stop, err := startMongod()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "starting mongod:", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startMongod starts a mongod from the PATH for the tests and points TODO_TEST_MONGO_ADDR to it, unless the
+// variable names a server already. Its database lives in a temporary directory removed by stop.
+func startMongod() (stop func(), err error) {
+	path, err := exec.LookPath("mongod")
+	if os.Getenv("TODO_TEST_MONGO_ADDR") != "" || err != nil {
+		return func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "todo-mongod")
+	if err != nil {
+		return nil, err
+	}
+	// the port of a listener closed again is free unless taken in between
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, port, _ := net.SplitHostPort(addr)
+
+	cmd := exec.Command(path, "--dbpath", dir, "--bind_ip", "127.0.0.1", "--port", port, "--quiet")
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	stop = func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("mongod does not listen on %s", addr)
+		}
+	}
+	os.Setenv("TODO_TEST_MONGO_ADDR", "mongodb://"+addr)
+	return stop, nil
+}
+
+// TestMongoRepository runs against the MongoDB server of mongoAddr
+func TestMongoRepository(t *testing.T) {
+	addr := mongoAddr(t)
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		repo, err := repository.NewMongoRepository(connect(t, addr), testDatabase(t, addr), "todos")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
+
+func TestMongoLegacyDueDates(t *testing.T) {
+	addr := mongoAddr(t)
+	database := testDatabase(t, addr)
+	client := connect(t, addr)
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	// the documents of the first version, its updates and the current one
+	legacy := []interface{}{
+		bson.M{"id": 1, "title": "inserted", "description": "", "duedate": "2022-05-11T17:40:22"},
+		bson.M{"id": 2, "title": "updated", "description": "", "dueDate": "2022-05-11"},
+		bson.M{"id": 3, "title": "empty", "description": "", "duedate": ""},
+		bson.M{"id": 4, "title": "empty update", "description": "", "dueDate": ""},
+		bson.M{"id": 5, "title": "both", "description": "", "duedate": "2021-01-01", "dueDate": "2022-05-11T17:40:22Z"},
+		bson.M{"id": 6, "title": "free-form", "description": "", "duedate": "next tuesday"},
+		bson.M{"id": 7, "title": "current", "status": "open", "dueDate": time.Date(2022, 5, 11, 17, 40, 22, 0, time.UTC)},
+		bson.M{"id": 8, "title": "stale", "status": "open", "dueDate": time.Date(2022, 5, 11, 0, 0, 0, 0, time.UTC), "duedate": "2021-01-01"},
+	}
+	if _, err := client.Database(database).Collection("todos").InsertMany(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.NewMongoRepository(connect(t, addr), database, "todos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Shutdown(context.Background()) })
+
+	at := time.Date(2022, 5, 11, 17, 40, 22, 0, time.UTC)
+	day := time.Date(2022, 5, 11, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		id     int
+		due    *time.Time
+		allDay bool
+	}{
+		{1, &at, false},
+		{2, &day, true},
+		{3, nil, false},
+		{4, nil, false},
+		{5, &at, false},
+		{6, nil, false},
+		{7, &at, false},
+		{8, &day, false},
+	}
+	for _, tt := range tests {
+		todo, err := repo.Get(context.Background(), tt.id)
+		if err != nil {
+			t.Errorf("Get(%d): %v", tt.id, err)
+			continue
+		}
+		if fmt.Sprint(todo.DueDate) != fmt.Sprint(tt.due) || todo.AllDay != tt.allDay {
+			t.Errorf("todo %d is due %v (all day %t), want %v (all day %t)", tt.id, todo.DueDate, todo.AllDay, tt.due, tt.allDay)
+		}
+		if todo.Status != model.StatusOpen {
+			t.Errorf("todo %d has status %q, want open", tt.id, todo.Status)
+		}
+	}
+	todos, err := repo.GetAll(context.Background(), model.Filter{}, model.Sorting{}, model.Pagination{})
+	if err != nil || len(todos) != len(legacy) {
+		t.Errorf("GetAll = %d todos, %v, want %d", len(todos), err, len(legacy))
+	}
+
+	n, err := client.Database(database).Collection("todos").CountDocuments(context.Background(), bson.M{"duedate": bson.M{"$exists": true}})
+	if err != nil || n != 0 {
+		t.Errorf("%d documents still have duedate (%v)", n, err)
+	}
+}
+
+// mongoAddr returns the address of the MongoDB server of the tests, TODO_TEST_MONGO_ADDR or the mongod
+// started by TestMain. Without either the test is skipped, and fails in CI, which must run them.
+func mongoAddr(t *testing.T) string {
+	addr := os.Getenv("TODO_TEST_MONGO_ADDR")
+	if addr == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("neither TODO_TEST_MONGO_ADDR is set nor mongod on the PATH")
+		}
+		t.Skip("neither TODO_TEST_MONGO_ADDR is set nor mongod on the PATH")
+	}
+	return addr
+}
+
+// testDatabase returns the name of a new database, which is dropped after the test with a client of its
+// own, as Shutdown disconnects the clients of the repositories
+func testDatabase(t *testing.T, addr string) string {
+	database := fmt.Sprintf("todo_test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		admin := connect(t, addr)
+		admin.Database(database).Drop(context.Background())
+		admin.Disconnect(context.Background())
+	})
+	return database
+}
+
+func connect(t *testing.T, addr string) *mongo.Client {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
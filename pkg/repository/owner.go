@@ -0,0 +1,31 @@
+package repository
+
+import "context"
+
+type ownerKey struct{}
+
+// WithOwner scopes the repository calls made with the returned context to the todos and projects of the
+// user ownerID. Todos and projects of other users are not found, listed or counted, and new ones belong
+// to ownerID. Calls without an owner see everything.
+func WithOwner(ctx context.Context, ownerID int) context.Context {
+	return context.WithValue(ctx, ownerKey{}, ownerID)
+}
+
+// Owner returns the user the calls made with ctx are scoped to, zero for none
+func Owner(ctx context.Context) int {
+	owner, _ := ctx.Value(ownerKey{}).(int)
+	return owner
+}
+
+// owns reports whether the scope of ctx includes the todos and projects of ownerID
+func owns(ctx context.Context, ownerID int) bool {
+	owner := Owner(ctx)
+	return owner == 0 || owner == ownerID
+}
+
+// assignOwner gives a new todo or project the owner of ctx. Without one, the given owner is kept.
+func assignOwner(ctx context.Context, ownerID *int) {
+	if owner := Owner(ctx); owner != 0 {
+		*ownerID = owner
+	}
+}
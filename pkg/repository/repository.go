@@ -1,26 +1,110 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"os"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Repository stores todos and their projects, scoped to the owner of the context, see WithOwner, and the
+// users owning them. Every method gives up when its context is done and returns the context's error.
 type Repository interface {
+	ProjectRepository
+	UserRepository
+	APIKeyRepository
+	ShareRepository
+
 	// Create a new todo
-	Create(todo *model.Todo) error
+	Create(ctx context.Context, todo *model.Todo) error
 	// Get a todo by id
-	Get(id int) (*model.Todo, error)
+	Get(ctx context.Context, id int) (*model.Todo, error)
 	// Get all todos
-	GetAll(filter string, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error)
-	// Update a todo
-	Update(todo *model.Todo) error
-	// Delete a todo
-	Delete(id int) error
+	GetAll(ctx context.Context, filter model.Filter, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error)
+	// Count the todos matching filter
+	Count(ctx context.Context, filter model.Filter) (int, error)
+	// Update a todo. A non-zero todo.Version must match the stored version. The new version is set on todo.
+	Update(ctx context.Context, todo *model.Todo) error
+	// Modify a todo by calling change with its current state and storing the result.
+	// Nothing is stored when change returns an error. change may be called again when the todo
+	// is changed concurrently, so it must not have side effects. Fields that change leaves alone
+	// are not written, so concurrent modifications of other fields are kept.
+	Modify(ctx context.Context, id int, change func(todo *model.Todo) error) (*model.Todo, error)
+	// Delete a todo. A non-zero version must match the stored version.
+	Delete(ctx context.Context, id int, version int64) error
+	// Tags returns every tag with the number of todos carrying it, ordered by tag
+	Tags(ctx context.Context) ([]model.TagCount, error)
+	// RenameTags replaces the tags from with the tag to on every todo carrying any of them, merging them
+	// into one. Each todo is changed atomically and gets a new version. It returns the number of changed todos.
+	RenameTags(ctx context.Context, from []string, to string) (int, error)
+	// AssignOwnerless gives the todos and projects stored before user accounts existed, which belong to no
+	// one, to the user ownerID. Each gets a new version. It returns the number of todos and projects changed.
+	AssignOwnerless(ctx context.Context, ownerID int) (int, error)
+
+	Shutdown(ctx context.Context) error
+}
+
+// ProjectRepository stores projects. A todo can only be created in or moved to an existing project that is
+// not archived, otherwise Create, Update and Modify fail with ErrValidation or ErrConflict. The repository
+// sets Todo.Archived to the archived state of the project of the todo.
+type ProjectRepository interface {
+	// CreateProject creates a new project
+	CreateProject(ctx context.Context, project *model.Project) error
+	// GetProject gets a project by id
+	GetProject(ctx context.Context, id int) (*model.Project, error)
+	// GetProjects gets all projects ordered by name
+	GetProjects(ctx context.Context) ([]*model.Project, error)
+	// ModifyProject changes a project by calling change with its current state and storing the result.
+	// Archiving or unarchiving the project archives or unarchives its todos, each getting a new version.
+	ModifyProject(ctx context.Context, id int, change func(project *model.Project) error) (*model.Project, error)
+	// DeleteProject deletes a project together with its todos. A non-zero version must match the stored version.
+	DeleteProject(ctx context.Context, id int, version int64) error
+}
+
+// UserRepository stores user accounts. Users are not scoped to an owner.
+type UserRepository interface {
+	// CreateUser creates a new user with a normalized name. It fails with ErrConflict when the name is taken.
+	CreateUser(ctx context.Context, user *model.User) error
+	// GetUser gets a user by id
+	GetUser(ctx context.Context, id int) (*model.User, error)
+	// GetUserByName gets a user by name, which is normalized first
+	GetUserByName(ctx context.Context, name string) (*model.User, error)
+}
+
+// APIKeyRepository stores the API keys of users. Keys are scoped to the owner of the context like projects,
+// except for the lookup by hash that authenticates a request.
+type APIKeyRepository interface {
+	// CreateAPIKey creates a new key. It fails with ErrConflict when a key with the same hash exists.
+	CreateAPIKey(ctx context.Context, key *model.APIKey) error
+	// GetAPIKeys gets all keys ordered by creation time
+	GetAPIKeys(ctx context.Context) ([]*model.APIKey, error)
+	// GetAPIKeyByHash gets the key with the given hash, of any owner
+	GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	// TouchAPIKey sets the time a key was last used
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+	// DeleteAPIKey deletes a key, which revokes it
+	DeleteAPIKey(ctx context.Context, id int) error
+}
 
-	Shutdown() error
+// ShareRepository stores the shares of todos and projects. Shares are not scoped to the owner of the
+// context, since they are read by the users they are shared with; the app decides who may see them.
+type ShareRepository interface {
+	// CreateShare creates a new share. It fails with ErrConflict when the resource is shared with the
+	// user already.
+	CreateShare(ctx context.Context, share *model.Share) error
+	// GetShare gets a share by id
+	GetShare(ctx context.Context, id int) (*model.Share, error)
+	// GetShares gets the shares matching filter ordered by creation time
+	GetShares(ctx context.Context, filter model.ShareFilter) ([]*model.Share, error)
+	// UpdateShare stores the role and acceptance of a share
+	UpdateShare(ctx context.Context, share *model.Share) error
+	// DeleteShare deletes a share, which revokes it
+	DeleteShare(ctx context.Context, id int) error
 }
 
 func New(client interface{}) (Repository, error) {
@@ -29,7 +113,36 @@ func New(client interface{}) (Repository, error) {
 		return NewJSONRepository(client)
 	case *mongo.Client:
 		return NewMongoRepository(client, "todo", "todos")
+	case *sql.DB:
+		return NewSQLiteRepository(client)
 	default:
 		return nil, errors.New("unsupported client type")
 	}
 }
+
+// newID returns a random id for a new todo, project, user, API key or share. Zero stands for none, for
+// example a user id of zero for no owner, so it is never returned.
+func newID() int {
+	for {
+		if id := int(uuid.New().ID()); id != 0 {
+			return id
+		}
+	}
+}
+
+// unusedID returns a new id that taken reports as free, for stores that cannot reject a duplicate
+func unusedID(taken func(id int) bool) int {
+	for {
+		if id := newID(); !taken(id) {
+			return id
+		}
+	}
+}
+
+// offset returns the number of todos before the requested page. Pages before the first are the first page.
+func offset(pagination model.Pagination) int {
+	if pagination.Page < 1 {
+		return 0
+	}
+	return (pagination.Page - 1) * pagination.Limit
+}
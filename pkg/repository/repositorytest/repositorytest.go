@@ -0,0 +1,1567 @@
+// Package repositorytest provides a conformance suite for implementations of repository.Repository.
+//
+// A backend runs the suite from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.Run(t, func(t *testing.T) repository.Repository {
+//			return newTestRepository(t)
+//		})
+//	}
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+)
+
+// Factory returns a new, empty repository. The suite shuts it down when the test ends,
+// before the cleanup functions the factory registered.
+type Factory func(t *testing.T) repository.Repository
+
+// Run runs every conformance test against repositories created by newRepository
+func Run(t *testing.T, newRepository Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repo repository.Repository)
+	}{
+		{"Create", testCreate},
+		{"Get", testGet},
+		{"Update", testUpdate},
+		{"Modify", testModify},
+		{"Delete", testDelete},
+		{"Versions", testVersions},
+		{"Validation", testValidation},
+		{"Isolation", testIsolation},
+		{"Filter", testFilter},
+		{"Sort", testSort},
+		{"Pagination", testPagination},
+		{"Cursor", testCursor},
+		{"Tags", testTags},
+		{"Projects", testProjects},
+		{"Checklist", testChecklist},
+		{"Subtasks", testSubtasks},
+		{"Recurrence", testRecurrence},
+		{"Users", testUsers},
+		{"Owners", testOwners},
+		{"Ownerless", testOwnerless},
+		{"APIKeys", testAPIKeys},
+		{"Shares", testShares},
+		{"Comments", testComments},
+		{"Concurrency", testConcurrency},
+		{"Cancellation", testCancellation},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newRepository(t)
+			t.Cleanup(func() {
+				if err := repo.Shutdown(context.Background()); err != nil {
+					t.Errorf("Shutdown: %v", err)
+				}
+			})
+			tt.test(t, repo)
+		})
+	}
+}
+
+// base is a fixed point in time, truncated to the millisecond precision of MongoDB
+var base = time.Date(2026, time.March, 10, 9, 30, 0, 0, time.UTC)
+
+func at(days int) *time.Time {
+	t := base.AddDate(0, 0, days)
+	return &t
+}
+
+func create(t *testing.T, repo repository.Repository, todo *model.Todo) *model.Todo {
+	t.Helper()
+	if todo.Status == "" {
+		todo.Status = model.StatusOpen
+	}
+	if err := repo.Create(context.Background(), todo); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return todo
+}
+
+func get(t *testing.T, repo repository.Repository, id int) *model.Todo {
+	t.Helper()
+	todo, err := repo.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get(%d): %v", id, err)
+	}
+	return todo
+}
+
+func all(t *testing.T, repo repository.Repository, filter model.Filter, sorting model.Sorting, pagination model.Pagination) []*model.Todo {
+	t.Helper()
+	todos, err := repo.GetAll(context.Background(), filter, sorting, pagination)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	return todos
+}
+
+func ids(todos []*model.Todo) []int {
+	ids := make([]int, len(todos))
+	for i, todo := range todos {
+		ids[i] = todo.ID
+	}
+	return ids
+}
+
+func sameIDs(a, b []int) bool {
+	a = append([]int(nil), a...)
+	b = append([]int(nil), b...)
+	sort.Ints(a)
+	sort.Ints(b)
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func assertEqual(t *testing.T, got, want *model.Todo) {
+	t.Helper()
+	if got.ID != want.ID || got.Title != want.Title || got.Description != want.Description ||
+		got.AllDay != want.AllDay || got.TimeZone != want.TimeZone || got.Status != want.Status ||
+		!equalTime(got.DueDate, want.DueDate) || !equalTime(got.CompletedAt, want.CompletedAt) ||
+		(want.Version != 0 && got.Version != want.Version) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func testCreate(t *testing.T, repo repository.Repository) {
+	first := create(t, repo, &model.Todo{Title: "first"})
+	second := create(t, repo, &model.Todo{Title: "second"})
+
+	if first.ID == 0 || second.ID == 0 {
+		t.Fatalf("Create did not assign ids: %d, %d", first.ID, second.ID)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("Create assigned the same id %d twice", first.ID)
+	}
+}
+
+func testGet(t *testing.T, repo repository.Repository) {
+	want := create(t, repo, &model.Todo{
+		Title:       "Buy milk",
+		Description: "semi-skimmed",
+		DueDate:     at(1),
+		AllDay:      true,
+		TimeZone:    "Europe/Istanbul",
+		Status:      model.StatusDone,
+		CompletedAt: at(0),
+	})
+
+	assertEqual(t, get(t, repo, want.ID), want)
+
+	if _, err := repo.Get(context.Background(), want.ID+1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get of a missing todo returned %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testUpdate(t *testing.T, repo repository.Repository) {
+	todo := create(t, repo, &model.Todo{Title: "draft", DueDate: at(1)})
+
+	todo.Title = "final"
+	todo.DueDate = nil
+	todo.SetStatus(model.StatusDone, base)
+	if err := repo.Update(context.Background(), todo); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	assertEqual(t, get(t, repo, todo.ID), todo)
+
+	// an update that changes nothing is not an error
+	if err := repo.Update(context.Background(), todo); err != nil {
+		t.Errorf("Update without changes: %v", err)
+	}
+
+	if err := repo.Update(context.Background(), &model.Todo{ID: todo.ID + 1, Title: "missing", Status: model.StatusOpen}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Update of a missing todo returned %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testModify(t *testing.T, repo repository.Repository) {
+	todo := create(t, repo, &model.Todo{Title: "draft", Description: "keep me", DueDate: at(1)})
+
+	got, err := repo.Modify(context.Background(), todo.ID, func(todo *model.Todo) error {
+		todo.Title = "final"
+		todo.DueDate = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	want := &model.Todo{ID: todo.ID, Title: "final", Description: "keep me", Status: model.StatusOpen}
+	assertEqual(t, got, want)
+	assertEqual(t, get(t, repo, todo.ID), want)
+
+	// nothing is written when the change fails
+	errChange := errors.New("change failed")
+	_, err = repo.Modify(context.Background(), todo.ID, func(todo *model.Todo) error {
+		todo.Title = "discarded"
+		return errChange
+	})
+	if !errors.Is(err, errChange) {
+		t.Errorf("Modify returned %v, want %v", err, errChange)
+	}
+	_, err = repo.Modify(context.Background(), todo.ID, func(todo *model.Todo) error {
+		todo.Status = "later"
+		return nil
+	})
+	if !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("Modify to an invalid todo returned %v, want %v", err, repository.ErrValidation)
+	}
+	assertEqual(t, get(t, repo, todo.ID), want)
+
+	// the id cannot be changed
+	got, err = repo.Modify(context.Background(), todo.ID, func(todo *model.Todo) error {
+		todo.ID++
+		return nil
+	})
+	if err != nil || got.ID != todo.ID {
+		t.Errorf("Modify of the id returned %+v, %v", got, err)
+	}
+
+	if _, err := repo.Modify(context.Background(), todo.ID+1, func(*model.Todo) error { return nil }); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Modify of a missing todo returned %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testDelete(t *testing.T, repo repository.Repository) {
+	todo := create(t, repo, &model.Todo{Title: "delete me"})
+	other := create(t, repo, &model.Todo{Title: "keep me"})
+
+	if err := repo.Delete(context.Background(), todo.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := repo.Get(context.Background(), todo.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get of a deleted todo returned %v, want %v", err, repository.ErrNotFound)
+	}
+	if err := repo.Delete(context.Background(), todo.ID, 0); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Delete of a missing todo returned %v, want %v", err, repository.ErrNotFound)
+	}
+
+	get(t, repo, other.ID)
+}
+
+func testVersions(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	todo := create(t, repo, &model.Todo{Title: "v1"})
+	if todo.Version != 1 {
+		t.Fatalf("Create set version %d, want 1", todo.Version)
+	}
+
+	todo.Title = "v2"
+	if err := repo.Update(ctx, todo); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if todo.Version != 2 || get(t, repo, todo.ID).Version != 2 {
+		t.Fatalf("Update set version %d, want 2", todo.Version)
+	}
+
+	// an unconditional update ignores the version
+	todo.Version = 0
+	if err := repo.Update(ctx, todo); err != nil || todo.Version != 3 {
+		t.Fatalf("unconditional Update returned %v and version %d, want version 3", err, todo.Version)
+	}
+
+	stale := &model.Todo{ID: todo.ID, Title: "stale", Status: model.StatusOpen, Version: 2}
+	if err := repo.Update(ctx, stale); !errors.Is(err, repository.ErrPreconditionFailed) {
+		t.Errorf("Update with a stale version returned %v, want %v", err, repository.ErrPreconditionFailed)
+	}
+
+	modified, err := repo.Modify(ctx, todo.ID, func(todo *model.Todo) error {
+		todo.Title = "v4"
+		todo.Version = 100
+		return nil
+	})
+	if err != nil || modified.Version != 4 {
+		t.Fatalf("Modify returned %v and version %d, want version 4", err, modified.Version)
+	}
+	if got := get(t, repo, todo.ID); got.Title != "v4" || got.Version != 4 {
+		t.Errorf("got %+v, want title v4 at version 4", got)
+	}
+
+	if err := repo.Delete(ctx, todo.ID, 3); !errors.Is(err, repository.ErrPreconditionFailed) {
+		t.Errorf("Delete with a stale version returned %v, want %v", err, repository.ErrPreconditionFailed)
+	}
+	if err := repo.Delete(ctx, todo.ID, 4); err != nil {
+		t.Errorf("Delete with the current version: %v", err)
+	}
+	if err := repo.Delete(ctx, todo.ID, 4); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Delete of a missing todo returned %v, want %v", err, repository.ErrNotFound)
+	}
+}
+
+func testValidation(t *testing.T, repo repository.Repository) {
+	invalid := []*model.Todo{
+		{Title: "unknown status", Status: "later"},
+		{Title: "unknown time zone", Status: model.StatusOpen, TimeZone: "Mars/Olympus_Mons"},
+	}
+	for _, todo := range invalid {
+		if err := repo.Create(context.Background(), todo); !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("Create of %q returned %v, want %v", todo.Title, err, repository.ErrValidation)
+		}
+	}
+
+	todo := create(t, repo, &model.Todo{Title: "valid"})
+	todo.Status = "later"
+	if err := repo.Update(context.Background(), todo); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("Update with an unknown status returned %v, want %v", err, repository.ErrValidation)
+	}
+	if got := get(t, repo, todo.ID); got.Status != model.StatusOpen {
+		t.Errorf("rejected update changed the status to %q", got.Status)
+	}
+}
+
+func testIsolation(t *testing.T, repo repository.Repository) {
+	todo := create(t, repo, &model.Todo{Title: "original", DueDate: at(1)})
+
+	// changes to values passed in or returned must not reach the stored todo
+	todo.Title = "changed"
+	got := get(t, repo, todo.ID)
+	got.Title = "changed"
+	*got.DueDate = got.DueDate.AddDate(1, 0, 0)
+	for _, todo := range all(t, repo, model.Filter{}, model.Sorting{}, model.Pagination{}) {
+		todo.Title = "changed"
+	}
+
+	got = get(t, repo, todo.ID)
+	if got.Title != "original" {
+		t.Errorf("stored title changed to %q", got.Title)
+	}
+	if !got.DueDate.Equal(*at(1)) {
+		t.Errorf("stored due date changed to %v", got.DueDate)
+	}
+}
+
+func testFilter(t *testing.T, repo repository.Repository) {
+	groceries := create(t, repo, &model.Todo{Title: "Groceries", Description: "milk (2%)", DueDate: at(-2)})
+	laundry := create(t, repo, &model.Todo{Title: "Laundry", Description: "whites", DueDate: at(-1), Status: model.StatusInProgress})
+	taxes := create(t, repo, &model.Todo{Title: "Taxes", Description: "before april", DueDate: at(5), AllDay: true})
+	done := create(t, repo, &model.Todo{Title: "Dentist", DueDate: at(-3)})
+	done.SetStatus(model.StatusDone, base)
+	if err := repo.Update(context.Background(), done); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	undated := create(t, repo, &model.Todo{Title: "Someday", Status: model.StatusCancelled})
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		filter model.Filter
+		want   []*model.Todo
+	}{
+		{"none", model.Filter{}, []*model.Todo{groceries, laundry, taxes, done, undated}},
+		{"title", model.Filter{Text: "groc"}, []*model.Todo{groceries}},
+		{"description", model.Filter{Text: "WHITES"}, []*model.Todo{laundry}},
+		{"literal", model.Filter{Text: "(2%)"}, []*model.Todo{groceries}},
+		{"pattern", model.Filter{Text: ".*"}, nil},
+		{"status", model.Filter{Statuses: []model.Status{model.StatusOpen}}, []*model.Todo{groceries, taxes}},
+		{"statuses", model.Filter{Statuses: []model.Status{model.StatusDone, model.StatusCancelled}}, []*model.Todo{done, undated}},
+		{"due before", model.Filter{DueBefore: at(-1)}, []*model.Todo{groceries, done}},
+		{"due after", model.Filter{DueAfter: at(-1)}, []*model.Todo{laundry, taxes}},
+		{"due range", model.Filter{DueAfter: at(-2), DueBefore: at(5)}, []*model.Todo{groceries, laundry}},
+		{"combined", model.Filter{Text: "a", DueBefore: at(0), Statuses: []model.Status{model.StatusInProgress}}, []*model.Todo{laundry}},
+		{"query title", query(model.Condition{Field: model.FieldTitle, Op: model.OpContains, Value: "TAX"}), []*model.Todo{taxes}},
+		{"query description", query(model.Condition{Field: model.FieldDescription, Op: model.OpContains, Value: "april"}), []*model.Todo{taxes}},
+		{"query not description", query(model.Condition{Field: model.FieldDescription, Op: model.OpContains, Value: "taxes"}), nil},
+		{"query or", query(model.Or{
+			model.Condition{Field: model.FieldText, Op: model.OpContains, Value: "groc"},
+			model.Condition{Field: model.FieldStatus, Op: model.OpEqual, Value: string(model.StatusCancelled)},
+		}), []*model.Todo{groceries, undated}},
+		{"query empty or", query(model.Or{}), nil},
+		{"query empty and", query(model.And{}), []*model.Todo{groceries, laundry, taxes, done, undated}},
+		{"query not status", query(model.Not{Expr: model.Condition{Field: model.FieldStatus, Op: model.OpEqual, Value: string(model.StatusOpen)}}), []*model.Todo{laundry, done, undated}},
+		{"query due at most", query(model.Condition{Field: model.FieldDue, Op: model.OpLessOrEqual, Time: *at(-2)}), []*model.Todo{groceries, done}},
+		{"query due after", query(model.Condition{Field: model.FieldDue, Op: model.OpGreater, Time: *at(-1)}), []*model.Todo{taxes}},
+		{"query not due before", query(model.Not{Expr: model.Condition{Field: model.FieldDue, Op: model.OpLess, Time: *at(0)}}), []*model.Todo{taxes, undated}},
+		{"query without due date", query(model.Not{Expr: model.Condition{Field: model.FieldDue, Op: model.OpExists}}), []*model.Todo{undated}},
+		{"query completed", query(model.Condition{Field: model.FieldCompleted, Op: model.OpExists}), []*model.Todo{done}},
+		{"query and text", model.Filter{Text: "a", Query: model.Condition{Field: model.FieldStatus, Op: model.OpEqual, Value: string(model.StatusOpen)}}, []*model.Todo{taxes}},
+	}
+	if now.Before(*at(-3)) {
+		t.Fatal("the overdue test data expects a clock after " + base.String())
+	}
+	tests = append(tests, struct {
+		name   string
+		filter model.Filter
+		want   []*model.Todo
+	}{"overdue", model.Filter{Overdue: true}, []*model.Todo{groceries, laundry, taxes}}, struct {
+		name   string
+		filter model.Filter
+		want   []*model.Todo
+	}{"query not overdue", query(model.Not{Expr: model.Condition{Field: model.FieldOverdue}}), []*model.Todo{done, undated}})
+
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.name, " ", "_"), func(t *testing.T) {
+			got := all(t, repo, tt.filter, model.Sorting{}, model.Pagination{})
+			if !sameIDs(ids(got), ids(tt.want)) {
+				t.Errorf("got %v, want %v", ids(got), ids(tt.want))
+			}
+
+			count, err := repo.Count(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			if count != len(tt.want) {
+				t.Errorf("Count = %d, want %d", count, len(tt.want))
+			}
+		})
+	}
+}
+
+// query returns the filter of a query expression
+func query(expr model.Expr) model.Filter {
+	return model.Filter{Query: expr}
+}
+
+func testSort(t *testing.T, repo repository.Repository) {
+	titles := []string{"delta", "Alpha", "charlie", "Bravo", "echo"}
+	statuses := []model.Status{model.StatusOpen, model.StatusDone, model.StatusInProgress, model.StatusCancelled, model.StatusOpen}
+	priorities := []model.Priority{model.PriorityHigh, model.PriorityNone, model.PriorityLow, model.PriorityUrgent, model.PriorityNone}
+	sprint := createProject(t, repo, &model.Project{Name: "sprint"})
+	ops := createProject(t, repo, &model.Project{Name: "ops"})
+	projects := []int{sprint.ID, 0, ops.ID, sprint.ID, 0}
+	// the todos after the first are subtasks of the first, the second or none
+	parents := []int{-1, 0, -1, 1, 0}
+	var created []*model.Todo
+	for i, title := range titles {
+		todo := &model.Todo{
+			Title:       title,
+			Description: strings.ToUpper(titles[(i+2)%len(titles)]),
+			Status:      statuses[i],
+			Priority:    priorities[i],
+			ProjectID:   projects[i],
+		}
+		if parents[i] >= 0 {
+			todo.ParentID = created[parents[i]].ID
+		}
+		// leave one todo without a due date, missing dates sort first
+		if i != 2 {
+			todo.DueDate = at((i * 3) % 5)
+		}
+		if todo.Status == model.StatusDone {
+			todo.CompletedAt = at(-i)
+		}
+		created = append(created, create(t, repo, todo))
+	}
+
+	for _, sortBy := range sortKeys {
+		for _, sortType := range []model.SortType{model.SortAscending, model.SortDescending} {
+			sorting := model.SortByKeys(model.SortKey{SortBy: sortBy, SortType: sortType})
+			sorting.Now = sortNow
+			t.Run(sorting.String(), func(t *testing.T) {
+				checkOrder(t, all(t, repo, model.Filter{}, sorting, model.Pagination{}), sorting, len(titles))
+			})
+		}
+	}
+
+	multi := []model.Sorting{
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByStatus},
+			model.SortKey{SortBy: model.SortByTitle, SortType: model.SortDescending},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByCompletedAt, SortType: model.SortDescending},
+			model.SortKey{SortBy: model.SortByDueDate},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByStatus, SortType: model.SortDescending},
+			model.SortKey{SortBy: model.SortByID, SortType: model.SortDescending},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByPriority, SortType: model.SortDescending},
+			model.SortKey{SortBy: model.SortBySmart},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByProject, SortType: model.SortDescending},
+			model.SortKey{SortBy: model.SortByParent},
+			model.SortKey{SortBy: model.SortByTitle},
+		),
+	}
+	for _, sorting := range multi {
+		sorting.Now = sortNow
+		t.Run(sorting.String(), func(t *testing.T) {
+			checkOrder(t, all(t, repo, model.Filter{}, sorting, model.Pagination{}), sorting, len(titles))
+		})
+	}
+}
+
+// sortKeys are all SortBy values
+var sortKeys = []model.SortBy{
+	model.SortByID, model.SortByTitle, model.SortByDescription, model.SortByDueDate, model.SortByStatus, model.SortByCompletedAt,
+	model.SortByPriority, model.SortBySmart, model.SortByProject, model.SortByParent,
+}
+
+// sortNow is the moment the smart score is computed at, between the due dates of the todos
+var sortNow = base.Add(36 * time.Hour)
+
+// checkOrder checks that todos are sorted by sorting, with todos equal in every key ordered by ascending ID
+func checkOrder(t *testing.T, todos []*model.Todo, sorting model.Sorting, n int) {
+	t.Helper()
+	if len(todos) != n {
+		t.Fatalf("got %d todos, want %d", len(todos), n)
+	}
+	for i := 1; i < len(todos); i++ {
+		if !sortedBefore(todos[i-1], todos[i], sorting) {
+			t.Errorf("todo %d (%+v) is out of order after %+v", i, todos[i], todos[i-1])
+		}
+	}
+}
+
+// sortedBefore reports whether a must come before b
+func sortedBefore(a, b *model.Todo, sorting model.Sorting) bool {
+	for _, key := range sorting.Keys {
+		var c int
+		switch key.SortBy {
+		case model.SortByID:
+			c = a.ID - b.ID
+		case model.SortByTitle:
+			c = strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+		case model.SortByDescription:
+			c = strings.Compare(strings.ToLower(a.Description), strings.ToLower(b.Description))
+		case model.SortByDueDate:
+			c = compareTimes(a.DueDate, b.DueDate)
+		case model.SortByStatus:
+			c = strings.Compare(string(a.Status), string(b.Status))
+		case model.SortByCompletedAt:
+			c = compareTimes(a.CompletedAt, b.CompletedAt)
+		case model.SortByPriority:
+			c = int(a.Priority) - int(b.Priority)
+		case model.SortBySmart:
+			c = b.SmartScore(sorting.Now) - a.SmartScore(sorting.Now)
+		case model.SortByProject:
+			c = a.ProjectID - b.ProjectID
+		case model.SortByParent:
+			c = a.ParentID - b.ParentID
+		}
+		if key.SortType == model.SortDescending {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return a.ID < b.ID
+}
+
+// compareTimes orders missing times first
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case timeBefore(a, b):
+		return -1
+	case timeBefore(b, a):
+		return 1
+	}
+	return 0
+}
+
+// timeBefore orders missing times first
+func timeBefore(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	return a.Before(*b)
+}
+
+func testPagination(t *testing.T, repo repository.Repository) {
+	var want []int
+	for i := 0; i < 7; i++ {
+		want = append(want, create(t, repo, &model.Todo{Title: fmt.Sprintf("todo %d", i)}).ID)
+	}
+	sort.Ints(want)
+	sorting := model.SortByKeys(model.SortKey{SortBy: model.SortByID})
+
+	tests := []struct {
+		name       string
+		pagination model.Pagination
+		want       []int
+	}{
+		{"unlimited", model.Pagination{}, want},
+		{"first", model.Pagination{Page: 1, Limit: 3}, want[0:3]},
+		{"middle", model.Pagination{Page: 2, Limit: 3}, want[3:6]},
+		{"last partial", model.Pagination{Page: 3, Limit: 3}, want[6:7]},
+		{"past the end", model.Pagination{Page: 4, Limit: 3}, nil},
+		{"exact", model.Pagination{Page: 1, Limit: 7}, want},
+		{"larger than total", model.Pagination{Page: 1, Limit: 100}, want},
+		{"page zero", model.Pagination{Page: 0, Limit: 3}, want[0:3]},
+		{"negative page", model.Pagination{Page: -2, Limit: 3}, want[0:3]},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.name, " ", "_"), func(t *testing.T) {
+			got := ids(all(t, repo, model.Filter{}, sorting, tt.pagination))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testCursor(t *testing.T, repo repository.Repository) {
+	// duplicate keys and missing dates, projects and parents, so that pages end in the middle of ties
+	project := createProject(t, repo, &model.Project{Name: "sprint"})
+	var first *model.Todo
+	for i := 0; i < 9; i++ {
+		todo := &model.Todo{
+			Title:       []string{"alpha", "Bravo", "bravo"}[i%3],
+			Description: fmt.Sprintf("todo %d", i/2),
+			Status:      []model.Status{model.StatusOpen, model.StatusDone}[i%2],
+			Priority:    model.Priority(i % 3),
+		}
+		if i%3 == 1 {
+			todo.ProjectID = project.ID
+		}
+		if i%4 == 3 {
+			todo.ParentID = first.ID
+		}
+		if i%4 != 0 {
+			todo.DueDate = at(i % 3)
+			todo.AllDay = i == 6
+		}
+		if todo.Status == model.StatusDone && i%3 != 0 {
+			todo.CompletedAt = at(-(i % 2))
+		}
+		create(t, repo, todo)
+		if i == 0 {
+			first = todo
+		}
+	}
+
+	var sortings []model.Sorting
+	for _, sortBy := range sortKeys {
+		for _, sortType := range []model.SortType{model.SortAscending, model.SortDescending} {
+			sortings = append(sortings, model.SortByKeys(model.SortKey{SortBy: sortBy, SortType: sortType}))
+		}
+	}
+	sortings = append(sortings,
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByTitle},
+			model.SortKey{SortBy: model.SortByDueDate, SortType: model.SortDescending},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByDueDate, SortType: model.SortDescending},
+			model.SortKey{SortBy: model.SortByStatus},
+			model.SortKey{SortBy: model.SortByCompletedAt, SortType: model.SortDescending},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortByStatus, SortType: model.SortDescending},
+			model.SortKey{SortBy: model.SortByID, SortType: model.SortDescending},
+		),
+		model.SortByKeys(
+			model.SortKey{SortBy: model.SortBySmart},
+			model.SortKey{SortBy: model.SortByTitle, SortType: model.SortDescending},
+		),
+	)
+
+	for _, sorting := range sortings {
+		sorting := sorting
+		sorting.Now = sortNow
+		t.Run(sorting.String(), func(t *testing.T) {
+			todos := all(t, repo, model.Filter{}, sorting, model.Pagination{})
+			checkOrder(t, todos, sorting, 9)
+			want := ids(todos)
+
+			var got []int
+			pagination := model.Pagination{Limit: 2}
+			for page := 0; page < 10; page++ {
+				todos := all(t, repo, model.Filter{}, sorting, pagination)
+				if len(todos) == 0 {
+					break
+				}
+				got = append(got, ids(todos)...)
+				pagination.After = todos[len(todos)-1]
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("deleted", func(t *testing.T) {
+		sorting := model.SortByKeys(model.SortKey{SortBy: model.SortByTitle})
+		todos := all(t, repo, model.Filter{}, sorting, model.Pagination{})
+		if err := repo.Delete(context.Background(), todos[3].ID, 0); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		got := ids(all(t, repo, model.Filter{}, sorting, model.Pagination{Limit: 3, After: todos[3]}))
+		if want := ids(todos[4:7]); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func testTags(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	groceries := create(t, repo, &model.Todo{Title: "Groceries", Tags: []string{" Home", "errands", "home"}})
+	report := create(t, repo, &model.Todo{Title: "Report", Tags: []string{"work"}})
+	garden := create(t, repo, &model.Todo{Title: "Garden", Tags: []string{"home", "weekend"}})
+	untagged := create(t, repo, &model.Todo{Title: "Untagged"})
+
+	if got := get(t, repo, groceries.ID).Tags; fmt.Sprint(got) != "[errands home]" {
+		t.Errorf("stored tags %q, want them normalized", got)
+	}
+	if err := repo.Create(ctx, &model.Todo{Title: "Invalid", Tags: []string{"two words"}}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("Create with an invalid tag = %v, want ErrValidation", err)
+	}
+
+	tags, err := repo.Tags(ctx)
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if got := fmt.Sprint(tags); got != "[{errands 1} {home 2} {weekend 1} {work 1}]" {
+		t.Errorf("Tags = %s", got)
+	}
+
+	filters := []struct {
+		name   string
+		filter model.Filter
+		want   []*model.Todo
+	}{
+		{"any", model.Filter{Tags: []string{"work", "weekend"}}, []*model.Todo{report, garden}},
+		{"all", model.Filter{Tags: []string{"home", "weekend"}, TagMatch: model.TagMatchAll}, []*model.Todo{garden}},
+		{"query", query(model.Condition{Field: model.FieldTag, Op: model.OpEqual, Value: "home"}), []*model.Todo{groceries, garden}},
+		{"query not", query(model.Not{Expr: model.Condition{Field: model.FieldTag, Op: model.OpEqual, Value: "home"}}), []*model.Todo{report, untagged}},
+	}
+	for _, tt := range filters {
+		got := all(t, repo, tt.filter, model.Sorting{}, model.Pagination{})
+		if !sameIDs(ids(got), ids(tt.want)) {
+			t.Errorf("%s: got %v, want %v", tt.name, ids(got), ids(tt.want))
+		}
+	}
+
+	// changing a todo replaces its tags
+	garden.Tags = []string{"weekend"}
+	if err := repo.Update(ctx, garden); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := repo.Modify(ctx, report.ID, func(todo *model.Todo) error {
+		todo.Tags = append(todo.Tags, "urgent")
+		return nil
+	}); err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if got := get(t, repo, garden.ID).Tags; fmt.Sprint(got) != "[weekend]" {
+		t.Errorf("updated tags %q", got)
+	}
+
+	// merge errands and weekend into chores
+	n, err := repo.RenameTags(ctx, []string{"errands", "weekend"}, "chores")
+	if err != nil {
+		t.Fatalf("RenameTags: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("RenameTags changed %d todos, want 2", n)
+	}
+	renamed := get(t, repo, groceries.ID)
+	if fmt.Sprint(renamed.Tags) != "[chores home]" {
+		t.Errorf("renamed tags %q", renamed.Tags)
+	}
+	if renamed.Version != groceries.Version+1 {
+		t.Errorf("version %d after the rename, want %d", renamed.Version, groceries.Version+1)
+	}
+	if got := get(t, repo, untagged.ID); got.Version != untagged.Version {
+		t.Errorf("version of an untouched todo changed to %d", got.Version)
+	}
+
+	tags, err = repo.Tags(ctx)
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if got := fmt.Sprint(tags); got != "[{chores 2} {home 1} {urgent 1} {work 1}]" {
+		t.Errorf("Tags after the rename = %s", got)
+	}
+
+	// deleted todos leave their tags
+	if err := repo.Delete(ctx, report.ID, 0); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	tags, err = repo.Tags(ctx)
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if got := fmt.Sprint(tags); got != "[{chores 2} {home 1}]" {
+		t.Errorf("Tags after the delete = %s", got)
+	}
+}
+
+func createProject(t *testing.T, repo repository.Repository, project *model.Project) *model.Project {
+	t.Helper()
+	if err := repo.CreateProject(context.Background(), project); err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	return project
+}
+
+func testProjects(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	sprint := createProject(t, repo, &model.Project{Name: "sprint"})
+	ops := createProject(t, repo, &model.Project{Name: "Ops", Description: "on call"})
+	if sprint.ID == 0 || sprint.Version != 1 {
+		t.Fatalf("CreateProject set id %d and version %d", sprint.ID, sprint.Version)
+	}
+	if err := repo.CreateProject(ctx, &model.Project{Name: " "}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("CreateProject without a name = %v, want ErrValidation", err)
+	}
+
+	projects, err := repo.GetProjects(ctx)
+	if err != nil {
+		t.Fatalf("GetProjects: %v", err)
+	}
+	if len(projects) != 2 || projects[0].ID != ops.ID || projects[1].ID != sprint.ID {
+		t.Errorf("GetProjects = %+v, want Ops and sprint ordered by name", projects)
+	}
+	if got, err := repo.GetProject(ctx, ops.ID); err != nil || *got != *ops {
+		t.Errorf("GetProject = %+v, %v, want %+v", got, err, ops)
+	}
+
+	story := create(t, repo, &model.Todo{Title: "story", ProjectID: sprint.ID})
+	bug := create(t, repo, &model.Todo{Title: "bug", ProjectID: sprint.ID})
+	page := create(t, repo, &model.Todo{Title: "page", ProjectID: ops.ID})
+	loose := create(t, repo, &model.Todo{Title: "loose"})
+	if err := repo.Create(ctx, &model.Todo{Title: "lost", Status: model.StatusOpen, ProjectID: 1}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("Create in a missing project = %v, want ErrValidation", err)
+	}
+
+	inSprint := all(t, repo, model.Filter{ProjectID: sprint.ID}, model.Sorting{}, model.Pagination{})
+	if !sameIDs(ids(inSprint), []int{story.ID, bug.ID}) {
+		t.Errorf("todos of the project = %v, want %v", ids(inSprint), []int{story.ID, bug.ID})
+	}
+	notInSprint := all(t, repo, query(model.Not{Expr: model.Condition{Field: model.FieldProject, Op: model.OpEqual, Value: fmt.Sprint(sprint.ID)}}), model.Sorting{}, model.Pagination{})
+	if !sameIDs(ids(notInSprint), []int{page.ID, loose.ID}) {
+		t.Errorf("todos outside the project = %v, want %v", ids(notInSprint), []int{page.ID, loose.ID})
+	}
+
+	// archiving the project archives its todos
+	archived, err := repo.ModifyProject(ctx, sprint.ID, func(project *model.Project) error {
+		project.Archived = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ModifyProject: %v", err)
+	}
+	if !archived.Archived || archived.Version != 2 {
+		t.Errorf("archived project %+v, want archived at version 2", archived)
+	}
+	if got := get(t, repo, story.ID); !got.Archived || got.Version != story.Version+1 {
+		t.Errorf("todo of the archived project %+v, want archived with a new version", got)
+	}
+	if got := get(t, repo, page.ID); got.Archived || got.Version != page.Version {
+		t.Errorf("todo of another project changed to %+v", got)
+	}
+	yes, no := true, false
+	if got := all(t, repo, model.Filter{Archived: &yes}, model.Sorting{}, model.Pagination{}); !sameIDs(ids(got), []int{story.ID, bug.ID}) {
+		t.Errorf("archived todos = %v", ids(got))
+	}
+	if got := all(t, repo, model.Filter{Archived: &no}, model.Sorting{}, model.Pagination{}); !sameIDs(ids(got), []int{page.ID, loose.ID}) {
+		t.Errorf("unarchived todos = %v", ids(got))
+	}
+
+	// todos stay in an archived project but no new ones enter it
+	if _, err := repo.Modify(ctx, story.ID, func(todo *model.Todo) error {
+		todo.Title = "story done"
+		todo.Archived = false
+		return nil
+	}); err != nil {
+		t.Fatalf("Modify of an archived todo: %v", err)
+	}
+	if got := get(t, repo, story.ID); !got.Archived {
+		t.Errorf("Modify unarchived a todo of an archived project")
+	}
+	if err := repo.Create(ctx, &model.Todo{Title: "late", Status: model.StatusOpen, ProjectID: sprint.ID}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("Create in an archived project = %v, want ErrConflict", err)
+	}
+	loose.ProjectID = sprint.ID
+	if err := repo.Update(ctx, loose); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("moving a todo to an archived project = %v, want ErrConflict", err)
+	}
+
+	// moving a todo out of the archived project unarchives it
+	moved, err := repo.Modify(ctx, bug.ID, func(todo *model.Todo) error {
+		todo.ProjectID = ops.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if moved.Archived || get(t, repo, bug.ID).Archived {
+		t.Errorf("todo moved out of an archived project is still archived")
+	}
+
+	if _, err := repo.ModifyProject(ctx, sprint.ID, func(project *model.Project) error {
+		project.Archived = false
+		return nil
+	}); err != nil {
+		t.Fatalf("ModifyProject: %v", err)
+	}
+	if get(t, repo, story.ID).Archived {
+		t.Errorf("todo of an unarchived project is still archived")
+	}
+	if _, err := repo.ModifyProject(ctx, sprint.ID, func(project *model.Project) error {
+		project.Name = ""
+		return nil
+	}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("ModifyProject without a name = %v, want ErrValidation", err)
+	}
+
+	// deleting a project deletes its todos
+	if err := repo.DeleteProject(ctx, sprint.ID, 1); !errors.Is(err, repository.ErrPreconditionFailed) {
+		t.Errorf("DeleteProject of a stale version = %v, want ErrPreconditionFailed", err)
+	}
+	if err := repo.DeleteProject(ctx, sprint.ID, 0); err != nil {
+		t.Fatalf("DeleteProject: %v", err)
+	}
+	if _, err := repo.Get(ctx, story.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get of a todo of a deleted project = %v, want ErrNotFound", err)
+	}
+	get(t, repo, bug.ID)
+	get(t, repo, loose.ID)
+	if _, err := repo.GetProject(ctx, sprint.ID); !errors.Is(err, repository.ErrProjectNotFound) || !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetProject of a deleted project = %v, want ErrProjectNotFound", err)
+	}
+	if err := repo.DeleteProject(ctx, sprint.ID, 0); !errors.Is(err, repository.ErrProjectNotFound) {
+		t.Errorf("DeleteProject of a missing project = %v, want ErrProjectNotFound", err)
+	}
+	if _, err := repo.ModifyProject(ctx, sprint.ID, func(*model.Project) error { return nil }); !errors.Is(err, repository.ErrProjectNotFound) {
+		t.Errorf("ModifyProject of a missing project = %v, want ErrProjectNotFound", err)
+	}
+}
+
+func testChecklist(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	todo := create(t, repo, &model.Todo{Title: "groceries", Checklist: []model.ChecklistItem{
+		{Text: "milk"}, {Text: "eggs", Done: true}, {Text: "bread"},
+	}})
+
+	stored := get(t, repo, todo.ID)
+	if got := fmt.Sprint(stored.Checklist); got != "[{1 milk false} {2 eggs true} {3 bread false}]" {
+		t.Fatalf("stored checklist %s, want the items numbered in order", got)
+	}
+
+	// new items get the next free ids, the order is kept
+	changed, err := repo.Modify(ctx, todo.ID, func(todo *model.Todo) error {
+		todo.Checklist = []model.ChecklistItem{todo.Checklist[2], {Text: "butter"}, todo.Checklist[0]}
+		todo.Checklist[2].Done = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	want := "[{3 bread false} {4 butter false} {1 milk true}]"
+	if got := fmt.Sprint(changed.Checklist); got != want {
+		t.Errorf("Modify returned checklist %s, want %s", got, want)
+	}
+	if got := fmt.Sprint(get(t, repo, todo.ID).Checklist); got != want {
+		t.Errorf("stored checklist %s, want %s", got, want)
+	}
+
+	invalid := []struct {
+		name      string
+		checklist []model.ChecklistItem
+	}{
+		{"empty text", []model.ChecklistItem{{Text: ""}}},
+		{"duplicate id", []model.ChecklistItem{{ID: 1, Text: "a"}, {ID: 1, Text: "b"}}},
+		{"negative id", []model.ChecklistItem{{ID: -1, Text: "a"}}},
+	}
+	for _, tt := range invalid {
+		err := repo.Create(ctx, &model.Todo{Title: tt.name, Status: model.StatusOpen, Checklist: tt.checklist})
+		if !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("Create with %s = %v, want ErrValidation", tt.name, err)
+		}
+	}
+
+	// removing every item removes the checklist
+	if _, err := repo.Modify(ctx, todo.ID, func(todo *model.Todo) error {
+		todo.Checklist = nil
+		return nil
+	}); err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if got := get(t, repo, todo.ID).Checklist; len(got) != 0 {
+		t.Errorf("checklist %v left after removing every item", got)
+	}
+
+	// the ids of removed items are not given to new ones
+	changed, err = repo.Modify(ctx, todo.ID, func(todo *model.Todo) error {
+		todo.Checklist = []model.ChecklistItem{{Text: "cheese"}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if got := fmt.Sprint(changed.Checklist); got != "[{5 cheese false}]" {
+		t.Errorf("checklist %s after removing every item, want [{5 cheese false}]", got)
+	}
+}
+
+func testRecurrence(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	// rules are stored in their canonical form
+	todo := create(t, repo, &model.Todo{Title: "chores", DueDate: at(0), Recurrence: "RRULE:freq=weekly;byday=fr,mo"})
+	if got := get(t, repo, todo.ID).Recurrence; got != "FREQ=WEEKLY;BYDAY=MO,FR" {
+		t.Errorf("stored recurrence %q, want FREQ=WEEKLY;BYDAY=MO,FR", got)
+	}
+
+	invalid := []struct {
+		name string
+		todo *model.Todo
+	}{
+		{"invalid rule", &model.Todo{Title: "invalid", Status: model.StatusOpen, DueDate: at(0), Recurrence: "FREQ=SOMETIMES"}},
+		{"no due date", &model.Todo{Title: "no due date", Status: model.StatusOpen, Recurrence: "FREQ=DAILY"}},
+	}
+	for _, tt := range invalid {
+		if err := repo.Create(ctx, tt.todo); !errors.Is(err, repository.ErrValidation) {
+			t.Errorf("Create with %s = %v, want ErrValidation", tt.name, err)
+		}
+	}
+
+	// ending the series leaves a todo that does not repeat
+	if _, err := repo.Modify(ctx, todo.ID, func(todo *model.Todo) error {
+		todo.Recurrence = ""
+		return nil
+	}); err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if got := get(t, repo, todo.ID).Recurrence; got != "" {
+		t.Errorf("recurrence %q left after ending the series", got)
+	}
+}
+
+func testSubtasks(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	parent := create(t, repo, &model.Todo{Title: "parent"})
+	first := create(t, repo, &model.Todo{Title: "first", ParentID: parent.ID})
+	second := create(t, repo, &model.Todo{Title: "second", ParentID: parent.ID})
+	nested := create(t, repo, &model.Todo{Title: "nested", ParentID: first.ID})
+	create(t, repo, &model.Todo{Title: "other"})
+
+	if got := get(t, repo, first.ID).ParentID; got != parent.ID {
+		t.Errorf("stored parent %d, want %d", got, parent.ID)
+	}
+	subtasks := all(t, repo, model.Filter{ParentID: parent.ID}, model.Sorting{}, model.Pagination{})
+	if !sameIDs(ids(subtasks), []int{first.ID, second.ID}) {
+		t.Errorf("subtasks %v, want %v", ids(subtasks), []int{first.ID, second.ID})
+	}
+	if n, err := repo.Count(ctx, model.Filter{ParentID: first.ID}); err != nil || n != 1 {
+		t.Errorf("Count of the subtasks = %d, %v, want 1", n, err)
+	}
+
+	// a subtask becomes a top-level todo without its parent
+	if _, err := repo.Modify(ctx, nested.ID, func(todo *model.Todo) error {
+		todo.ParentID = 0
+		return nil
+	}); err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if got := get(t, repo, nested.ID).ParentID; got != 0 {
+		t.Errorf("parent %d left after removing it", got)
+	}
+
+	if _, err := repo.Modify(ctx, parent.ID, func(todo *model.Todo) error {
+		todo.ParentID = todo.ID
+		return nil
+	}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("Modify making a todo its own subtask = %v, want ErrValidation", err)
+	}
+}
+
+func testUsers(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	ada := &model.User{Name: " Ada ", PasswordHash: "hash", CreatedAt: base}
+	if err := repo.CreateUser(ctx, ada); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if ada.ID == 0 || ada.Name != "ada" {
+		t.Fatalf("CreateUser set id %d and name %q", ada.ID, ada.Name)
+	}
+
+	if err := repo.CreateUser(ctx, &model.User{Name: "ADA", PasswordHash: "other"}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("CreateUser with a taken name = %v, want ErrConflict", err)
+	}
+	if err := repo.CreateUser(ctx, &model.User{Name: "a b", PasswordHash: "hash"}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("CreateUser with an invalid name = %v, want ErrValidation", err)
+	}
+
+	for _, get := range []func() (*model.User, error){
+		func() (*model.User, error) { return repo.GetUser(ctx, ada.ID) },
+		func() (*model.User, error) { return repo.GetUserByName(ctx, "Ada") },
+	} {
+		got, err := get()
+		if err != nil {
+			t.Fatalf("get user: %v", err)
+		}
+		if got.ID != ada.ID || got.Name != "ada" || got.PasswordHash != "hash" || !got.CreatedAt.Equal(base) {
+			t.Errorf("got %+v, want %+v", got, ada)
+		}
+	}
+
+	if _, err := repo.GetUser(ctx, ada.ID+1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUser of a missing user = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetUserByName(ctx, "grace"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserByName of a missing user = %v, want ErrNotFound", err)
+	}
+}
+
+func testOwnerless(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	ada := repository.WithOwner(ctx, 1)
+
+	// todos and projects created without an owner belong to no one
+	project := &model.Project{Name: "legacy"}
+	if err := repo.CreateProject(ctx, project); err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	legacy := create(t, repo, &model.Todo{Title: "legacy", ProjectID: project.ID})
+	theirs := &model.Todo{Title: "theirs", Status: model.StatusOpen}
+	if err := repo.Create(repository.WithOwner(ctx, 2), theirs); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	n, err := repo.AssignOwnerless(ctx, 1)
+	if err != nil {
+		t.Fatalf("AssignOwnerless: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("AssignOwnerless changed %d todos and projects, want 2", n)
+	}
+	todo, err := repo.Get(ada, legacy.ID)
+	if err != nil {
+		t.Fatalf("Get of an assigned todo: %v", err)
+	}
+	if todo.OwnerID != 1 || todo.Version != legacy.Version+1 {
+		t.Errorf("assigned todo has owner %d and version %d, want 1 and %d", todo.OwnerID, todo.Version, legacy.Version+1)
+	}
+	if _, err := repo.GetProject(ada, project.ID); err != nil {
+		t.Errorf("GetProject of an assigned project: %v", err)
+	}
+	if todo := get(t, repo, theirs.ID); todo.OwnerID != 2 || todo.Version != theirs.Version {
+		t.Errorf("todo of another user has owner %d and version %d after AssignOwnerless", todo.OwnerID, todo.Version)
+	}
+
+	if n, err := repo.AssignOwnerless(ctx, 3); err != nil || n != 0 {
+		t.Errorf("AssignOwnerless without ownerless todos = %d, %v, want 0", n, err)
+	}
+}
+
+func testOwners(t *testing.T, repo repository.Repository) {
+	ada := repository.WithOwner(context.Background(), 1)
+	grace := repository.WithOwner(context.Background(), 2)
+
+	project := &model.Project{Name: "thesis", OwnerID: 2}
+	if err := repo.CreateProject(ada, project); err != nil {
+		t.Fatalf("CreateProject: %v", err)
+	}
+	if project.OwnerID != 1 {
+		t.Errorf("CreateProject set owner %d, want 1", project.OwnerID)
+	}
+	mine := &model.Todo{Title: "mine", Status: model.StatusOpen, Tags: []string{"shared", "ada"}, ProjectID: project.ID, OwnerID: 2}
+	if err := repo.Create(ada, mine); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if mine.OwnerID != 1 {
+		t.Errorf("Create set owner %d, want 1", mine.OwnerID)
+	}
+	theirs := &model.Todo{Title: "theirs", Status: model.StatusOpen, Tags: []string{"shared"}}
+	if err := repo.Create(grace, theirs); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// the todos and projects of other users are not found
+	if _, err := repo.Get(grace, mine.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get of another user's todo = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.Modify(grace, mine.ID, func(todo *model.Todo) error { return nil }); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Modify of another user's todo = %v, want ErrNotFound", err)
+	}
+	if err := repo.Update(grace, mine.Clone()); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Update of another user's todo = %v, want ErrNotFound", err)
+	}
+	if err := repo.Delete(grace, mine.ID, mine.Version); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Delete of another user's todo = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetProject(grace, project.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetProject of another user's project = %v, want ErrNotFound", err)
+	}
+	if err := repo.DeleteProject(grace, project.ID, 0); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteProject of another user's project = %v, want ErrNotFound", err)
+	}
+	moved := theirs.Clone()
+	moved.ProjectID = project.ID
+	if err := repo.Update(grace, moved); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("moving a todo to another user's project = %v, want ErrValidation", err)
+	}
+
+	for _, tt := range []struct {
+		ctx  context.Context
+		want []*model.Todo
+	}{
+		{ada, []*model.Todo{mine}},
+		{grace, []*model.Todo{theirs}},
+		{context.Background(), []*model.Todo{mine, theirs}},
+	} {
+		todos, err := repo.GetAll(tt.ctx, model.Filter{}, model.Sorting{}, model.Pagination{})
+		if err != nil {
+			t.Fatalf("GetAll: %v", err)
+		}
+		if !sameIDs(ids(todos), ids(tt.want)) {
+			t.Errorf("GetAll of owner %d = %v, want %v", repository.Owner(tt.ctx), ids(todos), ids(tt.want))
+		}
+		count, err := repo.Count(tt.ctx, model.Filter{Tags: []string{"shared"}})
+		if err != nil {
+			t.Fatalf("Count: %v", err)
+		}
+		if count != len(tt.want) {
+			t.Errorf("Count of owner %d = %d, want %d", repository.Owner(tt.ctx), count, len(tt.want))
+		}
+	}
+
+	projects, err := repo.GetProjects(grace)
+	if err != nil {
+		t.Fatalf("GetProjects: %v", err)
+	}
+	if len(projects) != 0 {
+		t.Errorf("GetProjects of another user = %+v", projects)
+	}
+
+	tags, err := repo.Tags(grace)
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if got := fmt.Sprint(tags); got != "[{shared 1}]" {
+		t.Errorf("Tags of another user = %s", got)
+	}
+	n, err := repo.RenameTags(grace, []string{"shared"}, "common")
+	if err != nil {
+		t.Fatalf("RenameTags: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("RenameTags changed %d todos, want only the user's own", n)
+	}
+	if got := get(t, repo, mine.ID).Tags; fmt.Sprint(got) != "[ada shared]" {
+		t.Errorf("tags of another user's todo renamed to %q", got)
+	}
+
+	// the owner cannot be changed
+	changed := mine.Clone()
+	changed.OwnerID = 2
+	if err := repo.Update(ada, changed); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	modified, err := repo.Modify(ada, mine.ID, func(todo *model.Todo) error {
+		todo.OwnerID = 2
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if modified.OwnerID != 1 || get(t, repo, mine.ID).OwnerID != 1 {
+		t.Errorf("the owner changed to %d", get(t, repo, mine.ID).OwnerID)
+	}
+	archived, err := repo.ModifyProject(ada, project.ID, func(project *model.Project) error {
+		project.OwnerID = 2
+		project.Archived = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ModifyProject: %v", err)
+	}
+	if archived.OwnerID != 1 {
+		t.Errorf("the owner of the project changed to %d", archived.OwnerID)
+	}
+	if err := repo.DeleteProject(ada, project.ID, 0); err != nil {
+		t.Errorf("DeleteProject: %v", err)
+	}
+}
+
+func testAPIKeys(t *testing.T, repo repository.Repository) {
+	ada := repository.WithOwner(context.Background(), 1)
+	grace := repository.WithOwner(context.Background(), 2)
+
+	first := &model.APIKey{Name: "backup", Prefix: "todo_aaaa", Hash: "hash1", Scope: model.ScopeRead, CreatedAt: base, OwnerID: 2}
+	if err := repo.CreateAPIKey(ada, first); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if first.ID == 0 || first.OwnerID != 1 {
+		t.Fatalf("CreateAPIKey set id %d and owner %d", first.ID, first.OwnerID)
+	}
+	second := &model.APIKey{Name: "sync", Prefix: "todo_bbbb", Hash: "hash2", Scope: model.ScopeWrite, CreatedAt: base.Add(time.Hour)}
+	if err := repo.CreateAPIKey(ada, second); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if err := repo.CreateAPIKey(grace, &model.APIKey{Name: "other", Prefix: "todo_cccc", Hash: "hash3", Scope: model.ScopeAdmin, CreatedAt: base}); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+
+	if err := repo.CreateAPIKey(grace, &model.APIKey{Name: "copy", Hash: "hash1", Scope: model.ScopeRead, CreatedAt: base}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("CreateAPIKey with a taken hash = %v, want ErrConflict", err)
+	}
+	if err := repo.CreateAPIKey(ada, &model.APIKey{Name: "bad", Hash: "hash4", Scope: "root", CreatedAt: base}); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("CreateAPIKey with an invalid scope = %v, want ErrValidation", err)
+	}
+
+	keys, err := repo.GetAPIKeys(ada)
+	if err != nil {
+		t.Fatalf("GetAPIKeys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ID != first.ID || keys[1].ID != second.ID {
+		t.Fatalf("GetAPIKeys = %+v, want the user's keys in the order they were created", keys)
+	}
+	if keys[0].Name != "backup" || keys[0].Prefix != "todo_aaaa" || keys[0].Hash != "hash1" || keys[0].Scope != model.ScopeRead ||
+		!keys[0].CreatedAt.Equal(base) || keys[0].LastUsedAt != nil {
+		t.Errorf("GetAPIKeys returned %+v, want %+v", keys[0], first)
+	}
+
+	// keys are looked up by hash before the owner is known
+	got, err := repo.GetAPIKeyByHash(context.Background(), "hash2")
+	if err != nil {
+		t.Fatalf("GetAPIKeyByHash: %v", err)
+	}
+	if got.ID != second.ID || got.OwnerID != 1 || got.Scope != model.ScopeWrite {
+		t.Errorf("GetAPIKeyByHash = %+v, want %+v", got, second)
+	}
+	if _, err := repo.GetAPIKeyByHash(context.Background(), "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash of a missing key = %v, want ErrNotFound", err)
+	}
+
+	usedAt := base.Add(2 * time.Hour)
+	if err := repo.TouchAPIKey(ada, first.ID, usedAt); err != nil {
+		t.Fatalf("TouchAPIKey: %v", err)
+	}
+	got, err = repo.GetAPIKeyByHash(ada, "hash1")
+	if err != nil {
+		t.Fatalf("GetAPIKeyByHash: %v", err)
+	}
+	if got.LastUsedAt == nil || !got.LastUsedAt.Equal(usedAt) {
+		t.Errorf("last used at %v, want %v", got.LastUsedAt, usedAt)
+	}
+
+	// the keys of other users cannot be changed
+	if err := repo.TouchAPIKey(grace, first.ID, usedAt); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("TouchAPIKey of another user's key = %v, want ErrNotFound", err)
+	}
+	if err := repo.DeleteAPIKey(grace, first.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteAPIKey of another user's key = %v, want ErrNotFound", err)
+	}
+
+	if err := repo.DeleteAPIKey(ada, first.ID); err != nil {
+		t.Fatalf("DeleteAPIKey: %v", err)
+	}
+	if _, err := repo.GetAPIKeyByHash(ada, "hash1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetAPIKeyByHash of a deleted key = %v, want ErrNotFound", err)
+	}
+	if err := repo.DeleteAPIKey(ada, first.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteAPIKey of a deleted key = %v, want ErrNotFound", err)
+	}
+}
+
+func testShares(t *testing.T, repo repository.Repository) {
+	ctx := repository.WithOwner(context.Background(), 1)
+	project := &model.Share{ResourceType: model.ResourceProject, ResourceID: 10, UserID: 2, Role: model.RoleEditor, CreatedAt: base}
+	todo := &model.Share{ResourceType: model.ResourceTodo, ResourceID: 20, UserID: 2, Role: model.RoleViewer, CreatedAt: base.Add(time.Hour)}
+	other := &model.Share{ResourceType: model.ResourceProject, ResourceID: 10, UserID: 3, Role: model.RoleCommenter, CreatedAt: base.Add(2 * time.Hour)}
+	for _, share := range []*model.Share{project, todo, other} {
+		if err := repo.CreateShare(ctx, share); err != nil {
+			t.Fatalf("CreateShare: %v", err)
+		}
+		if share.ID == 0 {
+			t.Fatalf("CreateShare set no id")
+		}
+	}
+
+	again := &model.Share{ResourceType: model.ResourceProject, ResourceID: 10, UserID: 2, Role: model.RoleViewer, CreatedAt: base}
+	if err := repo.CreateShare(ctx, again); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("CreateShare of a shared project = %v, want ErrConflict", err)
+	}
+	owner := &model.Share{ResourceType: model.ResourceTodo, ResourceID: 20, UserID: 3, Role: model.RoleOwner, CreatedAt: base}
+	if err := repo.CreateShare(ctx, owner); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("CreateShare with the owner role = %v, want ErrValidation", err)
+	}
+
+	// shares are not scoped to the owner of the context, the users they are shared with read them
+	got, err := repo.GetShare(repository.WithOwner(context.Background(), 2), project.ID)
+	if err != nil {
+		t.Fatalf("GetShare: %v", err)
+	}
+	if got.ResourceType != project.ResourceType || got.ResourceID != project.ResourceID || got.UserID != project.UserID ||
+		got.Role != project.Role || got.Accepted || !got.CreatedAt.Equal(base) {
+		t.Errorf("GetShare = %+v, want %+v", got, project)
+	}
+	if _, err := repo.GetShare(ctx, project.ID+1); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetShare of a missing share = %v, want ErrNotFound", err)
+	}
+
+	for _, tt := range []struct {
+		filter model.ShareFilter
+		want   []*model.Share
+	}{
+		{model.ShareFilter{}, []*model.Share{project, todo, other}},
+		{model.ShareFilter{UserID: 2}, []*model.Share{project, todo}},
+		{model.ShareFilter{ResourceType: model.ResourceProject, ResourceID: 10}, []*model.Share{project, other}},
+		{model.ShareFilter{ResourceType: model.ResourceTodo, ResourceID: 10}, nil},
+	} {
+		shares, err := repo.GetShares(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("GetShares: %v", err)
+		}
+		if len(shares) != len(tt.want) {
+			t.Errorf("GetShares(%+v) returned %d shares, want %d", tt.filter, len(shares), len(tt.want))
+			continue
+		}
+		for i := range shares {
+			if shares[i].ID != tt.want[i].ID {
+				t.Errorf("GetShares(%+v)[%d] = %+v, want %+v", tt.filter, i, shares[i], tt.want[i])
+			}
+		}
+	}
+
+	changed := project.Clone()
+	changed.Role, changed.Accepted, changed.UserID = model.RoleViewer, true, 3
+	if err := repo.UpdateShare(ctx, changed); err != nil {
+		t.Fatalf("UpdateShare: %v", err)
+	}
+	got, err = repo.GetShare(ctx, project.ID)
+	if err != nil {
+		t.Fatalf("GetShare: %v", err)
+	}
+	if got.Role != model.RoleViewer || !got.Accepted || got.UserID != 2 {
+		t.Errorf("UpdateShare stored %+v, want the new role and acceptance only", got)
+	}
+
+	if err := repo.DeleteShare(ctx, project.ID); err != nil {
+		t.Fatalf("DeleteShare: %v", err)
+	}
+	if err := repo.DeleteShare(ctx, project.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteShare of a deleted share = %v, want ErrNotFound", err)
+	}
+	if err := repo.UpdateShare(ctx, changed); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateShare of a deleted share = %v, want ErrNotFound", err)
+	}
+}
+
+func testComments(t *testing.T, repo repository.Repository) {
+	todo := create(t, repo, &model.Todo{Title: "plan trip"})
+
+	comment := model.Comment{AuthorID: 2, Text: "book the train", CreatedAt: base}
+	changed, err := repo.Modify(context.Background(), todo.ID, func(todo *model.Todo) error {
+		comment.ID = todo.NextCommentID()
+		todo.Comments = append(todo.Comments, comment)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	if len(changed.Comments) != 1 {
+		t.Fatalf("Modify returned comments %+v", changed.Comments)
+	}
+	stored := get(t, repo, todo.ID)
+	if len(stored.Comments) != 1 || stored.Comments[0].Text != comment.Text || stored.Comments[0].AuthorID != 2 ||
+		!stored.Comments[0].CreatedAt.Equal(base) {
+		t.Errorf("stored comments %+v, want %+v", stored.Comments, comment)
+	}
+
+	// the ID of a removed comment is not given to the next one
+	_, err = repo.Modify(context.Background(), todo.ID, func(todo *model.Todo) error {
+		todo.Comments = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Modify: %v", err)
+	}
+	stored = get(t, repo, todo.ID)
+	if id := stored.NextCommentID(); id != comment.ID+1 {
+		t.Errorf("NextCommentID after removing comment %d = %d, want %d", comment.ID, id, comment.ID+1)
+	}
+}
+
+func testConcurrency(t *testing.T, repo repository.Repository) {
+	const workers = 8
+	const perWorker = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker*3)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				todo := &model.Todo{Title: fmt.Sprintf("worker %d todo %d", w, i), Status: model.StatusOpen}
+				if err := repo.Create(context.Background(), todo); err != nil {
+					errs <- err
+					continue
+				}
+				todo.SetStatus(model.StatusDone, base)
+				if err := repo.Update(context.Background(), todo); err != nil {
+					errs <- err
+				}
+				if _, err := repo.GetAll(context.Background(), model.Filter{Text: "worker"}, model.SortByKeys(model.SortKey{SortBy: model.SortByTitle}), model.Pagination{Page: 1, Limit: 5}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	todos := all(t, repo, model.Filter{}, model.Sorting{}, model.Pagination{})
+	if len(todos) != workers*perWorker {
+		t.Fatalf("got %d todos, want %d", len(todos), workers*perWorker)
+	}
+	seen := make(map[int]bool)
+	for _, todo := range todos {
+		if seen[todo.ID] {
+			t.Errorf("id %d was assigned twice", todo.ID)
+		}
+		seen[todo.ID] = true
+		if todo.Status != model.StatusDone {
+			t.Errorf("todo %d has status %q, want %q", todo.ID, todo.Status, model.StatusDone)
+		}
+	}
+}
+
+func testCancellation(t *testing.T, repo repository.Repository) {
+	todo := create(t, repo, &model.Todo{Title: "untouched"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]error{
+		"Create": repo.Create(ctx, &model.Todo{Title: "cancelled", Status: model.StatusOpen}),
+		"Update": repo.Update(ctx, &model.Todo{ID: todo.ID, Title: "cancelled", Status: model.StatusOpen}),
+		"Delete": repo.Delete(ctx, todo.ID, 0),
+	}
+	_, calls["Get"] = repo.Get(ctx, todo.ID)
+	_, calls["GetAll"] = repo.GetAll(ctx, model.Filter{}, model.Sorting{}, model.Pagination{})
+
+	for name, err := range calls {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s with a cancelled context returned %v, want %v", name, err, context.Canceled)
+		}
+	}
+
+	todos := all(t, repo, model.Filter{}, model.Sorting{}, model.Pagination{})
+	if len(todos) != 1 || todos[0].Title != "untouched" {
+		t.Errorf("cancelled calls changed the todos: %+v", todos)
+	}
+}
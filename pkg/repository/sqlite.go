@@ -0,0 +1,1271 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+var _ Repository = (*SQLiteRepository)(nil)
+
+// sqliteMigrations are applied in order, each exactly once. Append new migrations, never edit applied ones.
+var sqliteMigrations = []string{
+	`CREATE TABLE todos (
+		id           INTEGER PRIMARY KEY,
+		title        TEXT    NOT NULL DEFAULT '',
+		description  TEXT    NOT NULL DEFAULT '',
+		due_date     INTEGER,
+		all_day      INTEGER NOT NULL DEFAULT 0,
+		time_zone    TEXT    NOT NULL DEFAULT '',
+		status       TEXT    NOT NULL DEFAULT 'open',
+		completed_at INTEGER
+	);
+	CREATE INDEX todos_due_date ON todos (due_date);
+	CREATE INDEX todos_title ON todos (title COLLATE NOCASE);`,
+	`ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+	`CREATE TABLE todo_tags (
+		todo_id INTEGER NOT NULL,
+		tag     TEXT    NOT NULL,
+		PRIMARY KEY (todo_id, tag)
+	);
+	CREATE INDEX todo_tags_tag ON todo_tags (tag);
+	CREATE TRIGGER todos_delete_tags AFTER DELETE ON todos BEGIN
+		DELETE FROM todo_tags WHERE todo_id = OLD.id;
+	END;`,
+	`CREATE TABLE projects (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT    NOT NULL,
+		description TEXT    NOT NULL DEFAULT '',
+		archived    INTEGER NOT NULL DEFAULT 0,
+		version     INTEGER NOT NULL DEFAULT 1
+	);
+	ALTER TABLE todos ADD COLUMN project_id INTEGER;
+	ALTER TABLE todos ADD COLUMN archived INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX todos_project_id ON todos (project_id);`,
+	`ALTER TABLE todos ADD COLUMN parent_id INTEGER;
+	ALTER TABLE todos ADD COLUMN checklist TEXT;
+	CREATE INDEX todos_parent_id ON todos (parent_id);`,
+	`ALTER TABLE todos ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE todos ADD COLUMN priority INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE users (
+		id            INTEGER PRIMARY KEY,
+		name          TEXT    NOT NULL UNIQUE,
+		password_hash TEXT    NOT NULL,
+		created_at    INTEGER NOT NULL
+	);
+	ALTER TABLE todos ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE projects ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX todos_owner_id ON todos (owner_id);
+	CREATE INDEX projects_owner_id ON projects (owner_id);`,
+	`CREATE TABLE api_keys (
+		id           INTEGER PRIMARY KEY,
+		owner_id     INTEGER NOT NULL,
+		name         TEXT    NOT NULL,
+		prefix       TEXT    NOT NULL,
+		hash         TEXT    NOT NULL UNIQUE,
+		scope        TEXT    NOT NULL,
+		created_at   INTEGER NOT NULL,
+		last_used_at INTEGER
+	);
+	CREATE INDEX api_keys_owner_id ON api_keys (owner_id);`,
+	`CREATE TABLE shares (
+		id            INTEGER PRIMARY KEY,
+		resource_type TEXT    NOT NULL,
+		resource_id   INTEGER NOT NULL,
+		user_id       INTEGER NOT NULL,
+		role          TEXT    NOT NULL,
+		accepted      INTEGER NOT NULL DEFAULT 0,
+		created_at    INTEGER NOT NULL,
+		UNIQUE (resource_type, resource_id, user_id)
+	);
+	CREATE INDEX shares_user_id ON shares (user_id);
+	ALTER TABLE todos ADD COLUMN comments TEXT;`,
+	`ALTER TABLE todos ADD COLUMN last_comment_id INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE todos ADD COLUMN last_checklist_id INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// todoColumns are the columns of a todo. The checklist and the comments are stored as JSON arrays.
+const todoColumns = "id, title, description, due_date, all_day, time_zone, status, completed_at, version, project_id, archived, parent_id, checklist, recurrence, priority, owner_id, comments, last_comment_id, last_checklist_id"
+
+const projectColumns = "id, name, description, archived, version, owner_id"
+
+const userColumns = "id, name, password_hash, created_at"
+
+const apiKeyColumns = "id, owner_id, name, prefix, hash, scope, created_at, last_used_at"
+
+const shareColumns = "id, resource_type, resource_id, user_id, role, accepted, created_at"
+
+// todoSelect selects the columns scanned by scanTodo from todos, including the tags
+const todoSelect = "SELECT " + todoColumns + ", (SELECT group_concat(tag, ',') FROM todo_tags WHERE todo_id = todos.id) FROM todos"
+
+func NewSQLiteRepository(db *sql.DB) (*SQLiteRepository, error) {
+	// SQLite allows a single writer, serialize access instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec("PRAGMA journal_mode = WAL; PRAGMA busy_timeout = 5000;"); err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
+	return &SQLiteRepository{db: db}, nil
+}
+
+// migrate brings the schema up to date
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create a new todo
+func (r *SQLiteRepository) Create(ctx context.Context, todo *model.Todo) error {
+	if err := validate(todo); err != nil {
+		return err
+	}
+
+	assignOwner(ctx, &todo.OwnerID)
+	todo.ID = newID()
+	todo.Version = 1
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	if err := checkProject(ctx, tx, todo, true); err != nil {
+		return err
+	}
+	checklist, err := jsonArray(todo.Checklist)
+	if err != nil {
+		return err
+	}
+	comments, err := jsonArray(todo.Comments)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO todos ("+todoColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		todo.ID, todo.Title, todo.Description, unixNano(todo.DueDate), todo.AllDay, todo.TimeZone, todo.Status, unixNano(todo.CompletedAt), todo.Version,
+		nullID(todo.ProjectID), todo.Archived, nullID(todo.ParentID), checklist, todo.Recurrence, todo.Priority, todo.OwnerID, comments, todo.LastCommentID, todo.LastChecklistID)
+	if err != nil {
+		return sqliteError(err)
+	}
+	if err := writeTags(ctx, tx, todo); err != nil {
+		return err
+	}
+	return sqliteError(tx.Commit())
+}
+
+// Get a todo by id
+func (r *SQLiteRepository) Get(ctx context.Context, id int) (*model.Todo, error) {
+	owner, args := sqliteOwner(ctx, id)
+	row := r.db.QueryRowContext(ctx, todoSelect+" WHERE id = ?"+owner, args...)
+	todo, err := scanTodo(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	return todo, nil
+}
+
+// Get all todos
+func (r *SQLiteRepository) GetAll(ctx context.Context, filter model.Filter, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error) {
+	where, args, err := sqliteWhere(ctx, filter, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	sorting.Now = sorting.Time()
+	if pagination.After != nil {
+		after, afterArgs := sqliteAfter(sorting, pagination.After)
+		where += " AND " + after
+		args = append(args, afterArgs...)
+	}
+
+	query := todoSelect + where + sqliteOrderBy(sorting)
+	if pagination.After != nil && pagination.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, pagination.Limit)
+	} else if pagination.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, pagination.Limit, offset(pagination))
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	var todos []*model.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		todos = append(todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return todos, nil
+}
+
+// Count todos
+func (r *SQLiteRepository) Count(ctx context.Context, filter model.Filter) (int, error) {
+	where, args, err := sqliteWhere(ctx, filter, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM todos"+where, args...).Scan(&count); err != nil {
+		return 0, sqliteError(err)
+	}
+	return count, nil
+}
+
+// sqliteWhere builds the WHERE clause for a filter in the scope of ctx
+func sqliteWhere(ctx context.Context, filter model.Filter, now time.Time) (string, []interface{}, error) {
+	condition, args, err := sqliteExpr(filter.Expr(), now)
+	if err != nil {
+		return "", nil, err
+	}
+	owner, args := sqliteOwner(ctx, args...)
+	return " WHERE " + condition + owner, args, nil
+}
+
+// sqliteOwner returns the condition appended to a WHERE clause that limits it to the owner of ctx, and
+// args followed by the arguments of the condition. Without an owner the condition is empty.
+func sqliteOwner(ctx context.Context, args ...interface{}) (string, []interface{}) {
+	owner := Owner(ctx)
+	if owner == 0 {
+		return "", args
+	}
+	return " AND owner_id = ?", append(args, owner)
+}
+
+// sqliteExpr translates a filter expression into an SQL condition
+func sqliteExpr(expr model.Expr, now time.Time) (string, []interface{}, error) {
+	switch expr := expr.(type) {
+	case model.And:
+		return sqliteJoin(expr, " AND ", "1", now)
+	case model.Or:
+		return sqliteJoin(expr, " OR ", "0", now)
+	case model.Not:
+		condition, args, err := sqliteExpr(expr.Expr, now)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT " + condition, args, nil
+	case model.Condition:
+		return sqliteCondition(expr, now)
+	default:
+		return "", nil, wrap(ErrValidation, fmt.Errorf("unsupported expression %T", expr))
+	}
+}
+
+// sqliteJoin joins the conditions of exprs with op, or returns empty without any
+func sqliteJoin(exprs []model.Expr, op string, empty string, now time.Time) (string, []interface{}, error) {
+	if len(exprs) == 0 {
+		return empty, nil, nil
+	}
+
+	conditions := make([]string, len(exprs))
+	var args []interface{}
+	for i, expr := range exprs {
+		condition, exprArgs, err := sqliteExpr(expr, now)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions[i] = condition
+		args = append(args, exprArgs...)
+	}
+	return "(" + strings.Join(conditions, op) + ")", args, nil
+}
+
+// sqliteOps are the SQL operators of the date comparisons
+var sqliteOps = map[model.Op]string{
+	model.OpLess:           "<",
+	model.OpLessOrEqual:    "<=",
+	model.OpGreater:        ">",
+	model.OpGreaterOrEqual: ">=",
+}
+
+// sqliteCondition translates a condition. Conditions are never NULL, so that NOT matches everything else.
+func sqliteCondition(c model.Condition, now time.Time) (string, []interface{}, error) {
+	switch c.Field {
+	case model.FieldText, model.FieldTitle, model.FieldDescription:
+		if c.Op != model.OpContains {
+			break
+		}
+		pattern := "%" + escapeLike(c.Value) + "%"
+		switch c.Field {
+		case model.FieldTitle:
+			return `title LIKE ? ESCAPE '\'`, []interface{}{pattern}, nil
+		case model.FieldDescription:
+			return `description LIKE ? ESCAPE '\'`, []interface{}{pattern}, nil
+		}
+		return `(title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')`, []interface{}{pattern, pattern}, nil
+	case model.FieldStatus:
+		if c.Op != model.OpEqual {
+			break
+		}
+		return "status = ?", []interface{}{c.Value}, nil
+	case model.FieldDue, model.FieldCompleted:
+		column := "due_date"
+		if c.Field == model.FieldCompleted {
+			column = "completed_at"
+		}
+		if c.Op == model.OpExists {
+			return column + " IS NOT NULL", nil, nil
+		}
+		if op, ok := sqliteOps[c.Op]; ok {
+			return "(" + column + " IS NOT NULL AND " + column + " " + op + " ?)", []interface{}{c.Time.UnixNano()}, nil
+		}
+	case model.FieldTag:
+		if c.Op != model.OpEqual {
+			break
+		}
+		return "EXISTS (SELECT 1 FROM todo_tags WHERE todo_id = todos.id AND tag = ?)", []interface{}{c.Value}, nil
+	case model.FieldProject:
+		if c.Op != model.OpEqual {
+			break
+		}
+		id, err := conditionID(c)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(project_id IS NOT NULL AND project_id = ?)", []interface{}{id}, nil
+	case model.FieldParent:
+		if c.Op != model.OpEqual {
+			break
+		}
+		id, err := conditionID(c)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(parent_id IS NOT NULL AND parent_id = ?)", []interface{}{id}, nil
+	case model.FieldArchived:
+		return "archived = 1", nil, nil
+	case model.FieldOverdue:
+		return "(due_date IS NOT NULL AND status NOT IN (?, ?) AND ((all_day = 0 AND due_date < ?) OR (all_day = 1 AND due_date < ?)))",
+			[]interface{}{model.StatusDone, model.StatusCancelled, now.UnixNano(), now.Add(-24 * time.Hour).UnixNano()}, nil
+	}
+	return "", nil, wrap(ErrValidation, fmt.Errorf("unsupported condition %s %s", c.Field, c.Op))
+}
+
+// sqliteSortColumn returns the column expression of a SortBy. The smart score is computed at now.
+func sqliteSortColumn(sortBy model.SortBy, now time.Time) string {
+	switch sortBy {
+	case model.SortByTitle:
+		return "title COLLATE NOCASE"
+	case model.SortByDescription:
+		return "description COLLATE NOCASE"
+	case model.SortByDueDate:
+		return "due_date"
+	case model.SortByStatus:
+		return "status"
+	case model.SortByCompletedAt:
+		return "completed_at"
+	case model.SortByPriority:
+		return "priority"
+	case model.SortBySmart:
+		return sqliteSmartRank(now)
+	case model.SortByProject:
+		return "project_id"
+	case model.SortByParent:
+		return "parent_id"
+	default:
+		return "id"
+	}
+}
+
+// sqliteSmartRank computes the negated model.Todo.SmartScore, so that the todo to do next sorts first.
+// Days are only divided as positive numbers, where the integer division of SQLite rounds down like Go.
+func sqliteSmartRank(now time.Time) string {
+	const day = int64(24 * time.Hour)
+	deadline := fmt.Sprintf("(due_date + CASE WHEN all_day THEN %d ELSE 0 END)", day)
+	base := fmt.Sprintf("1 + priority * %d", model.SmartPriorityWeight)
+	return fmt.Sprintf(`-(CASE
+		WHEN status IN ('%s', '%s') THEN 0
+		WHEN due_date IS NULL THEN %s
+		WHEN %s < %d THEN %s + %d + MIN((%d - %s) / %d, %d)
+		ELSE %s + MAX(0, %d - %d * ((%s - %d) / %d))
+	END)`,
+		model.StatusDone, model.StatusCancelled,
+		base,
+		deadline, now.UnixNano(), base, model.SmartOverdueScore, now.UnixNano(), deadline, day, model.SmartMaxOverdueDays,
+		base, model.SmartDueScore, model.SmartDueDecay, deadline, now.UnixNano(), day)
+}
+
+// sqliteOrderBy orders todos equal in every key by id
+func sqliteOrderBy(sorting model.Sorting) string {
+	var terms []string
+	for _, key := range sorting.Keys {
+		direction := " ASC"
+		if key.SortType == model.SortDescending {
+			direction = " DESC"
+		}
+		terms = append(terms, sqliteSortColumn(key.SortBy, sorting.Now)+direction)
+		if key.SortBy == model.SortByID {
+			return " ORDER BY " + strings.Join(terms, ", ")
+		}
+	}
+	return " ORDER BY " + strings.Join(append(terms, "id ASC"), ", ")
+}
+
+// sqliteSortValue returns the value of a todo in the column expression of sortBy
+func sqliteSortValue(todo *model.Todo, sortBy model.SortBy, now time.Time) interface{} {
+	switch sortBy {
+	case model.SortByTitle:
+		return todo.Title
+	case model.SortByDescription:
+		return todo.Description
+	case model.SortByDueDate:
+		return unixNano(todo.DueDate)
+	case model.SortByStatus:
+		return string(todo.Status)
+	case model.SortByCompletedAt:
+		return unixNano(todo.CompletedAt)
+	case model.SortByPriority:
+		return todo.Priority
+	case model.SortBySmart:
+		return -todo.SmartScore(now)
+	case model.SortByProject:
+		return nullID(todo.ProjectID)
+	case model.SortByParent:
+		return nullID(todo.ParentID)
+	default:
+		return todo.ID
+	}
+}
+
+// sqliteAfter builds the condition matching the todos sorted behind after: those behind it in the first
+// key, or equal in the first key and behind it in the second and so on. NULL sorts first, as in ORDER BY.
+func sqliteAfter(sorting model.Sorting, after *model.Todo) (string, []interface{}) {
+	keys := sorting.Keys
+	if len(keys) == 0 || keys[len(keys)-1].SortBy != model.SortByID {
+		keys = append(keys[:len(keys):len(keys)], model.SortKey{SortBy: model.SortByID})
+	}
+
+	var or []string
+	var args []interface{}
+	var equal []string
+	var equalArgs []interface{}
+	for _, key := range keys {
+		column := sqliteSortColumn(key.SortBy, sorting.Now)
+		value := sqliteSortValue(after, key.SortBy, sorting.Now)
+
+		var behind string
+		var behindArgs []interface{}
+		switch {
+		case key.SortType == model.SortAscending && value == nil:
+			behind = column + " IS NOT NULL"
+		case key.SortType == model.SortAscending:
+			behind, behindArgs = column+" > ?", []interface{}{value}
+		case value != nil:
+			behind, behindArgs = "("+column+" < ? OR "+column+" IS NULL)", []interface{}{value}
+		}
+		if behind != "" {
+			or = append(or, "("+strings.Join(append(equal[:len(equal):len(equal)], behind), " AND ")+")")
+			args = append(append(args, equalArgs...), behindArgs...)
+		}
+
+		if key.SortBy == model.SortByID {
+			break
+		}
+		if value == nil {
+			equal = append(equal, column+" IS NULL")
+		} else {
+			equal = append(equal, column+" = ?")
+			equalArgs = append(equalArgs, value)
+		}
+	}
+
+	if len(or) == 0 {
+		return "0", nil
+	}
+	return "(" + strings.Join(or, " OR ") + ")", args
+}
+
+// escapeLike escapes the LIKE wildcards so s is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Update a todo
+func (r *SQLiteRepository) Update(ctx context.Context, todo *model.Todo) error {
+	if err := validate(todo); err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	version := todo.Version
+	if err := updateTodo(ctx, tx, todo); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		todo.Version = version
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// updateTodo stores todo if todo.Version is zero or the stored version and sets the new version on it.
+// The owner of the todo is kept.
+func updateTodo(ctx context.Context, db querier, todo *model.Todo) error {
+	var projectID sql.NullInt64
+	owner, args := sqliteOwner(ctx, todo.ID)
+	err := db.QueryRowContext(ctx, "SELECT project_id, owner_id FROM todos WHERE id = ?"+owner, args...).Scan(&projectID, &todo.OwnerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return sqliteError(err)
+	}
+	if err := checkProject(ctx, db, todo, int(projectID.Int64) != todo.ProjectID); err != nil {
+		return err
+	}
+
+	checklist, err := jsonArray(todo.Checklist)
+	if err != nil {
+		return err
+	}
+	comments, err := jsonArray(todo.Comments)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	err = db.QueryRowContext(ctx, "UPDATE todos SET title = ?, description = ?, due_date = ?, all_day = ?, time_zone = ?, status = ?, completed_at = ?, project_id = ?, archived = ?, parent_id = ?, checklist = ?, recurrence = ?, priority = ?, comments = ?, last_comment_id = ?, last_checklist_id = ?, version = version + 1 WHERE id = ? AND (? = 0 OR version = ?) RETURNING version",
+		todo.Title, todo.Description, unixNano(todo.DueDate), todo.AllDay, todo.TimeZone, todo.Status, unixNano(todo.CompletedAt), nullID(todo.ProjectID), todo.Archived,
+		nullID(todo.ParentID), checklist, todo.Recurrence, todo.Priority, comments, todo.LastCommentID, todo.LastChecklistID, todo.ID, todo.Version, todo.Version).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundOrPrecondition(ctx, db, todo.ID)
+	}
+	if err != nil {
+		return sqliteError(err)
+	}
+	if err := writeTags(ctx, db, todo); err != nil {
+		return err
+	}
+
+	todo.Version = version
+	return nil
+}
+
+// checkProject looks up the project of a todo for placeTodo
+func checkProject(ctx context.Context, db querier, todo *model.Todo, moved bool) error {
+	var project *model.Project
+	if todo.ProjectID != 0 {
+		var err error
+		owner, args := sqliteOwner(ctx, todo.ProjectID)
+		project, err = scanProject(db.QueryRowContext(ctx, "SELECT "+projectColumns+" FROM projects WHERE id = ?"+owner, args...))
+		if errors.Is(err, sql.ErrNoRows) {
+			project = nil
+		} else if err != nil {
+			return sqliteError(err)
+		}
+	}
+	return placeTodo(todo, project, moved)
+}
+
+// writeTags replaces the stored tags of a todo
+func writeTags(ctx context.Context, db querier, todo *model.Todo) error {
+	if _, err := db.ExecContext(ctx, "DELETE FROM todo_tags WHERE todo_id = ?", todo.ID); err != nil {
+		return sqliteError(err)
+	}
+	for _, tag := range todo.Tags {
+		if _, err := db.ExecContext(ctx, "INSERT INTO todo_tags (todo_id, tag) VALUES (?, ?)", todo.ID, tag); err != nil {
+			return sqliteError(err)
+		}
+	}
+	return nil
+}
+
+// notFoundOrPrecondition tells why a conditional write did not match any row
+func notFoundOrPrecondition(ctx context.Context, db querier, id int) error {
+	var exists bool
+	owner, args := sqliteOwner(ctx, id)
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM todos WHERE id = ?"+owner+")", args...).Scan(&exists); err != nil {
+		return sqliteError(err)
+	}
+	if exists {
+		return ErrPreconditionFailed
+	}
+	return ErrNotFound
+}
+
+// Modify a todo
+func (r *SQLiteRepository) Modify(ctx context.Context, id int, change func(todo *model.Todo) error) (*model.Todo, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	owner, args := sqliteOwner(ctx, id)
+	todo, err := scanTodo(tx.QueryRowContext(ctx, todoSelect+" WHERE id = ?"+owner, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	version := todo.Version
+	if err := change(todo); err != nil {
+		return nil, err
+	}
+	todo.ID = id
+	todo.Version = version
+	if err := validate(todo); err != nil {
+		return nil, err
+	}
+
+	if err := updateTodo(ctx, tx, todo); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return todo, nil
+}
+
+// Delete a todo
+func (r *SQLiteRepository) Delete(ctx context.Context, id int, version int64) error {
+	owner, args := sqliteOwner(ctx, id, version, version)
+	result, err := r.db.ExecContext(ctx, "DELETE FROM todos WHERE id = ? AND (? = 0 OR version = ?)"+owner, args...)
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n == 0 {
+		return notFoundOrPrecondition(ctx, r.db, id)
+	}
+	return nil
+}
+
+// Tags counts the tags with the index of todo_tags
+func (r *SQLiteRepository) Tags(ctx context.Context) ([]model.TagCount, error) {
+	owned, args := sqliteOwnedTodos(ctx)
+	rows, err := r.db.QueryContext(ctx, "SELECT tag, COUNT(*) FROM todo_tags WHERE "+owned+" GROUP BY tag ORDER BY tag", args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	tags := []model.TagCount{}
+	for rows.Next() {
+		var tag model.TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, sqliteError(err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return tags, nil
+}
+
+// RenameTags changes the tags of all todos in one transaction
+func (r *SQLiteRepository) RenameTags(ctx context.Context, from []string, to string) (int, error) {
+	if len(from) == 0 {
+		return 0, nil
+	}
+	owned, ownedArgs := sqliteOwnedTodos(ctx)
+	in := "tag IN (?" + strings.Repeat(", ?", len(from)-1) + ") AND " + owned
+	fromArgs := make([]interface{}, len(from))
+	for i, tag := range from {
+		fromArgs[i] = tag
+	}
+	fromArgs = append(fromArgs, ownedArgs...)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE todos SET version = version + 1 WHERE id IN (SELECT todo_id FROM todo_tags WHERE "+in+")", fromArgs...)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+
+	args := append([]interface{}{to}, fromArgs...)
+	if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO todo_tags (todo_id, tag) SELECT DISTINCT todo_id, ? FROM todo_tags WHERE "+in, args...); err != nil {
+		return 0, sqliteError(err)
+	}
+	args = append(fromArgs[:len(fromArgs):len(fromArgs)], to)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM todo_tags WHERE "+in+" AND tag != ?", args...); err != nil {
+		return 0, sqliteError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, sqliteError(err)
+	}
+	return int(n), nil
+}
+
+// AssignOwnerless updates the todos and projects without owner in one transaction
+func (r *SQLiteRepository) AssignOwnerless(ctx context.Context, ownerID int) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for _, table := range []string{"todos", "projects"} {
+		result, err := tx.ExecContext(ctx, "UPDATE "+table+" SET owner_id = ?, version = version + 1 WHERE owner_id = 0", ownerID)
+		if err != nil {
+			return 0, sqliteError(err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, sqliteError(err)
+		}
+		changed += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, sqliteError(err)
+	}
+	return changed, nil
+}
+
+// sqliteOwnedTodos returns the condition on todo_tags limiting it to the todos of the owner of ctx and its arguments
+func sqliteOwnedTodos(ctx context.Context) (string, []interface{}) {
+	owner, args := sqliteOwner(ctx)
+	if owner == "" {
+		return "1", nil
+	}
+	return "todo_id IN (SELECT id FROM todos WHERE 1" + owner + ")", args
+}
+
+// CreateProject creates a new project
+func (r *SQLiteRepository) CreateProject(ctx context.Context, project *model.Project) error {
+	if err := validateProject(project); err != nil {
+		return err
+	}
+
+	assignOwner(ctx, &project.OwnerID)
+	project.ID = newID()
+	project.Version = 1
+	_, err := r.db.ExecContext(ctx, "INSERT INTO projects ("+projectColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		project.ID, project.Name, project.Description, project.Archived, project.Version, project.OwnerID)
+	return sqliteError(err)
+}
+
+// GetProject gets a project by id
+func (r *SQLiteRepository) GetProject(ctx context.Context, id int) (*model.Project, error) {
+	owner, args := sqliteOwner(ctx, id)
+	project, err := scanProject(r.db.QueryRowContext(ctx, "SELECT "+projectColumns+" FROM projects WHERE id = ?"+owner, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	return project, nil
+}
+
+// GetProjects gets all projects
+func (r *SQLiteRepository) GetProjects(ctx context.Context) ([]*model.Project, error) {
+	owner, args := sqliteOwner(ctx)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+projectColumns+" FROM projects WHERE 1"+owner+" ORDER BY name COLLATE NOCASE, id", args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	projects := []*model.Project{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		projects = append(projects, project)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return projects, nil
+}
+
+// ModifyProject changes the project and archives or unarchives its todos in one transaction
+func (r *SQLiteRepository) ModifyProject(ctx context.Context, id int, change func(project *model.Project) error) (*model.Project, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	owner, args := sqliteOwner(ctx, id)
+	project, err := scanProject(tx.QueryRowContext(ctx, "SELECT "+projectColumns+" FROM projects WHERE id = ?"+owner, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	version, archived, ownerID := project.Version, project.Archived, project.OwnerID
+	if err := change(project); err != nil {
+		return nil, err
+	}
+	project.ID = id
+	project.OwnerID = ownerID
+	project.Version = version + 1
+	if err := validateProject(project); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE projects SET name = ?, description = ?, archived = ?, version = ? WHERE id = ?",
+		project.Name, project.Description, project.Archived, project.Version, id)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	if project.Archived != archived {
+		_, err := tx.ExecContext(ctx, "UPDATE todos SET archived = ?, version = version + 1 WHERE project_id = ?", project.Archived, id)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return project, nil
+}
+
+// DeleteProject deletes the project and its todos in one transaction
+func (r *SQLiteRepository) DeleteProject(ctx context.Context, id int, version int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer tx.Rollback()
+
+	owner, args := sqliteOwner(ctx, id, version, version)
+	result, err := tx.ExecContext(ctx, "DELETE FROM projects WHERE id = ? AND (? = 0 OR version = ?)"+owner, args...)
+	if err != nil {
+		return sqliteError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n == 0 {
+		var exists bool
+		owner, args := sqliteOwner(ctx, id)
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM projects WHERE id = ?"+owner+")", args...).Scan(&exists); err != nil {
+			return sqliteError(err)
+		}
+		if exists {
+			return ErrPreconditionFailed
+		}
+		return ErrProjectNotFound
+	}
+
+	// the tags of the todos are deleted by the todos_delete_tags trigger
+	if _, err := tx.ExecContext(ctx, "DELETE FROM todos WHERE project_id = ?", id); err != nil {
+		return sqliteError(err)
+	}
+	return sqliteError(tx.Commit())
+}
+
+// CreateUser creates a new user. The unique name column rejects taken names.
+func (r *SQLiteRepository) CreateUser(ctx context.Context, user *model.User) error {
+	if err := validateUser(user); err != nil {
+		return err
+	}
+
+	user.ID = newID()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?)",
+		user.ID, user.Name, user.PasswordHash, user.CreatedAt.UnixNano())
+	return sqliteError(err)
+}
+
+// GetUser gets a user by id
+func (r *SQLiteRepository) GetUser(ctx context.Context, id int) (*model.User, error) {
+	return r.getUser(ctx, "id", id)
+}
+
+// GetUserByName gets a user by name
+func (r *SQLiteRepository) GetUserByName(ctx context.Context, name string) (*model.User, error) {
+	return r.getUser(ctx, "name", model.NormalizeUserName(name))
+}
+
+// getUser gets the user with value in column
+func (r *SQLiteRepository) getUser(ctx context.Context, column string, value interface{}) (*model.User, error) {
+	var user model.User
+	var createdAt int64
+	err := r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE "+column+" = ?", value).
+		Scan(&user.ID, &user.Name, &user.PasswordHash, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	user.CreatedAt = time.Unix(0, createdAt).UTC()
+	return &user, nil
+}
+
+// CreateAPIKey creates a new API key. The unique hash column rejects duplicate keys.
+func (r *SQLiteRepository) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	if err := validateAPIKey(key); err != nil {
+		return err
+	}
+
+	assignOwner(ctx, &key.OwnerID)
+	key.ID = newID()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.OwnerID, key.Name, key.Prefix, key.Hash, key.Scope, key.CreatedAt.UnixNano(), unixNano(key.LastUsedAt))
+	return sqliteError(err)
+}
+
+// GetAPIKeys gets all API keys in the order they were created
+func (r *SQLiteRepository) GetAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	owner, args := sqliteOwner(ctx)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE 1"+owner+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	keys := []*model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash gets the API key with the given hash
+func (r *SQLiteRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ?", hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	return key, nil
+}
+
+// TouchAPIKey sets the time an API key was last used
+func (r *SQLiteRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	owner, args := sqliteOwner(ctx, usedAt.UnixNano(), id)
+	return r.changeAPIKey(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?"+owner, args...)
+}
+
+// DeleteAPIKey deletes an API key
+func (r *SQLiteRepository) DeleteAPIKey(ctx context.Context, id int) error {
+	owner, args := sqliteOwner(ctx, id)
+	return r.changeAPIKey(ctx, "DELETE FROM api_keys WHERE id = ?"+owner, args...)
+}
+
+// changeAPIKey executes a statement that changes a single API key
+func (r *SQLiteRepository) changeAPIKey(ctx context.Context, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return sqliteError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// CreateShare creates a new share. The unique resource and user columns reject sharing twice.
+func (r *SQLiteRepository) CreateShare(ctx context.Context, share *model.Share) error {
+	if err := validateShare(share); err != nil {
+		return err
+	}
+
+	share.ID = newID()
+	_, err := r.db.ExecContext(ctx, "INSERT INTO shares ("+shareColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		share.ID, share.ResourceType, share.ResourceID, share.UserID, share.Role, share.Accepted, share.CreatedAt.UnixNano())
+	return sqliteError(err)
+}
+
+// GetShare gets a share by id
+func (r *SQLiteRepository) GetShare(ctx context.Context, id int) (*model.Share, error) {
+	share, err := scanShare(r.db.QueryRowContext(ctx, "SELECT "+shareColumns+" FROM shares WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	return share, nil
+}
+
+// GetShares gets the shares matching filter in the order they were created
+func (r *SQLiteRepository) GetShares(ctx context.Context, filter model.ShareFilter) ([]*model.Share, error) {
+	query := "SELECT " + shareColumns + " FROM shares WHERE 1"
+	var args []interface{}
+	if filter.ResourceType != "" {
+		query += " AND resource_type = ?"
+		args = append(args, filter.ResourceType)
+	}
+	if filter.ResourceID != 0 {
+		query += " AND resource_id = ?"
+		args = append(args, filter.ResourceID)
+	}
+	if filter.UserID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, filter.UserID)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+
+	shares := []*model.Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, sqliteError(err)
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, sqliteError(err)
+	}
+	return shares, nil
+}
+
+// UpdateShare stores the role and acceptance of a share
+func (r *SQLiteRepository) UpdateShare(ctx context.Context, share *model.Share) error {
+	if err := validateShare(share); err != nil {
+		return err
+	}
+	return r.changeShare(ctx, "UPDATE shares SET role = ?, accepted = ? WHERE id = ?", share.Role, share.Accepted, share.ID)
+}
+
+// DeleteShare deletes a share
+func (r *SQLiteRepository) DeleteShare(ctx context.Context, id int) error {
+	return r.changeShare(ctx, "DELETE FROM shares WHERE id = ?", id)
+}
+
+// changeShare executes a statement that changes a single share
+func (r *SQLiteRepository) changeShare(ctx context.Context, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return sqliteError(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return sqliteError(err)
+	}
+	if n == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) Shutdown(ctx context.Context) error {
+	return r.db.Close()
+}
+
+// sqliteError maps driver errors to the repository errors
+func sqliteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrConnDone) {
+		return wrap(ErrUnavailable, err)
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// the low byte is the primary result code of extended codes
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CONSTRAINT:
+			return wrap(ErrConflict, err)
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_FULL, sqlite3.SQLITE_IOERR, sqlite3.SQLITE_CANTOPEN:
+			return wrap(ErrUnavailable, err)
+		}
+	}
+
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTodo(row scanner) (*model.Todo, error) {
+	var todo model.Todo
+	var dueDate, completedAt sql.NullInt64
+	var projectID, parentID sql.NullInt64
+	var checklist, comments, tags sql.NullString
+	err := row.Scan(&todo.ID, &todo.Title, &todo.Description, &dueDate, &todo.AllDay, &todo.TimeZone, &todo.Status, &completedAt, &todo.Version,
+		&projectID, &todo.Archived, &parentID, &checklist, &todo.Recurrence, &todo.Priority, &todo.OwnerID, &comments, &todo.LastCommentID, &todo.LastChecklistID, &tags)
+	if err != nil {
+		return nil, err
+	}
+	todo.DueDate = fromUnixNano(dueDate)
+	todo.CompletedAt = fromUnixNano(completedAt)
+	todo.ProjectID = int(projectID.Int64)
+	todo.ParentID = int(parentID.Int64)
+	if checklist.Valid {
+		if err := json.Unmarshal([]byte(checklist.String), &todo.Checklist); err != nil {
+			return nil, err
+		}
+	}
+	if comments.Valid {
+		if err := json.Unmarshal([]byte(comments.String), &todo.Comments); err != nil {
+			return nil, err
+		}
+	}
+	if tags.Valid {
+		todo.Tags = model.NormalizeTags(strings.Split(tags.String, ","))
+	}
+	return &todo, nil
+}
+
+func scanProject(row scanner) (*model.Project, error) {
+	var project model.Project
+	if err := row.Scan(&project.ID, &project.Name, &project.Description, &project.Archived, &project.Version, &project.OwnerID); err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func scanAPIKey(row scanner) (*model.APIKey, error) {
+	var key model.APIKey
+	var createdAt int64
+	var lastUsedAt sql.NullInt64
+	if err := row.Scan(&key.ID, &key.OwnerID, &key.Name, &key.Prefix, &key.Hash, &key.Scope, &createdAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	key.CreatedAt = time.Unix(0, createdAt).UTC()
+	key.LastUsedAt = fromUnixNano(lastUsedAt)
+	return &key, nil
+}
+
+func scanShare(row scanner) (*model.Share, error) {
+	var share model.Share
+	var createdAt int64
+	if err := row.Scan(&share.ID, &share.ResourceType, &share.ResourceID, &share.UserID, &share.Role, &share.Accepted, &createdAt); err != nil {
+		return nil, err
+	}
+	share.CreatedAt = time.Unix(0, createdAt).UTC()
+	return &share, nil
+}
+
+// jsonArray encodes a checklist or comments for their column, NULL without items
+func jsonArray[T any](items []T) (interface{}, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// nullID stores a missing project or parent as NULL
+func nullID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// Times are stored as UTC nanoseconds so that they sort and compare as integers
+func unixNano(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64).UTC()
+	return &t
+}
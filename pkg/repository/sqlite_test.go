@@ -0,0 +1,24 @@
+package repository_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository/repositorytest"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "todo.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := repository.NewSQLiteRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
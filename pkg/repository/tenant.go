@@ -0,0 +1,472 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+)
+
+// maxTenantLength is the longest tenant id, the length of a DNS label
+const maxTenantLength = 63
+
+type tenantKey struct{}
+
+// WithTenant directs the repository calls made with the returned context to the workspace tenant. The data of
+// each tenant is stored apart from the others. The empty tenant is the default workspace.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// Tenant returns the tenant the calls made with ctx are directed to, empty for the default workspace
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// ValidateTenant checks that a tenant id is a DNS label of lower case letters, digits and dashes, so that it
+// can name files, databases and subdomains
+func ValidateTenant(tenant string) error {
+	if tenant == "" || len(tenant) > maxTenantLength {
+		return fmt.Errorf("the tenant id must be 1 to %d characters long", maxTenantLength)
+	}
+	for i, r := range tenant {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' && i > 0 && i < len(tenant)-1) {
+			return fmt.Errorf("tenant id %q may only contain lower case letters, digits and inner dashes", tenant)
+		}
+	}
+	return nil
+}
+
+// TenantStore keeps a repository per tenant. The default workspace is not in the store.
+type TenantStore interface {
+	// Tenants lists the tenants in the store, ordered by id
+	Tenants(ctx context.Context) ([]string, error)
+	// Create creates the empty repository of a new tenant. It fails with ErrConflict when the tenant exists.
+	Create(ctx context.Context, tenant string) (Repository, error)
+	// Open opens the repository of a tenant. It fails with ErrTenantNotFound when the tenant does not exist.
+	Open(ctx context.Context, tenant string) (Repository, error)
+	// Delete deletes the data of a tenant, whose repository is shut down
+	Delete(ctx context.Context, tenant string) error
+}
+
+// TenantManager creates and deletes tenants
+type TenantManager interface {
+	// Tenants lists the tenants ordered by id, without the default workspace
+	Tenants(ctx context.Context) ([]string, error)
+	// CreateTenant creates a tenant. It fails with ErrConflict when the tenant exists.
+	CreateTenant(ctx context.Context, tenant string) error
+	// DeleteTenant deletes a tenant with all its data
+	DeleteTenant(ctx context.Context, tenant string) error
+}
+
+// TenantRepository directs every call to the repository of the tenant of the context, see WithTenant.
+// The repositories of tenants are opened on first use and kept open until they are deleted or shut down.
+type TenantRepository struct {
+	// mtx guards open, pending and closed. It is not held while a tenant is opened, created or deleted.
+	mtx   sync.RWMutex
+	base  Repository
+	store TenantStore
+	open  map[string]Repository
+	// pending holds the tenants being opened, created or deleted. Each tenant has one such operation at
+	// a time, and requests of a tenant being opened wait for its repository instead of opening another.
+	pending map[string]*tenantOperation
+	closed  bool
+}
+
+// tenantOperation opens, creates or deletes the repository of a tenant. done is closed when it is over.
+type tenantOperation struct {
+	done chan struct{}
+	// opening tells that the operation opens the repository, which its waiters then use as well
+	opening bool
+	repo    Repository
+	err     error
+}
+
+var (
+	_ Repository    = (*TenantRepository)(nil)
+	_ TenantManager = (*TenantRepository)(nil)
+)
+
+// NewTenantRepository returns a repository storing the default workspace in base and the other tenants in store
+func NewTenantRepository(base Repository, store TenantStore) *TenantRepository {
+	return &TenantRepository{base: base, store: store, open: map[string]Repository{}, pending: map[string]*tenantOperation{}}
+}
+
+// repository returns the repository of the tenant of ctx
+func (r *TenantRepository) repository(ctx context.Context) (Repository, error) {
+	tenant := Tenant(ctx)
+	if tenant == "" {
+		return r.base, nil
+	}
+
+	r.mtx.RLock()
+	repo, ok := r.open[tenant]
+	r.mtx.RUnlock()
+	if ok {
+		return repo, nil
+	}
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, ErrTenantNotFound
+	}
+
+	op, repo, err := r.begin(ctx, tenant, true)
+	if op == nil {
+		return repo, err
+	}
+	repo, err = r.store.Open(ctx, tenant)
+	return r.finish(tenant, op, repo, err)
+}
+
+// begin waits until no other operation is pending for tenant and starts one. When opening, it returns no
+// operation but the repository if the tenant is open already, or opened by another request meanwhile.
+func (r *TenantRepository) begin(ctx context.Context, tenant string, opening bool) (*tenantOperation, Repository, error) {
+	for {
+		r.mtx.Lock()
+		if r.closed {
+			r.mtx.Unlock()
+			return nil, nil, wrap(ErrUnavailable, errors.New("the repository is shut down"))
+		}
+		if repo, ok := r.open[tenant]; ok && opening {
+			r.mtx.Unlock()
+			return nil, repo, nil
+		}
+		other, ok := r.pending[tenant]
+		if !ok {
+			op := &tenantOperation{done: make(chan struct{}), opening: opening}
+			r.pending[tenant] = op
+			r.mtx.Unlock()
+			return op, nil, nil
+		}
+		r.mtx.Unlock()
+
+		select {
+		case <-other.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		// the repository another request opened is shared, unless that request gave up on its own
+		if opening && other.opening && !errors.Is(other.err, context.Canceled) && !errors.Is(other.err, context.DeadlineExceeded) {
+			return nil, other.repo, other.err
+		}
+	}
+}
+
+// finish ends the operation op of tenant, keeping repo open unless err is set. It returns repo and err.
+func (r *TenantRepository) finish(tenant string, op *tenantOperation, repo Repository, err error) (Repository, error) {
+	var stale Repository
+	r.mtx.Lock()
+	if err == nil && repo != nil {
+		if r.closed {
+			stale, repo, err = repo, nil, wrap(ErrUnavailable, errors.New("the repository is shut down"))
+		} else {
+			r.open[tenant] = repo
+		}
+	}
+	delete(r.pending, tenant)
+	op.repo, op.err = repo, err
+	close(op.done)
+	r.mtx.Unlock()
+
+	if stale != nil {
+		if err := stale.Shutdown(context.Background()); err != nil {
+			logrus.WithError(err).WithField("tenant", tenant).Warn("Could not shut down a tenant opened during shutdown")
+		}
+	}
+	return repo, err
+}
+
+// Tenants lists the tenants ordered by id
+func (r *TenantRepository) Tenants(ctx context.Context) ([]string, error) {
+	return r.store.Tenants(ctx)
+}
+
+// CreateTenant creates a tenant with an empty repository
+func (r *TenantRepository) CreateTenant(ctx context.Context, tenant string) error {
+	if err := ValidateTenant(tenant); err != nil {
+		return wrap(ErrValidation, err)
+	}
+
+	op, _, err := r.begin(ctx, tenant, false)
+	if err != nil {
+		return err
+	}
+	repo, err := r.store.Create(ctx, tenant)
+	_, err = r.finish(tenant, op, repo, err)
+	return err
+}
+
+// DeleteTenant shuts the repository of a tenant down and deletes its data. Requests of the tenant that
+// are still running fail.
+func (r *TenantRepository) DeleteTenant(ctx context.Context, tenant string) error {
+	if ValidateTenant(tenant) != nil {
+		return ErrTenantNotFound
+	}
+
+	op, _, err := r.begin(ctx, tenant, false)
+	if err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	repo, ok := r.open[tenant]
+	delete(r.open, tenant)
+	r.mtx.Unlock()
+
+	if ok {
+		err = repo.Shutdown(ctx)
+	}
+	if err == nil {
+		err = r.store.Delete(ctx, tenant)
+	}
+	_, err = r.finish(tenant, op, nil, err)
+	return err
+}
+
+// Shutdown shuts the repositories of every tenant down, the default workspace last. Tenants opened
+// meanwhile are shut down as their opening finishes.
+func (r *TenantRepository) Shutdown(ctx context.Context) error {
+	r.mtx.Lock()
+	r.closed = true
+	open := r.open
+	r.open = map[string]Repository{}
+	r.mtx.Unlock()
+
+	var errs []error
+	for tenant, repo := range open {
+		if err := repo.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant, err))
+		}
+	}
+	if err := r.base.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *TenantRepository) Create(ctx context.Context, todo *model.Todo) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Create(ctx, todo)
+}
+
+func (r *TenantRepository) Get(ctx context.Context, id int) (*model.Todo, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Get(ctx, id)
+}
+
+func (r *TenantRepository) GetAll(ctx context.Context, filter model.Filter, sorting model.Sorting, pagination model.Pagination) ([]*model.Todo, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetAll(ctx, filter, sorting, pagination)
+}
+
+func (r *TenantRepository) Count(ctx context.Context, filter model.Filter) (int, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.Count(ctx, filter)
+}
+
+func (r *TenantRepository) Update(ctx context.Context, todo *model.Todo) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Update(ctx, todo)
+}
+
+func (r *TenantRepository) Modify(ctx context.Context, id int, change func(todo *model.Todo) error) (*model.Todo, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Modify(ctx, id, change)
+}
+
+func (r *TenantRepository) Delete(ctx context.Context, id int, version int64) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.Delete(ctx, id, version)
+}
+
+func (r *TenantRepository) Tags(ctx context.Context) ([]model.TagCount, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Tags(ctx)
+}
+
+func (r *TenantRepository) RenameTags(ctx context.Context, from []string, to string) (int, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.RenameTags(ctx, from, to)
+}
+
+func (r *TenantRepository) AssignOwnerless(ctx context.Context, ownerID int) (int, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return repo.AssignOwnerless(ctx, ownerID)
+}
+
+func (r *TenantRepository) CreateProject(ctx context.Context, project *model.Project) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.CreateProject(ctx, project)
+}
+
+func (r *TenantRepository) GetProject(ctx context.Context, id int) (*model.Project, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetProject(ctx, id)
+}
+
+func (r *TenantRepository) GetProjects(ctx context.Context) ([]*model.Project, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetProjects(ctx)
+}
+
+func (r *TenantRepository) ModifyProject(ctx context.Context, id int, change func(project *model.Project) error) (*model.Project, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.ModifyProject(ctx, id, change)
+}
+
+func (r *TenantRepository) DeleteProject(ctx context.Context, id int, version int64) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.DeleteProject(ctx, id, version)
+}
+
+func (r *TenantRepository) CreateUser(ctx context.Context, user *model.User) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.CreateUser(ctx, user)
+}
+
+func (r *TenantRepository) GetUser(ctx context.Context, id int) (*model.User, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetUser(ctx, id)
+}
+
+func (r *TenantRepository) GetUserByName(ctx context.Context, name string) (*model.User, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetUserByName(ctx, name)
+}
+
+func (r *TenantRepository) CreateAPIKey(ctx context.Context, key *model.APIKey) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.CreateAPIKey(ctx, key)
+}
+
+func (r *TenantRepository) GetAPIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetAPIKeys(ctx)
+}
+
+func (r *TenantRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetAPIKeyByHash(ctx, hash)
+}
+
+func (r *TenantRepository) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.TouchAPIKey(ctx, id, usedAt)
+}
+
+func (r *TenantRepository) DeleteAPIKey(ctx context.Context, id int) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.DeleteAPIKey(ctx, id)
+}
+
+func (r *TenantRepository) CreateShare(ctx context.Context, share *model.Share) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.CreateShare(ctx, share)
+}
+
+func (r *TenantRepository) GetShare(ctx context.Context, id int) (*model.Share, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetShare(ctx, id)
+}
+
+func (r *TenantRepository) GetShares(ctx context.Context, filter model.ShareFilter) ([]*model.Share, error) {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetShares(ctx, filter)
+}
+
+func (r *TenantRepository) UpdateShare(ctx context.Context, share *model.Share) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.UpdateShare(ctx, share)
+}
+
+func (r *TenantRepository) DeleteShare(ctx context.Context, id int) error {
+	repo, err := r.repository(ctx)
+	if err != nil {
+		return err
+	}
+	return repo.DeleteShare(ctx, id)
+}
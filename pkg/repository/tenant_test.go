@@ -0,0 +1,231 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/model"
+	"github.com/yelimot/fullstack-todo-app-backend/pkg/repository"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTenantRepository(t *testing.T) {
+	stores := map[string]func(dir string) (repository.TenantStore, error){
+		"json": func(dir string) (repository.TenantStore, error) {
+			return repository.NewJSONTenantStore(dir)
+		},
+		"sqlite": func(dir string) (repository.TenantStore, error) {
+			return repository.NewSQLiteTenantStore(dir)
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			db, err := os.OpenFile(filepath.Join(dir, "db.json"), os.O_CREATE|os.O_RDWR, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			base, err := repository.NewJSONRepository(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store, err := newStore(filepath.Join(dir, "tenants"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			testTenants(t, repository.NewTenantRepository(base, store))
+		})
+	}
+}
+
+// TestMongoTenantRepository runs against the MongoDB server of mongoAddr like TestMongoRepository
+func TestMongoTenantRepository(t *testing.T) {
+	addr := mongoAddr(t)
+
+	database := fmt.Sprintf("todo_test_%d", time.Now().UnixNano())
+	admin := connect(t, addr)
+	t.Cleanup(func() {
+		ctx := context.Background()
+		names, _ := admin.ListDatabaseNames(ctx, bson.M{"name": bson.M{"$regex": "^" + database}})
+		for _, name := range names {
+			admin.Database(name).Drop(ctx)
+		}
+		admin.Disconnect(ctx)
+	})
+
+	client := connect(t, addr)
+	base, err := repository.NewMongoRepository(client, database, "todos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := repository.NewMongoTenantStore(client, database)
+
+	// the database of a tenant is named after the default one, which leaves less than 63 characters for the id
+	long := strings.Repeat("a", 63-len(database))
+	if _, err := store.Create(context.Background(), long); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("create a tenant whose database name is too long: want ErrValidation, got %v", err)
+	}
+	testTenants(t, repository.NewTenantRepository(base, store))
+}
+
+func testTenants(t *testing.T, repo *repository.TenantRepository) {
+	defer repo.Shutdown(context.Background())
+
+	def := context.Background()
+	acme := repository.WithTenant(def, "acme")
+	globex := repository.WithTenant(def, "globex")
+
+	if _, err := repo.Get(acme, 1); !errors.Is(err, repository.ErrTenantNotFound) {
+		t.Fatalf("get in a missing tenant: want ErrTenantNotFound, got %v", err)
+	}
+	for _, tenant := range []string{"acme", "globex"} {
+		if err := repo.CreateTenant(def, tenant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.CreateTenant(def, "acme"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("create an existing tenant: want ErrConflict, got %v", err)
+	}
+	if err := repo.CreateTenant(def, "Not_A_Label"); !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("create an invalid tenant: want ErrValidation, got %v", err)
+	}
+	tenants, err := repo.Tenants(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"acme", "globex"}; !reflect.DeepEqual(tenants, want) {
+		t.Errorf("tenants: want %v, got %v", want, tenants)
+	}
+
+	// every tenant sees only its own todos
+	ids := map[string]int{}
+	for _, ctx := range []context.Context{def, acme, globex} {
+		todo := &model.Todo{Title: repository.Tenant(ctx) + " todo", Status: model.StatusOpen}
+		if err := repo.Create(ctx, todo); err != nil {
+			t.Fatal(err)
+		}
+		ids[repository.Tenant(ctx)] = todo.ID
+	}
+	if _, err := repo.Get(globex, ids["acme"]); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("get the todo of another tenant: want ErrNotFound, got %v", err)
+	}
+	for _, ctx := range []context.Context{def, acme, globex} {
+		todos, err := repo.GetAll(ctx, model.Filter{}, model.Sorting{}, model.Pagination{Page: 1, Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := repository.Tenant(ctx) + " todo"; len(todos) != 1 || todos[0].Title != want {
+			t.Errorf("tenant %q: want only %q, got %v", repository.Tenant(ctx), want, todos)
+		}
+	}
+
+	if err := repo.DeleteTenant(def, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(acme, ids["acme"]); !errors.Is(err, repository.ErrTenantNotFound) {
+		t.Errorf("get in a deleted tenant: want ErrTenantNotFound, got %v", err)
+	}
+	if err := repo.DeleteTenant(def, "acme"); !errors.Is(err, repository.ErrTenantNotFound) {
+		t.Errorf("delete a deleted tenant: want ErrTenantNotFound, got %v", err)
+	}
+	if _, err := repo.Get(globex, ids["globex"]); err != nil {
+		t.Errorf("the other tenant must be kept: %v", err)
+	}
+}
+
+// blockingStore counts the tenants opened and keeps them opening until release is closed
+type blockingStore struct {
+	repository.TenantStore
+	opens   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Open(ctx context.Context, tenant string) (repository.Repository, error) {
+	if s.opens.Add(1) == 1 {
+		close(s.started)
+	}
+	<-s.release
+	return s.TenantStore.Open(ctx, tenant)
+}
+
+func TestTenantRepositoryOpening(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db, err := os.OpenFile(filepath.Join(dir, "db.json"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := repository.NewJSONRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonStore, err := repository.NewJSONTenantStore(filepath.Join(dir, "tenants"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// acme exists but is not open yet
+	acmeRepo, err := jsonStore.Create(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acmeRepo.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store := &blockingStore{TenantStore: jsonStore, started: make(chan struct{}), release: make(chan struct{})}
+	repo := repository.NewTenantRepository(base, store)
+	defer repo.Shutdown(ctx)
+	globex := repository.WithTenant(ctx, "globex")
+	if err := repo.CreateTenant(ctx, "globex"); err != nil {
+		t.Fatal(err)
+	}
+
+	acme := repository.WithTenant(ctx, "acme")
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := repo.Get(acme, 1)
+			errs <- err
+		}()
+	}
+	<-store.started
+
+	// other tenants and requests that give up do not wait for the tenant being opened
+	done := make(chan error, 1)
+	go func() {
+		_, err := repo.Get(globex, 1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("get in an open tenant: want ErrNotFound, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("an open tenant waits for another tenant being opened")
+	}
+	canceled, cancel := context.WithCancel(acme)
+	cancel()
+	if _, err := repo.Get(canceled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("get with a canceled context: want context.Canceled, got %v", err)
+	}
+
+	// the tenant is opened once for all requests waiting for it
+	close(store.release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; !errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrTenantNotFound) {
+			t.Errorf("get in the opened tenant: want ErrNotFound, got %v", err)
+		}
+	}
+	if n := store.opens.Load(); n != 1 {
+		t.Errorf("tenant opened %d times, want once", n)
+	}
+}
@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JSONTenantStore keeps the db file of each tenant in a directory, named after the tenant
+type JSONTenantStore struct {
+	dir string
+}
+
+var _ TenantStore = (*JSONTenantStore)(nil)
+
+// NewJSONTenantStore returns a store keeping the db files of the tenants in dir, which is created if needed
+func NewJSONTenantStore(dir string) (*JSONTenantStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &JSONTenantStore{dir: dir}, nil
+}
+
+func (s *JSONTenantStore) path(tenant string) string {
+	return filepath.Join(s.dir, tenant+".json")
+}
+
+func (s *JSONTenantStore) Tenants(ctx context.Context) ([]string, error) {
+	return tenantFiles(s.dir, ".json")
+}
+
+func (s *JSONTenantStore) Create(ctx context.Context, tenant string) (Repository, error) {
+	db, err := os.OpenFile(s.path(tenant), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONRepository(db)
+}
+
+func (s *JSONTenantStore) Open(ctx context.Context, tenant string) (Repository, error) {
+	db, err := os.OpenFile(s.path(tenant), os.O_RDWR, 0600)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONRepository(db)
+}
+
+func (s *JSONTenantStore) Delete(ctx context.Context, tenant string) error {
+	path := s.path(tenant)
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrTenantNotFound
+		}
+		return err
+	}
+	removeTempFiles(path)
+	if err := os.Remove(walPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SQLiteTenantStore keeps the database file of each tenant in a directory, named after the tenant
+type SQLiteTenantStore struct {
+	dir string
+}
+
+var _ TenantStore = (*SQLiteTenantStore)(nil)
+
+// NewSQLiteTenantStore returns a store keeping the databases of the tenants in dir, which is created if needed
+func NewSQLiteTenantStore(dir string) (*SQLiteTenantStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &SQLiteTenantStore{dir: dir}, nil
+}
+
+func (s *SQLiteTenantStore) path(tenant string) string {
+	return filepath.Join(s.dir, tenant+".db")
+}
+
+func (s *SQLiteTenantStore) Tenants(ctx context.Context) ([]string, error) {
+	return tenantFiles(s.dir, ".db")
+}
+
+func (s *SQLiteTenantStore) Create(ctx context.Context, tenant string) (Repository, error) {
+	// create the file exclusively, so that two requests cannot both create the tenant
+	f, err := os.OpenFile(s.path(tenant), os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return s.open(tenant)
+}
+
+func (s *SQLiteTenantStore) Open(ctx context.Context, tenant string) (Repository, error) {
+	if _, err := os.Stat(s.path(tenant)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrTenantNotFound
+		}
+		return nil, err
+	}
+	return s.open(tenant)
+}
+
+func (s *SQLiteTenantStore) open(tenant string) (Repository, error) {
+	db, err := sql.Open("sqlite", s.path(tenant))
+	if err != nil {
+		return nil, err
+	}
+	repo, err := NewSQLiteRepository(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return repo, nil
+}
+
+func (s *SQLiteTenantStore) Delete(ctx context.Context, tenant string) error {
+	path := s.path(tenant)
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrTenantNotFound
+		}
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// tenantFiles lists the tenants with a file of the given extension in dir
+func tenantFiles(dir, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	tenants := []string{}
+	for _, entry := range entries {
+		tenant, ok := strings.CutSuffix(entry.Name(), ext)
+		if ok && entry.Type().IsRegular() && ValidateTenant(tenant) == nil {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// maxMongoDatabaseLength is the longest database name MongoDB accepts
+const maxMongoDatabaseLength = 63
+
+// MongoTenantStore keeps each tenant in its own database, named after the database of the default workspace
+// and the tenant, e.g. todo_acme
+type MongoTenantStore struct {
+	client   *mongo.Client
+	database string
+}
+
+var _ TenantStore = (*MongoTenantStore)(nil)
+
+// NewMongoTenantStore returns a store keeping the tenants in databases of client prefixed with database
+func NewMongoTenantStore(client *mongo.Client, database string) *MongoTenantStore {
+	return &MongoTenantStore{client: client, database: database}
+}
+
+func (s *MongoTenantStore) name(tenant string) string {
+	return s.database + "_" + tenant
+}
+
+// validName checks that the database of tenant is within the limit of MongoDB, which leaves the tenant id
+// maxMongoDatabaseLength-len(s.database)-1 bytes
+func (s *MongoTenantStore) validName(tenant string) error {
+	if limit := maxMongoDatabaseLength - len(s.database) - 1; len(tenant) > limit {
+		return fmt.Errorf("%w: the tenant id must be at most %d characters long", ErrValidation, limit)
+	}
+	return nil
+}
+
+func (s *MongoTenantStore) Tenants(ctx context.Context) ([]string, error) {
+	prefix := s.database + "_"
+	names, err := s.client.ListDatabaseNames(ctx, bson.M{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}})
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	tenants := []string{}
+	for _, name := range names {
+		if tenant := strings.TrimPrefix(name, prefix); ValidateTenant(tenant) == nil {
+			tenants = append(tenants, tenant)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// exists reports whether the database of tenant exists. MongoDB creates databases on the first write,
+// so a tenant exists once its indexes are created.
+func (s *MongoTenantStore) exists(ctx context.Context, tenant string) (bool, error) {
+	names, err := s.client.ListDatabaseNames(ctx, bson.M{"name": s.name(tenant)})
+	if err != nil {
+		return false, mongoError(err)
+	}
+	return len(names) > 0, nil
+}
+
+func (s *MongoTenantStore) Create(ctx context.Context, tenant string) (Repository, error) {
+	if err := s.validName(tenant); err != nil {
+		return nil, err
+	}
+	exists, err := s.exists(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrConflict
+	}
+	return s.open(tenant)
+}
+
+func (s *MongoTenantStore) Open(ctx context.Context, tenant string) (Repository, error) {
+	if s.validName(tenant) != nil {
+		return nil, ErrTenantNotFound
+	}
+	exists, err := s.exists(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrTenantNotFound
+	}
+	return s.open(tenant)
+}
+
+func (s *MongoTenantStore) open(tenant string) (Repository, error) {
+	repo, err := NewMongoRepository(s.client, s.name(tenant), "todos")
+	if err != nil {
+		return nil, err
+	}
+	// the client is shared by every tenant and disconnected with the default workspace
+	repo.sharedClient = true
+	return repo, nil
+}
+
+func (s *MongoTenantStore) Delete(ctx context.Context, tenant string) error {
+	exists, err := s.exists(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrTenantNotFound
+	}
+	return mongoError(s.client.Database(s.name(tenant)).Drop(ctx))
+}